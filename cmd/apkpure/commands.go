@@ -0,0 +1,419 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// command is one apkpure subcommand
+type command struct {
+	name string
+	// usage is the synopsis after "apkpure", e.g. "sync [flags] OUTDIR"
+	usage string
+	// summary is the one-line description in the command list
+	summary string
+	// help is the longer description shown by "apkpure help NAME"
+	help string
+	// setup defines the command's flags on fs and returns the function
+	// that runs it with the positional arguments
+	setup func(fs *flag.FlagSet) func(args []string)
+}
+
+// commands lists the subcommands in the order help shows them. It is
+// filled in by init because help and completion refer back to it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "download",
+			usage:   "download [flags] OUTDIR",
+			summary: "Download apps into a directory",
+			help: `Downloads the apps given with -a or -c into OUTDIR. With --all-versions
+every listed version is downloaded instead of the newest match.`,
+			setup: downloadCommand,
+		},
+		{
+			name:    "list",
+			usage:   "list [flags]",
+			summary: "List the available versions of apps",
+			help:    `Lists the versions APKPure offers for the apps given with -a or -c.`,
+			setup:   listCommand,
+		},
+		{
+			name:    "info",
+			usage:   "info [flags] PACKAGE[@VERSION][#VERSIONCODE]",
+			summary: "Show the store listing of an app or details of a version",
+			help: `Shows the title, developer, category, rating, description, icon and
+latest version of PACKAGE. With @VERSION or #VERSIONCODE it shows the
+build download would fetch instead: its version, type, size, release
+date, SDK levels, ABIs and digest.`,
+			setup: infoCommand,
+		},
+		{
+			name:    "search",
+			usage:   "search [flags] QUERY...",
+			summary: "Search apps by keyword",
+			help: `Searches APKPure for apps matching QUERY. Results are printed as CSV
+lines of package ID, latest version, title and developer, which
+download -c - reads from a pipe:
+  apkpure search whatsapp | apkpure download -c - OUTDIR`,
+			setup: searchCommand,
+		},
+		{
+			name:    "inspect",
+			usage:   "inspect [flags] FILE...",
+			summary: "Show the manifest, signature and digests of local files",
+			help: `Decodes the manifest and signature of each APK or XAPK and prints them
+with the file's digests. A bad manifest or signature is reported, not
+treated as an error.`,
+			setup: inspectCommand,
+		},
+		{
+			name:    "verify",
+			usage:   "verify [flags] FILE...",
+			summary: "Check local files against checksums, pins and manifests",
+			help: `Verifies the signature of each APK or XAPK and, when given, its checksum,
+signer certificate pin, package name and versionCode. Exits with 7 if
+any file fails.`,
+			setup: verifyCommand,
+		},
+		{
+			name:    "unpack",
+			usage:   "unpack [flags] FILE.xapk [OUTDIR]",
+			summary: "Extract the APKs and OBB files of an XAPK",
+			help: `Extracts the base APK, split APKs and OBB files of an XAPK.
+OUTDIR defaults to FILE without the .xapk extension.`,
+			setup: unpackCommand,
+		},
+		{
+			name:    "sync",
+			usage:   "sync [flags] OUTDIR",
+			summary: "Keep a mirror directory up to date",
+			help: `Downloads builds of the tracked apps that are not yet in the mirror,
+prunes builds outside the retention rules and prints what changed.
+Apps given with -a or -c are added to the tracked set; without them
+every app in the state file is synced.`,
+			setup: syncCommand,
+		},
+		{
+			name:    "lock",
+			usage:   "lock [flags]",
+			summary: "Pin apps to exact builds in " + apkpure.LockfileName,
+			help: `Resolves the apps given with -a or -c to their current builds and pins
+each to its versionCode, asset type and SHA-256 in the lockfile. Apps
+already in the lockfile keep their pins; --update resolves them again,
+or every locked app when no -a or -c is given.`,
+			setup: lockCommand,
+		},
+		{
+			name:    "fetch",
+			usage:   "fetch [flags] OUTDIR",
+			summary: "Download the builds pinned in " + apkpure.LockfileName,
+			help: `Downloads the builds pinned in the lockfile, skipping files already in
+OUTDIR that match their pinned SHA-256. A build that is gone or whose
+type or hash changed is an error; with --update-lock such apps are
+resolved again and the lockfile is updated.`,
+			setup: fetchCommand,
+		},
+		{
+			name:    "index",
+			usage:   "index [flags] DIR",
+			summary: "Publish a directory of APKs as an F-Droid repository",
+			help: `Writes an F-Droid repository index (index-v1.json, index-v2.json and
+entry.json) for the APKs in DIR. With -key and -cert the index is also
+signed into index-v1.jar and entry.jar.`,
+			setup: indexCommand,
+		},
+		{
+			name:    "store",
+			usage:   "store [flags] ls|gc STOREDIR",
+			summary: "List or garbage-collect a content-addressed store",
+			help: `ls lists the builds in a content-addressed store (-o store=STOREDIR);
+gc deletes blobs that no build refers to.`,
+			setup: storeCommand,
+		},
+		{
+			name:    "serve",
+			usage:   "serve [flags] OUTDIR",
+			summary: "Serve versions, app info and downloads over HTTP",
+			help: `Runs an HTTP API for other services. Downloads are stored in OUTDIR and
+shared between concurrent requests for the same build; -r bounds the
+requests to APKPure across all callers.
+  GET  /v1/apps/PACKAGE           store listing
+  GET  /v1/apps/PACKAGE/versions  available versions
+  GET  /v1/download?spec=SPEC     download a build and stream it back
+  POST /v1/jobs {"spec": SPEC}    start a download in the background
+  GET  /v1/jobs[/ID]              job status
+  GET  /v1/jobs/ID/file           file of a finished job
+SPEC is PACKAGE[@VERSION][#VERSIONCODE], as for -a.`,
+			setup: serveCommand,
+		},
+		{
+			name:    "proxy",
+			usage:   "proxy [flags] CACHEDIR",
+			summary: "Run a caching APK proxy",
+			help: `Serves builds to a fleet of machines from a local cache. Each build is
+downloaded from APKPure once and served from CACHEDIR afterwards, with
+ETag and Range support; with --max-size the least recently used builds
+are deleted to stay within the limit.
+  GET /v1/apk/SPEC  the build of SPEC, e.g. com.example.app@1.2.3
+  GET /v1/stats     cache and traffic counters
+SPEC is PACKAGE[@VERSION][#VERSIONCODE], with # written as %23.`,
+			setup: proxyCommand,
+		},
+		{
+			name:    "completion",
+			usage:   "completion bash|zsh|fish",
+			summary: "Print a shell completion script",
+			help: `Prints a completion script for the shell. For example:
+  source <(apkpure completion bash)
+  apkpure completion zsh > "${fpath[1]}/_apkpure"
+  apkpure completion fish > ~/.config/fish/completions/apkpure.fish`,
+			setup: completionCommand,
+		},
+		{
+			name:    "help",
+			usage:   "help [COMMAND]",
+			summary: "Show help for a command",
+			help:    `Shows the commands, or the flags of COMMAND.`,
+			setup:   helpCommand,
+		},
+	}
+}
+
+// legacyCommand runs invocations without a command name, e.g.
+// "apkpure -a APP OUTDIR" and "apkpure -l -a APP", as before subcommands
+var legacyCommand = &command{
+	usage: "[-l] [flags] [OUTDIR]",
+	help: `Without a command, apkpure downloads like "download", or lists versions
+like "list" when -l is given.`,
+	setup: legacySetup,
+}
+
+// findCommand returns the command called name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// globalFlags are the flags accepted before or after any command
+type globalFlags struct {
+	config  string
+	profile string
+	format  string
+}
+
+// globalFlagNames are the names extractGlobalFlags recognizes
+var globalFlagNames = []string{"config", "profile", "format"}
+
+// globalUsage describes the global flags in help output
+const globalUsage = `Global flags:
+  --config FILE    Config file (default: ./apkpure.toml, then the user config directory)
+  --profile NAME   Config file profile to use
+  --format FORMAT  Output format, plaintext or json; overrides output_format
+`
+
+// newFlagSet creates the flag set of cmd with its usage message
+func newFlagSet(cmd *command) (*flag.FlagSet, func(args []string)) {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	run := cmd.setup(fs)
+	fs.Usage = func() { printCommandUsage(fs.Output(), cmd, fs) }
+	return fs, run
+}
+
+// runCommand parses args with the flags of cmd and runs it
+func runCommand(cmd *command, args []string) {
+	fs, run := newFlagSet(cmd)
+	_ = fs.Parse(args)
+	run(fs.Args())
+}
+
+// printUsage writes the command list
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: apkpure [global flags] COMMAND [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	_ = tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprint(w, globalUsage)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Invocations without a command still work:")
+	fmt.Fprintln(w, "  apkpure -a APP OUTDIR    same as: apkpure download -a APP OUTDIR")
+	fmt.Fprintln(w, "  apkpure -l -a APP        same as: apkpure list -a APP")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "apkpure help COMMAND" for the flags of a command.`)
+}
+
+// printCommandUsage writes the synopsis, description and flags of cmd
+func printCommandUsage(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: apkpure %s\n\n", cmd.usage)
+	fmt.Fprintf(w, "%s\n", cmd.help)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Flags:")
+		fs.PrintDefaults()
+	}
+	fmt.Fprintln(w)
+	fmt.Fprint(w, globalUsage)
+}
+
+// exitUsageError reports a command line mistake and the usage of the
+// command, then exits with exitUsage
+func exitUsageError(fs *flag.FlagSet, format string, a ...any) {
+	if format != "" {
+		fmt.Fprintf(fs.Output(), "Error: %s\n\n", fmt.Sprintf(format, a...))
+	}
+	fs.Usage()
+	os.Exit(exitUsage)
+}
+
+// helpCommand implements "apkpure help [COMMAND]"
+func helpCommand(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		if len(args) == 0 {
+			printUsage(os.Stdout)
+			return
+		}
+		if len(args) > 1 {
+			exitUsageError(fs, "expected at most one command")
+		}
+		cmd := findCommand(args[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", args[0])
+			printUsage(os.Stderr)
+			os.Exit(exitUsage)
+		}
+		cmdFlags, _ := newFlagSet(cmd)
+		cmdFlags.SetOutput(os.Stdout)
+		cmdFlags.Usage()
+	}
+}
+
+// appFlags select the apps a command works on, from -a or a CSV file
+type appFlags struct {
+	app    string
+	csv    string
+	fields csvFields
+}
+
+// register defines -a, -c, -f and -v on fs
+func (f *appFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.app, "a", "", "App ID (e.g., com.instagram.android, com.instagram.android@1.2.3 or com.instagram.android#123456)")
+	fs.StringVar(&f.app, "app", "", "App ID (alias for -a)")
+	fs.StringVar(&f.csv, "c", "", "CSV file containing app IDs (- for standard input)")
+	fs.StringVar(&f.csv, "csv", "", "CSV file containing app IDs (alias for -c)")
+	fs.IntVar(&f.fields.app, "f", 1, "CSV field number containing app IDs")
+	fs.IntVar(&f.fields.app, "field", 1, "CSV field number (alias for -f)")
+	fs.IntVar(&f.fields.version, "v", 0, "CSV field number containing versions or version constraints")
+	fs.IntVar(&f.fields.version, "version-field", 0, "CSV field number for versions (alias for -v)")
+}
+
+// registerBuildFields defines -n and -k, the CSV columns that pin a
+// build by versionCode and checksum
+func (f *appFlags) registerBuildFields(fs *flag.FlagSet) {
+	fs.IntVar(&f.fields.versionCode, "n", 0, "CSV field number containing versionCodes")
+	fs.IntVar(&f.fields.versionCode, "version-code-field", 0, "CSV field number for versionCodes (alias for -n)")
+	fs.IntVar(&f.fields.checksum, "k", 0, "CSV field number containing expected checksums (e.g., sha256=<hex>)")
+	fs.IntVar(&f.fields.checksum, "checksum-field", 0, "CSV field number for checksums (alias for -k)")
+}
+
+// given reports whether -a or -c was used
+func (f *appFlags) given() bool {
+	return f.app != "" || f.csv != ""
+}
+
+// apps parses the apps from -a or -c, exiting on errors. It returns nil
+// when neither was given.
+func (f *appFlags) apps() []apkpure.AppInfo {
+	var apps []apkpure.AppInfo
+	var err error
+	if f.app != "" {
+		apps, err = parseAppID(f.app)
+	} else if f.csv != "" {
+		apps, err = parseCSVFile(f.csv, f.fields)
+	}
+	if err != nil {
+		fmt.Printf("Error parsing apps: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	return apps
+}
+
+// requiredApps is like apps but insists on at least one app
+func (f *appFlags) requiredApps(fs *flag.FlagSet) []apkpure.AppInfo {
+	if !f.given() {
+		exitUsageError(fs, "either -a/--app or -c/--csv must be specified")
+	}
+	apps := f.apps()
+	if len(apps) == 0 {
+		fmt.Println("Error: No apps to process")
+		os.Exit(exitFailure)
+	}
+	return apps
+}
+
+// optionFlags are -o and the flags that override single options
+type optionFlags struct {
+	options  string
+	parallel int
+	sleep    int64
+	pinFile  string
+}
+
+// register defines -o on fs
+func (f *optionFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.options, "o", "", "Additional options (e.g., arch=arm64-v8a,language=en-US,output_format=json)")
+	fs.StringVar(&f.options, "options", "", "Additional options (alias for -o)")
+}
+
+// registerDownload defines -r, -s and -p, for commands that download
+func (f *optionFlags) registerDownload(fs *flag.FlagSet) {
+	fs.IntVar(&f.parallel, "r", 4, "Number of parallel downloads")
+	fs.IntVar(&f.parallel, "parallel", 4, "Number of parallel downloads (alias for -r)")
+	fs.Int64Var(&f.sleep, "s", 0, "Sleep duration between downloads in milliseconds")
+	fs.Int64Var(&f.sleep, "sleep-duration", 0, "Sleep duration (alias for -s)")
+	fs.StringVar(&f.pinFile, "p", "", "CSV file of pinned signer certificates (package,sha256)")
+	fs.StringVar(&f.pinFile, "pins", "", "CSV file of pinned signer certificates (alias for -p)")
+}
+
+// settings builds the options for a command: the config file, -o and
+// then the flags given explicitly. It exits on invalid options.
+func (f *optionFlags) settings(fs *flag.FlagSet) settings {
+	opts := parseOptions(f.options)
+	if flagWasSet(fs, "r", "parallel") {
+		opts.Parallel = f.parallel
+	}
+	if flagWasSet(fs, "s", "sleep-duration") {
+		opts.SleepDuration = time.Duration(f.sleep) * time.Millisecond
+	}
+	if f.pinFile != "" {
+		pins, err := parsePinFile(f.pinFile)
+		if err != nil {
+			fmt.Printf("Error parsing pins: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		opts.PinnedCertificates = pins
+	}
+	if opts.OutputFormat != "" && opts.OutputFormat != apkpure.OutputPlaintext && opts.OutputFormat != apkpure.OutputJSON {
+		fmt.Printf("Error: unsupported output format: %s\n", opts.OutputFormat)
+		os.Exit(exitUsage)
+	}
+	return opts
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newStubAPI serves one version of com.example and returns its base URL
+func newStubAPI(t *testing.T) string {
+	t.Helper()
+	var server *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v3/get_app_his_version", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("package_name") != "com.example" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"version_list": []any{map[string]any{
+			"version_name": "1.0",
+			"version_code": "10",
+			"asset":        map[string]any{"url": server.URL + "/files/com.example/10", "type": "APK", "size": 4},
+		}}})
+	})
+	mux.HandleFunc("GET /files/com.example/10", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "apk!")
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// useStubAPI points the options of the commands run by the test at
+// baseURL
+func useStubAPI(t *testing.T, baseURL string) {
+	t.Helper()
+	useSettings(t, []setting{
+		{key: "api_base_url", value: baseURL, source: "test"},
+		{key: "verify_manifest", value: "false", source: "test"},
+		{key: "retries", value: "1", source: "test"},
+	}, nil)
+}
+
+// captureStdout runs fn and returns what it printed to standard output
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+	fn()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExtractGlobalFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		rest    []string
+		globals globalFlags
+	}{
+		{
+			args: []string{"download", "-a", "com.example", "out"},
+			rest: []string{"download", "-a", "com.example", "out"},
+		},
+		{
+			args:    []string{"--config", "a.toml", "list", "-a", "com.example"},
+			rest:    []string{"list", "-a", "com.example"},
+			globals: globalFlags{config: "a.toml"},
+		},
+		{
+			args:    []string{"list", "-a", "com.example", "--format=json", "-profile", "ci"},
+			rest:    []string{"list", "-a", "com.example"},
+			globals: globalFlags{profile: "ci", format: "json"},
+		},
+		{
+			args:    []string{"-l", "-format", "json", "-a", "com.example"},
+			rest:    []string{"-l", "-a", "com.example"},
+			globals: globalFlags{format: "json"},
+		},
+		{
+			// Only exact flag names are global
+			args: []string{"download", "--formats", "x", "-o", "output_format=json", "format"},
+			rest: []string{"download", "--formats", "x", "-o", "output_format=json", "format"},
+		},
+		{
+			args:    []string{"list", "--profile"},
+			rest:    []string{"list"},
+			globals: globalFlags{},
+		},
+	}
+	for _, tt := range tests {
+		rest, globals := extractGlobalFlags(tt.args)
+		if !slices.Equal(rest, tt.rest) || globals != tt.globals {
+			t.Errorf("extractGlobalFlags(%q) = %q, %+v; want %q, %+v", tt.args, rest, globals, tt.rest, tt.globals)
+		}
+	}
+}
+
+// flagDefaults maps the flags of a command to their defaults
+func flagDefaults(cmd *command) map[string]string {
+	fs, _ := newFlagSet(cmd)
+	defaults := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { defaults[f.Name] = f.DefValue })
+	return defaults
+}
+
+func TestLegacyFlags(t *testing.T) {
+	legacy := flagDefaults(legacyCommand)
+	for _, name := range []string{"download", "list"} {
+		for flagName, def := range flagDefaults(findCommand(name)) {
+			if got, ok := legacy[flagName]; !ok || got != def {
+				t.Errorf("legacy -%s = %q, %t; want %q as in %s", flagName, got, ok, def, name)
+			}
+		}
+	}
+	for _, flagName := range []string{"l", "list-versions"} {
+		if _, ok := legacy[flagName]; !ok {
+			t.Errorf("legacy flags lack -%s", flagName)
+		}
+	}
+}
+
+func TestLegacyInvocation(t *testing.T) {
+	useStubAPI(t, newStubAPI(t))
+
+	t.Run("list", func(t *testing.T) {
+		want := captureStdout(t, func() { runCommand(findCommand("list"), []string{"-a", "com.example"}) })
+		for _, args := range [][]string{
+			{"-l", "-a", "com.example"},
+			{"-a", "com.example", "--list-versions"},
+		} {
+			got := captureStdout(t, func() { runCommand(legacyCommand, args) })
+			if got != want {
+				t.Errorf("%q printed %q, want %q as from list", args, got, want)
+			}
+		}
+		if !strings.Contains(want, "| 1.0 (10)") {
+			t.Errorf("list printed %q, want version 1.0 (10)", want)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		dir := t.TempDir()
+		csvFile := filepath.Join(dir, "apps.csv")
+		if err := os.WriteFile(csvFile, []byte("name,com.example\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for i, args := range [][]string{
+			{"-a", "com.example@1.0"},
+			{"-c", csvFile, "-f", "2", "-r", "1"},
+		} {
+			out := filepath.Join(dir, string(rune('a'+i)))
+			if err := os.Mkdir(out, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			captureStdout(t, func() { runCommand(legacyCommand, append(args, out)) })
+
+			data, err := os.ReadFile(filepath.Join(out, "com.example@1.0_10.apk"))
+			if err != nil || string(data) != "apk!" {
+				t.Errorf("%q: downloaded %q, %v", args, data, err)
+			}
+		}
+	})
+}
+
+func TestParseCSVFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "apps.csv")
+	content := "com.example, >=1.2 ,15\n\n ,ignored,\norg.other,,\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	apps, err := parseCSVFile(file, csvFields{app: 1, version: 2, versionCode: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, app := range apps {
+		got = append(got, app.PackageID+"|"+app.Version+"|"+app.VersionCode)
+	}
+	want := []string{"com.example|>=1.2|15", "org.other||"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCSVFile = %q, want %q", got, want)
+	}
+
+	for _, fields := range []csvFields{{app: 0}, {app: 1, version: 1}, {app: 1, checksum: -1}} {
+		if _, err := parseCSVFile(file, fields); err == nil {
+			t.Errorf("fields %+v were accepted", fields)
+		}
+	}
+}
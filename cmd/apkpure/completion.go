@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// completionFlag is a command flag as the completion scripts describe it
+type completionFlag struct {
+	// name is the flag as typed, e.g. "-a" or "--app"
+	name        string
+	description string
+	// takesValue is false for boolean flags
+	takesValue bool
+}
+
+// completionCommand implements "apkpure completion bash|zsh|fish"
+func completionCommand(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "expected one of bash, zsh or fish")
+		}
+		var err error
+		switch args[0] {
+		case "bash":
+			err = writeBashCompletion(os.Stdout)
+		case "zsh":
+			err = writeZshCompletion(os.Stdout)
+		case "fish":
+			err = writeFishCompletion(os.Stdout)
+		default:
+			exitUsageError(fs, "unsupported shell %q", args[0])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// commandFlags returns the flags of cmd in lexical order
+func commandFlags(cmd *command) []completionFlag {
+	fs, _ := newFlagSet(cmd)
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "--" + f.Name
+		if len(f.Name) == 1 {
+			name = "-" + f.Name
+		}
+		description, _, _ := strings.Cut(f.Usage, " (")
+		flags = append(flags, completionFlag{
+			name:        name,
+			description: description,
+			takesValue:  !isBoolFlag(f),
+		})
+	})
+	return flags
+}
+
+// isBoolFlag reports whether f needs no value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// commandArgs returns the fixed words a command takes as its first
+// argument, or nil when its arguments are files
+func commandArgs(cmd *command) []string {
+	switch cmd.name {
+	case "completion":
+		return []string{"bash", "zsh", "fish"}
+	case "store":
+		return []string{"ls", "gc"}
+	case "help":
+		names := make([]string, len(commands))
+		for i, c := range commands {
+			names[i] = c.name
+		}
+		return names
+	default:
+		return nil
+	}
+}
+
+// flagNames returns the names of flags separated by spaces
+func flagNames(flags []completionFlag) string {
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = f.name
+	}
+	return strings.Join(names, " ")
+}
+
+// shellQuote quotes s for bash and zsh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeBashCompletion writes a bash completion script
+func writeBashCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(`# bash completion for apkpure
+_apkpure() {
+    local cur prev cmd i words flags
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    cmd=""
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            --config|--profile|--format) ((i++)) ;;
+            -*) ;;
+            *) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+
+    case "$prev" in
+        --format) COMPREPLY=($(compgen -W "plaintext json" -- "$cur")); return ;;
+        --profile) return ;;
+    esac
+
+    case "$cmd" in
+`)
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.name
+		fmt.Fprintf(&b, "        %s)\n", cmd.name)
+		fmt.Fprintf(&b, "            flags=%s\n", shellQuote(flagNames(commandFlags(cmd))))
+		fmt.Fprintf(&b, "            words=%s\n", shellQuote(strings.Join(commandArgs(cmd), " ")))
+		b.WriteString("            ;;\n")
+	}
+	fmt.Fprintf(&b, `        *)
+            flags=%s
+            words=%s
+            ;;
+    esac
+`, shellQuote(flagNames(commandFlags(legacyCommand))), shellQuote(strings.Join(names, " ")))
+	b.WriteString(`
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags --config --profile --format" -- "$cur"))
+    elif [[ -n "$words" && ( -z "$cmd" || "$prev" == "$cmd" ) ]]; then
+        COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -o filenames -F _apkpure apkpure
+`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// zshEscape escapes the characters _arguments treats specially in a
+// flag description
+func zshEscape(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+// writeZshCompletion writes a zsh completion script
+func writeZshCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(`#compdef apkpure
+
+_apkpure() {
+  local -a commands global_flags
+  global_flags=(
+    '--config[Config file]:file:_files'
+    '--profile[Config file profile]:profile:'
+    '--format[Output format]:format:(plaintext json)'
+  )
+  commands=(
+`)
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "    %s\n", shellQuote(cmd.name+":"+zshEscape(cmd.summary)))
+	}
+	b.WriteString(`  )
+
+  if (( CURRENT == 2 )) && [[ ${words[CURRENT]} != -* ]]; then
+    _describe -t commands 'apkpure command' commands
+    return
+  fi
+
+  local cmd=${words[2]}
+  if [[ $cmd != -* ]]; then
+    shift words
+    (( CURRENT-- ))
+  else
+    cmd=""
+  fi
+
+  case $cmd in
+`)
+	// Without a command the legacy flags apply
+	for _, cmd := range slices.Concat(commands, []*command{legacyCommand}) {
+		pattern := cmd.name
+		if cmd == legacyCommand {
+			pattern = "*"
+		}
+		fmt.Fprintf(&b, "    %s)\n", pattern)
+		b.WriteString("      _arguments -s $global_flags")
+		for _, f := range commandFlags(cmd) {
+			spec := f.name + "[" + zshEscape(f.description) + "]"
+			if f.takesValue {
+				spec += ":value:_files"
+			}
+			fmt.Fprintf(&b, " \\\n        %s", shellQuote(spec))
+		}
+		if words := commandArgs(cmd); words != nil {
+			fmt.Fprintf(&b, " \\\n        %s", shellQuote("1:argument:("+strings.Join(words, " ")+")"))
+		}
+		b.WriteString(" \\\n        '*:file:_files'\n      ;;\n")
+	}
+	b.WriteString(`  esac
+}
+
+_apkpure "$@"
+`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fishQuote quotes s for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// writeFishCompletion writes a fish completion script
+func writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(`# fish completion for apkpure
+complete -c apkpure -l config -r -F -d 'Config file'
+complete -c apkpure -l profile -x -d 'Config file profile'
+complete -c apkpure -l format -x -a 'plaintext json' -d 'Output format'
+`)
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c apkpure -n __fish_use_subcommand -f -a %s -d %s\n", cmd.name, fishQuote(cmd.summary))
+	}
+	// Without a command the legacy flags apply
+	for _, cmd := range slices.Concat(commands, []*command{legacyCommand}) {
+		condition := fishQuote("__fish_seen_subcommand_from " + cmd.name)
+		if cmd == legacyCommand {
+			condition = "__fish_use_subcommand"
+		}
+		for _, f := range commandFlags(cmd) {
+			option := "-l " + strings.TrimPrefix(f.name, "--")
+			if !strings.HasPrefix(f.name, "--") {
+				option = "-s " + strings.TrimPrefix(f.name, "-")
+			}
+			if f.takesValue {
+				option += " -r"
+			}
+			fmt.Fprintf(&b, "complete -c apkpure -n %s %s -d %s\n", condition, option, fishQuote(f.description))
+		}
+		if words := commandArgs(cmd); words != nil {
+			fmt.Fprintf(&b, "complete -c apkpure -n %s -x -a %s\n", condition, fishQuote(strings.Join(words, " ")))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
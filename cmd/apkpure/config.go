@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+const (
+	// configFileName is looked up in the working directory
+	configFileName = "apkpure.toml"
+	// envPrefix prefixes environment variables that override options
+	envPrefix = "APKPURE_"
+)
+
+// setting is one option value and where it came from, for error reports
+type setting struct {
+	key    string
+	value  string
+	source string
+}
+
+// config is a parsed config file
+type config struct {
+	path string
+	// profile is the profile the file selects by default
+	profile string
+	// settings are the top-level options
+	settings []setting
+	// profiles maps profile names to their options
+	profiles map[string][]setting
+}
+
+// baseSettings are the config file, profile and environment settings
+// every command starts from; -o settings are applied on top
+var baseSettings []setting
+
+// overrideSettings come from global flags such as --format and are
+// applied after -o
+var overrideSettings []setting
+
+// settings are the options a command runs with
+type settings struct {
+	apkpure.DownloadOptions
+	// outputTemplate renders each download result instead of the output
+	// format, when set
+	outputTemplate *template.Template
+}
+
+// extractGlobalFlags removes the global flags from args, wherever they
+// appear, and returns their values
+func extractGlobalFlags(args []string) (rest []string, globals globalFlags) {
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || !slices.Contains(globalFlagNames, name) {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "config":
+			globals.config = value
+		case "profile":
+			globals.profile = value
+		case "format":
+			globals.format = value
+		}
+	}
+	return rest, globals
+}
+
+// loadSettings reads the config file and environment into the base
+// settings. An explicit path must exist; otherwise apkpure.toml in the
+// working directory and then config.toml in the user config directory
+// ($XDG_CONFIG_HOME/apkpure) are tried.
+func loadSettings(path, profile string) ([]setting, error) {
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if profile == "" {
+		profile = os.Getenv(envPrefix + "PROFILE")
+	}
+
+	cfg, err := findConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var layered []setting
+	if cfg != nil {
+		layered = append(layered, cfg.settings...)
+		if profile == "" {
+			profile = cfg.profile
+		}
+	}
+	if profile != "" {
+		if cfg == nil {
+			return nil, fmt.Errorf("profile %q requested but no config file found", profile)
+		}
+		profileSettings, ok := cfg.profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown profile %q (have: %s)", cfg.path, profile,
+				strings.Join(slices.Sorted(maps.Keys(cfg.profiles)), ", "))
+		}
+		layered = append(layered, profileSettings...)
+	}
+
+	return append(layered, envSettings()...), nil
+}
+
+// findConfig loads the config file at path, or the first one found in
+// the default locations. It returns nil if there is none.
+func findConfig(path string) (*config, error) {
+	if path != "" {
+		return readConfig(path)
+	}
+
+	candidates := []string{configFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "apkpure", "config.toml"))
+	}
+	for _, candidate := range candidates {
+		cfg, err := readConfig(candidate)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return cfg, err
+	}
+	return nil, nil
+}
+
+// readConfig parses the config file at path
+func readConfig(path string) (*config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return parseConfig(file, path)
+}
+
+// parseConfig parses a config file. The format is the subset of TOML the
+// options need: key = value pairs with string, integer, boolean or
+// string array values, a top-level profile key, and [profiles.NAME]
+// tables.
+func parseConfig(r io.Reader, name string) (*config, error) {
+	cfg := &config{path: name, profiles: make(map[string][]setting)}
+	table := ""
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		source := fmt.Sprintf("%s:%d", name, lineNum)
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s: unsupported table header %s", source, line)
+			}
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			prefix, profile, ok := strings.Cut(header, ".")
+			if prefix != "profiles" || !ok {
+				return nil, fmt.Errorf("%s: unknown table [%s]; only [profiles.NAME] is supported", source, header)
+			}
+			profile, err := parseKey(profile)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", source, err)
+			}
+			if _, ok := cfg.profiles[profile]; ok {
+				return nil, fmt.Errorf("%s: profile %q defined twice", source, profile)
+			}
+			cfg.profiles[profile] = []setting{}
+			table = profile
+			continue
+		}
+
+		rawKey, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: expected key = value", source)
+		}
+		key, err := parseKey(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		value, err := parseValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", source, key, err)
+		}
+
+		if seen[table+"\x00"+key] {
+			return nil, fmt.Errorf("%s: %s set twice", source, key)
+		}
+		seen[table+"\x00"+key] = true
+
+		switch {
+		case table == "" && key == "profile":
+			cfg.profile = value
+		case table == "":
+			cfg.settings = append(cfg.settings, setting{key: key, value: value, source: source})
+		default:
+			cfg.profiles[table] = append(cfg.profiles[table], setting{key: key, value: value, source: source})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseKey parses a bare or quoted key
+func parseKey(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "'") {
+		return parseString(raw)
+	}
+	if raw == "" || strings.IndexFunc(raw, func(r rune) bool {
+		return !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		return "", fmt.Errorf("invalid key %q", raw)
+	}
+	return raw, nil
+}
+
+// parseValue parses a value into the string form options take. Arrays
+// are joined with ";", the separator used for architecture lists.
+func parseValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case raw == "true" || raw == "false":
+		return raw, nil
+	case strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "'"):
+		return parseString(raw)
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", errors.New("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			value, err := parseValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ";"), nil
+	default:
+		n, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid value %s (strings must be quoted)", raw)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+// parseString parses a "basic" or 'literal' string
+func parseString(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	s, err := strconv.Unquote(raw)
+	if err != nil || raw[0] != '"' {
+		return "", fmt.Errorf("invalid string %s", raw)
+	}
+	return s, nil
+}
+
+// envSettings returns the options set through APKPURE_<KEY> variables
+func envSettings() []setting {
+	var envs []setting
+	for _, key := range slices.Sorted(maps.Keys(optionSetters)) {
+		name := envPrefix + strings.ToUpper(key)
+		if value, ok := os.LookupEnv(name); ok {
+			envs = append(envs, setting{key: key, value: value, source: "$" + name})
+		}
+	}
+	return envs
+}
+
+// optionBuilder accumulates settings into options
+type optionBuilder struct {
+	opts      settings
+	cacheDir  string
+	storeDir  string
+	storeLink apkpure.LinkMode
+	pinFile   string
+}
+
+// optionSetters applies each known option key; config files, APKPURE_*
+// variables and -o all use these keys
+var optionSetters = map[string]func(b *optionBuilder, value string) error{
+	"arch":          func(b *optionBuilder, v string) error { b.opts.Arch = v; return nil },
+	"language":      func(b *optionBuilder, v string) error { b.opts.Language = v; return nil },
+	"os_ver":        func(b *optionBuilder, v string) error { b.opts.OSVersion = v; return nil },
+	"output_format": func(b *optionBuilder, v string) error { b.opts.OutputFormat = v; return nil },
+	"output_template": func(b *optionBuilder, v string) error {
+		tmpl, err := template.New("output").Parse(v)
+		b.opts.outputTemplate = tmpl
+		return err
+	},
+	"parallel": func(b *optionBuilder, v string) error { return setInt(&b.opts.Parallel, v) },
+	"sleep_duration": func(b *optionBuilder, v string) error {
+		// Bare numbers are milliseconds, like -s
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			b.opts.SleepDuration = time.Duration(ms) * time.Millisecond
+			return nil
+		}
+		return setDuration(&b.opts.SleepDuration, v)
+	},
+	"unpack_xapk":      func(b *optionBuilder, v string) error { return setBool(&b.opts.UnpackXAPK, v) },
+	"verify_signature": func(b *optionBuilder, v string) error { return setBool(&b.opts.VerifySignature, v) },
+	"verify_manifest": func(b *optionBuilder, v string) error {
+		var verify bool
+		if err := setBool(&verify, v); err != nil {
+			return err
+		}
+		b.opts.SkipManifestCheck = !verify
+		return nil
+	},
+	"retries": func(b *optionBuilder, v string) error {
+		return setInt(&retryPolicy(&b.opts.DownloadOptions).MaxAttempts, v)
+	},
+	"retry_backoff": func(b *optionBuilder, v string) error {
+		return setDuration(&retryPolicy(&b.opts.DownloadOptions).BaseBackoff, v)
+	},
+	"retry_max_backoff": func(b *optionBuilder, v string) error {
+		return setDuration(&retryPolicy(&b.opts.DownloadOptions).MaxBackoff, v)
+	},
+	"cache_dir":  func(b *optionBuilder, v string) error { b.cacheDir = v; return nil },
+	"cache_ttl":  func(b *optionBuilder, v string) error { return setDuration(&b.opts.CacheTTL, v) },
+	"offline":    func(b *optionBuilder, v string) error { return setBool(&b.opts.Offline, v) },
+	"store":      func(b *optionBuilder, v string) error { b.storeDir = v; return nil },
+	"store_link": func(b *optionBuilder, v string) error { b.storeLink = apkpure.LinkMode(v); return nil },
+	"pins":       func(b *optionBuilder, v string) error { b.pinFile = v; return nil },
+	"api_base_url": func(b *optionBuilder, v string) error {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("expected an http or https URL, got %q", v)
+		}
+		b.opts.APIBaseURL = v
+		return nil
+	},
+}
+
+// setInt parses an integer option
+func setInt(dst *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	*dst = n
+	return nil
+}
+
+// setBool parses a boolean option
+func setBool(dst *bool, value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", value)
+	}
+	*dst = b
+	return nil
+}
+
+// setDuration parses a duration option such as 1s or 1h30m
+func setDuration(dst *time.Duration, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	*dst = d
+	return nil
+}
+
+// set applies one setting, rejecting unknown keys and invalid values
+func (b *optionBuilder) set(s setting) error {
+	setter, ok := optionSetters[s.key]
+	if !ok {
+		return fmt.Errorf("%s: unknown option %q", s.source, s.key)
+	}
+	if err := setter(b, s.value); err != nil {
+		return fmt.Errorf("%s: %s: %v", s.source, s.key, err)
+	}
+	return nil
+}
+
+// build opens the cache, store and pin file the settings name
+func (b *optionBuilder) build() (settings, error) {
+	opts := b.opts
+
+	if b.pinFile != "" {
+		pins, err := parsePinFile(b.pinFile)
+		if err != nil {
+			return settings{}, fmt.Errorf("parsing pins: %w", err)
+		}
+		opts.PinnedCertificates = pins
+	}
+
+	if b.storeDir != "" {
+		store, err := apkpure.OpenStore(b.storeDir, b.storeLink)
+		if err != nil {
+			return settings{}, fmt.Errorf("opening store: %w", err)
+		}
+		opts.Store = store
+	}
+
+	// A TTL or offline mode implies caching; use the default location
+	// when no cache_dir was given
+	cacheDir := b.cacheDir
+	if cacheDir == "" && (opts.CacheTTL > 0 || opts.Offline) {
+		dir, err := apkpure.DefaultCacheDir()
+		if err != nil {
+			return settings{}, fmt.Errorf("locating cache: %w", err)
+		}
+		cacheDir = dir
+	}
+	if cacheDir != "" {
+		cache, err := apkpure.NewDiskCache(cacheDir)
+		if err != nil {
+			return settings{}, fmt.Errorf("opening cache: %w", err)
+		}
+		opts.Cache = cache
+	}
+
+	return opts, nil
+}
+
+// parseOptions layers the -o string over the config file, profile and
+// environment settings, applies the global flag overrides and opens what
+// they refer to. Unknown keys, invalid values and problems opening a
+// cache, store or pin file are fatal.
+func parseOptions(optStr string) settings {
+	opts, err := buildOptions(optStr)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	return opts
+}
+
+// buildOptions is parseOptions without the exit
+func buildOptions(optStr string) (settings, error) {
+	layered := slices.Clone(baseSettings)
+	for _, pair := range strings.Split(optStr, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return settings{}, fmt.Errorf("-o: expected key=value, got %q", pair)
+		}
+		layered = append(layered, setting{key: strings.TrimSpace(key), value: strings.TrimSpace(value), source: "-o"})
+	}
+	layered = append(layered, overrideSettings...)
+
+	var b optionBuilder
+	for _, s := range layered {
+		if err := b.set(s); err != nil {
+			return settings{}, err
+		}
+	}
+	return b.build()
+}
+
+// retryPolicy returns opts.Retry, initializing it to the default policy
+func retryPolicy(opts *apkpure.DownloadOptions) *apkpure.RetryPolicy {
+	if opts.Retry == nil {
+		opts.Retry = apkpure.DefaultRetryPolicy()
+	}
+	return opts.Retry
+}
+
+// flagWasSet reports whether any of names was given on the command line
+func flagWasSet(fs *flag.FlagSet, names ...string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if slices.Contains(names, f.Name) {
+			set = true
+		}
+	})
+	return set
+}
+
+// writeResults prints download results through the output template, if
+// one is configured, or in the output format
+func writeResults(results []apkpure.DownloadResult, opts settings) error {
+	if opts.outputTemplate != nil {
+		return apkpure.WriteResultsTemplate(os.Stdout, results, opts.outputTemplate)
+	}
+	return apkpure.WriteResults(os.Stdout, results, opts.OutputFormat)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	const file = `# defaults
+language = "en-US"   # trailing comment
+retries = 1_000
+unpack_xapk = true
+arch = ["arm64-v8a", 'x86_64']
+output_template = "{{.package_id}} # not a comment"
+"quoted-key" = 'C:\path'
+profile = "pixel"
+
+[profiles.pixel]
+arch = "arm64-v8a"
+
+[ profiles."tv box" ]
+os_ver = 28
+`
+	cfg, err := parseConfig(strings.NewReader(file), "test.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSettings := []setting{
+		{"language", "en-US", "test.toml:2"},
+		{"retries", "1000", "test.toml:3"},
+		{"unpack_xapk", "true", "test.toml:4"},
+		{"arch", "arm64-v8a;x86_64", "test.toml:5"},
+		{"output_template", "{{.package_id}} # not a comment", "test.toml:6"},
+		{"quoted-key", `C:\path`, "test.toml:7"},
+	}
+	if !reflect.DeepEqual(cfg.settings, wantSettings) {
+		t.Errorf("settings = %q, want %q", cfg.settings, wantSettings)
+	}
+	if cfg.profile != "pixel" {
+		t.Errorf("profile = %q, want pixel", cfg.profile)
+	}
+	wantProfiles := map[string][]setting{
+		"pixel":  {{"arch", "arm64-v8a", "test.toml:11"}},
+		"tv box": {{"os_ver", "28", "test.toml:14"}},
+	}
+	if !reflect.DeepEqual(cfg.profiles, wantProfiles) {
+		t.Errorf("profiles = %q, want %q", cfg.profiles, wantProfiles)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"bare string", "language = en-US", "test.toml:1: language: invalid value en-US"},
+		{"missing value", "\nretries =", "test.toml:2: retries: missing value"},
+		{"no equals", "retries", "test.toml:1: expected key = value"},
+		{"bad key", "a.b = 1", `test.toml:1: invalid key "a.b"`},
+		{"set twice", "retries = 1\nretries = 2", "test.toml:2: retries set twice"},
+		{"unknown table", "[other]", "test.toml:1: unknown table [other]"},
+		{"array table", "[[profiles]]", "test.toml:1: unsupported table header"},
+		{"profile twice", "[profiles.a]\n[profiles.a]", `test.toml:2: profile "a" defined twice`},
+		{"multiline array", "arch = [\n", "test.toml:1: arch: arrays must be on one line"},
+		{"unterminated string", `language = "en`, "test.toml:1: language: invalid string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig(strings.NewReader(tt.file), "test.toml")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseConfig error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigSameKeyInProfiles(t *testing.T) {
+	_, err := parseConfig(strings.NewReader("arch = \"x86\"\n[profiles.a]\narch = \"x86_64\"\n[profiles.b]\narch = \"x86_64\"\n"), "test.toml")
+	if err != nil {
+		t.Errorf("a key set once per table was rejected: %v", err)
+	}
+}
+
+// useSettings replaces the global settings for one test
+func useSettings(t *testing.T, base, overrides []setting) {
+	t.Helper()
+	oldBase, oldOverrides := baseSettings, overrideSettings
+	baseSettings, overrideSettings = base, overrides
+	t.Cleanup(func() { baseSettings, overrideSettings = oldBase, oldOverrides })
+}
+
+// writeConfig writes a config file into a fresh working directory and
+// clears the variables that would change how it is found
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, envPrefix) {
+			t.Setenv(name, "")
+			_ = os.Unsetenv(name)
+		}
+	}
+	path := filepath.Join(dir, configFileName)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	writeConfig(t, `language = "en-US"
+arch = "x86"
+os_ver = 30
+parallel = 2
+profile = "pixel"
+
+[profiles.pixel]
+arch = "arm64-v8a"
+os_ver = 34
+
+[profiles.tv]
+arch = "armeabi-v7a"
+`)
+
+	tests := []struct {
+		name      string
+		profile   string
+		env       map[string]string
+		options   string
+		overrides []setting
+		want      func(settings) bool
+	}{
+		{
+			name: "file then default profile",
+			want: func(o settings) bool {
+				return o.Language == "en-US" && o.Arch == "arm64-v8a" && o.OSVersion == "34" && o.Parallel == 2
+			},
+		},
+		{
+			name:    "explicit profile",
+			profile: "tv",
+			want:    func(o settings) bool { return o.Arch == "armeabi-v7a" && o.OSVersion == "30" },
+		},
+		{
+			name: "profile from environment",
+			env:  map[string]string{"APKPURE_PROFILE": "tv"},
+			want: func(o settings) bool { return o.Arch == "armeabi-v7a" },
+		},
+		{
+			name: "environment over profile",
+			env:  map[string]string{"APKPURE_ARCH": "x86_64", "APKPURE_PARALLEL": "8"},
+			want: func(o settings) bool { return o.Arch == "x86_64" && o.OSVersion == "34" && o.Parallel == 8 },
+		},
+		{
+			name:    "-o over environment",
+			env:     map[string]string{"APKPURE_ARCH": "x86_64"},
+			options: "arch=x86, language = ko-KR",
+			want:    func(o settings) bool { return o.Arch == "x86" && o.Language == "ko-KR" },
+		},
+		{
+			name:      "global flags over -o",
+			options:   "output_format=plaintext",
+			overrides: []setting{{key: "output_format", value: "json", source: "--format"}},
+			want:      func(o settings) bool { return o.OutputFormat == "json" },
+		},
+		{
+			name:    "sleep duration in milliseconds",
+			options: "sleep_duration=250,retries=5",
+			want: func(o settings) bool {
+				return o.SleepDuration == 250*time.Millisecond && o.Retry != nil && o.Retry.MaxAttempts == 5
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			base, err := loadSettings("", tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			useSettings(t, base, tt.overrides)
+			opts, err := buildOptions(tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want(opts) {
+				t.Errorf("unexpected options %+v", opts.DownloadOptions)
+			}
+		})
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	path := writeConfig(t, "[profiles.a]\n")
+
+	if _, err := loadSettings("", "b"); err == nil || !strings.Contains(err.Error(), `unknown profile "b" (have: a)`) {
+		t.Errorf("unknown profile: got %v", err)
+	}
+	if _, err := loadSettings(filepath.Join(filepath.Dir(path), "missing.toml"), ""); err == nil {
+		t.Error("a missing explicit config file was accepted")
+	}
+
+	t.Chdir(t.TempDir())
+	if _, err := loadSettings("", "a"); err == nil || !strings.Contains(err.Error(), "no config file found") {
+		t.Errorf("profile without config: got %v", err)
+	}
+}
+
+func TestBuildOptionsErrors(t *testing.T) {
+	writeConfig(t, "language = \"en-US\"\nparallel = \"four\"\n")
+	base, err := loadSettings("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		base    []setting
+		options string
+		want    string
+	}{
+		{"invalid value in file", base, "", `apkpure.toml:2: parallel: invalid integer "four"`},
+		{"unknown key in file", []setting{{"paralel", "4", "apkpure.toml:3"}}, "", `apkpure.toml:3: unknown option "paralel"`},
+		{"invalid -o value", nil, "verify_signature=maybe", `-o: verify_signature: invalid boolean "maybe"`},
+		{"malformed -o pair", nil, "arch", `-o: expected key=value, got "arch"`},
+		{"invalid URL", nil, "api_base_url=ftp://mirror", "-o: api_base_url: expected an http or https URL"},
+		{"invalid template", nil, "output_template={{.x", "-o: output_template:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSettings(t, tt.base, nil)
+			_, err := buildOptions(tt.options)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("buildOptions error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
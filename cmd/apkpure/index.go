@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// indexCommand implements "apkpure index [flags] DIR"
+func indexCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		repoName        string
+		repoDescription string
+		repoAddress     string
+		keyPath         string
+		certPath        string
+		optionFlags     optionFlags
+	)
+	fs.StringVar(&repoName, "name", "APKPure mirror", "Repository name")
+	fs.StringVar(&repoDescription, "description", "", "Repository description")
+	fs.StringVar(&repoAddress, "address", "", "Public URL of DIR (e.g., https://example.com/fdroid/repo)")
+	fs.StringVar(&keyPath, "key", "", "PEM private key to sign the index with")
+	fs.StringVar(&certPath, "cert", "", "PEM certificate matching -key")
+	optionFlags.register(fs)
+
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "DIR is required")
+		}
+		dir := args[0]
+		if err := validateOutPath(dir); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		repo := apkpure.RepoOptions{
+			Name:        repoName,
+			Description: repoDescription,
+			Address:     repoAddress,
+		}
+		if (keyPath == "") != (certPath == "") {
+			exitUsageError(fs, "-key and -cert must be given together")
+		}
+		if keyPath != "" {
+			signer, err := apkpure.LoadRepoSigner(keyPath, certPath)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitFailure)
+			}
+			repo.Signer = signer
+		}
+
+		opts := optionFlags.settings(fs)
+		summary, err := apkpure.WriteFDroidIndex(dir, repo)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		if err := apkpure.WriteRepoIndexSummary(os.Stdout, summary, opts.OutputFormat); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
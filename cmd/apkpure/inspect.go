@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// infoCommand implements "apkpure info [flags] PACKAGE[@VERSION][#VERSIONCODE]".
+// A bare package shows its store listing; a version or versionCode shows
+// that build.
+func infoCommand(fs *flag.FlagSet) func(args []string) {
+	var optionFlags optionFlags
+	optionFlags.register(fs)
+
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "PACKAGE is required")
+		}
+		apps, err := parseAppID(args[0])
+		if err != nil {
+			exitUsageError(fs, "%v", err)
+		}
+		app := apps[0]
+
+		opts := optionFlags.settings(fs)
+		client := apkpure.NewClient(opts.DownloadOptions)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if app.Version == "" && app.VersionCode == "" {
+			details, err := client.GetAppDetailsContext(ctx, app.PackageID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				exitOnErrors([]error{err})
+			}
+			err = apkpure.WriteAppDetails(os.Stdout, details, opts.OutputFormat)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitFailure)
+			}
+			return
+		}
+
+		version, err := client.ResolveVersionContext(ctx, app)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exitOnErrors([]error{err})
+		}
+
+		if err := apkpure.WriteVersionDetails(os.Stdout, app.PackageID, *version, opts.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// inspectCommand implements "apkpure inspect [flags] FILE..."
+func inspectCommand(fs *flag.FlagSet) func(args []string) {
+	var optionFlags optionFlags
+	optionFlags.register(fs)
+
+	return func(args []string) {
+		if len(args) == 0 {
+			exitUsageError(fs, "at least one FILE is required")
+		}
+		opts := optionFlags.settings(fs)
+
+		reports := make([]apkpure.FileReport, len(args))
+		errs := make([]error, len(args))
+		for i, path := range args {
+			info, err := apkpure.InspectFile(path)
+			reports[i] = apkpure.FileReport{Path: path, Info: info, Error: err}
+			errs[i] = err
+		}
+
+		if err := apkpure.WriteFileReports(os.Stdout, reports, opts.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		exitOnErrors(errs)
+	}
+}
+
+// verifyCommand implements "apkpure verify [flags] FILE..."
+func verifyCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		optionFlags optionFlags
+		checksum    string
+		packageID   string
+		versionCode string
+		pinFile     string
+	)
+	optionFlags.register(fs)
+	fs.StringVar(&checksum, "k", "", "Expected checksum (e.g., sha256=<hex>)")
+	fs.StringVar(&checksum, "checksum", "", "Expected checksum (alias for -k)")
+	fs.StringVar(&packageID, "package", "", "Expected package name in the manifest")
+	fs.StringVar(&versionCode, "version-code", "", "Expected versionCode in the manifest")
+	fs.StringVar(&pinFile, "p", "", "CSV file of pinned signer certificates (package,sha256)")
+	fs.StringVar(&pinFile, "pins", "", "CSV file of pinned signer certificates (alias for -p)")
+
+	return func(args []string) {
+		if len(args) == 0 {
+			exitUsageError(fs, "at least one FILE is required")
+		}
+
+		var verify apkpure.VerifyOptions
+		var err error
+		if checksum != "" {
+			if verify.Checksum, err = apkpure.ParseChecksum(checksum); err != nil {
+				exitUsageError(fs, "%v", err)
+			}
+		}
+		if versionCode != "" && !apkpure.IsVersionCode(versionCode) {
+			exitUsageError(fs, "invalid versionCode %q", versionCode)
+		}
+		verify.PackageID = packageID
+		verify.VersionCode = versionCode
+
+		opts := optionFlags.settings(fs)
+		verify.PinnedCertificates = opts.PinnedCertificates
+		if pinFile != "" {
+			if verify.PinnedCertificates, err = parsePinFile(pinFile); err != nil {
+				fmt.Printf("Error parsing pins: %v\n", err)
+				os.Exit(exitFailure)
+			}
+		}
+
+		reports := make([]apkpure.FileReport, len(args))
+		errs := make([]error, len(args))
+		for i, path := range args {
+			info, err := apkpure.VerifyFile(path, verify)
+			reports[i] = apkpure.FileReport{Path: path, Info: info, Error: err}
+			errs[i] = err
+		}
+
+		if err := apkpure.WriteFileReports(os.Stdout, reports, opts.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		exitOnErrors(errs)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// lockCommand implements "apkpure lock [flags]"
+func lockCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		appFlags    appFlags
+		optionFlags optionFlags
+		lockPath    string
+		update      bool
+	)
+	appFlags.register(fs)
+	appFlags.registerBuildFields(fs)
+	optionFlags.register(fs)
+	optionFlags.registerDownload(fs)
+	fs.StringVar(&lockPath, "lock", apkpure.LockfileName, "Lockfile to write")
+	fs.BoolVar(&update, "update", false, "Re-resolve apps that are already locked (all of them without -a or -c)")
+
+	return func(args []string) {
+		if len(args) != 0 {
+			exitUsageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+
+		existing, err := apkpure.LoadLockfile(lockPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		var apps []apkpure.AppInfo
+		if update && existing != nil && !appFlags.given() {
+			for _, pkg := range existing.Packages {
+				apps = append(apps, pkg.App())
+			}
+		} else {
+			apps = appFlags.requiredApps(fs)
+		}
+
+		opts := optionFlags.settings(fs)
+		client := apkpure.NewClient(opts.DownloadOptions)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		// Apps already in the lockfile keep their pins unless --update is
+		// given; the rest are resolved
+		var unlocked []apkpure.AppInfo
+		for _, app := range apps {
+			if _, ok := findLocked(existing, app); update || !ok {
+				unlocked = append(unlocked, app)
+			}
+		}
+
+		// A partial lockfile would silently drop apps, so nothing is written
+		// unless every app resolved
+		lock, err := client.LockContext(ctx, unlocked)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exitOnErrors(unwrapJoined(err))
+			os.Exit(exitFailure)
+		}
+		resolved := lock.Packages
+		lock.Packages = make([]apkpure.LockedPackage, 0, len(apps))
+		for _, app := range apps {
+			if pkg, ok := findLocked(existing, app); ok && !update {
+				lock.Packages = append(lock.Packages, pkg)
+			} else {
+				lock.Packages = append(lock.Packages, resolved[0])
+				resolved = resolved[1:]
+			}
+		}
+		if kept := len(apps) - len(unlocked); kept > 0 && opts.OutputFormat != apkpure.OutputJSON {
+			fmt.Fprintf(os.Stderr, "Kept %d locked apps; use --update to re-resolve them\n", kept)
+		}
+
+		if err := lock.Save(lockPath); err != nil {
+			fmt.Printf("Error saving lockfile: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		if err := apkpure.WriteLockfile(os.Stdout, lock, opts.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// fetchCommand implements "apkpure fetch [flags] OUTDIR"
+func fetchCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		optionFlags optionFlags
+		lockPath    string
+		frozen      bool
+		updateLock  bool
+	)
+	optionFlags.register(fs)
+	optionFlags.registerDownload(fs)
+	fs.StringVar(&lockPath, "lock", apkpure.LockfileName, "Lockfile to read")
+	fs.BoolVar(&frozen, "frozen", false, "Fail on any drift (the default)")
+	fs.BoolVar(&updateLock, "update-lock", false, "Re-lock apps whose pinned build drifted and fetch the new builds")
+
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "OUTDIR is required")
+		}
+		if frozen && updateLock {
+			exitUsageError(fs, "--frozen and --update-lock are mutually exclusive")
+		}
+		fetchPath := args[0]
+		if err := validateOutPath(fetchPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		lock, err := apkpure.LoadLockfile(lockPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		opts := optionFlags.settings(fs)
+		if opts.OutputFormat != apkpure.OutputJSON {
+			opts.ProgressCallback = apkpure.SimpleProgressCallback()
+		}
+		client := apkpure.NewClient(opts.DownloadOptions)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		results := client.FetchLockedContext(ctx, lock, fetchPath)
+		if updateLock {
+			results = relockDrifted(ctx, client, lock, lockPath, results, fetchPath, opts.OutputFormat)
+		}
+
+		if err := writeResults(results, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		errs := make([]error, 0, len(results))
+		for _, result := range results {
+			errs = append(errs, result.Error)
+		}
+		exitOnErrors(errs)
+	}
+}
+
+// relockDrifted resolves the apps whose pinned build drifted again,
+// fetches the new builds and saves the updated lockfile. It returns
+// results with the drifted entries replaced.
+func relockDrifted(ctx context.Context, client *apkpure.Client, lock *apkpure.Lockfile, lockPath string, results []apkpure.DownloadResult, outPath, format string) []apkpure.DownloadResult {
+	var drifted []int
+	for i, result := range results {
+		if errors.Is(result.Error, apkpure.ErrLockDrift) {
+			drifted = append(drifted, i)
+		}
+	}
+	if len(drifted) == 0 {
+		return results
+	}
+
+	apps := make([]apkpure.AppInfo, len(drifted))
+	for i, idx := range drifted {
+		apps[i] = lock.Packages[idx].App()
+	}
+	relocked, err := client.LockContext(ctx, apps)
+	if err != nil {
+		// Keep the drift errors; the lockfile stays as it was
+		fmt.Fprintf(os.Stderr, "Error re-locking drifted apps: %v\n", err)
+		return results
+	}
+
+	for i, idx := range drifted {
+		lock.Packages[idx] = relocked.Packages[i]
+	}
+	if err := lock.Save(lockPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving lockfile: %v\n", err)
+		return results
+	}
+	if format != apkpure.OutputJSON {
+		fmt.Printf("Updated %d drifted apps in %s\n", len(drifted), lockPath)
+	}
+
+	refetched := client.FetchLockedContext(ctx, relocked, outPath)
+	for i, idx := range drifted {
+		results[idx] = refetched[i]
+	}
+	return results
+}
+
+// findLocked looks app up in lock, which may be nil
+func findLocked(lock *apkpure.Lockfile, app apkpure.AppInfo) (apkpure.LockedPackage, bool) {
+	if lock == nil {
+		return apkpure.LockedPackage{}, false
+	}
+	return lock.Find(app)
+}
+
+// unwrapJoined splits an error made by errors.Join into its parts
+func unwrapJoined(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
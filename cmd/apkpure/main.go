@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"time"
@@ -12,188 +15,417 @@ import (
 	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
 )
 
-var (
-	appID         string
-	csvFile       string
-	fieldNum      int
-	versionField  int
-	listVersions  bool
-	options       string
-	parallel      int
-	sleepDuration int64
-	outPath       string
+// Exit codes reported for failed operations
+const (
+	exitFailure      = 1
+	exitUsage        = 2
+	exitNotFound     = 3
+	exitRateLimited  = 4
+	exitHTTPStatus   = 5
+	exitFileExists   = 6
+	exitVerification = 7
+	exitNotCached    = 8
+	exitLockDrift    = 9
+	exitCanceled     = 130
 )
 
-func init() {
-	flag.StringVar(&appID, "a", "", "App ID (e.g., com.instagram.android or com.instagram.android@1.2.3)")
-	flag.StringVar(&appID, "app", "", "App ID (alias for -a)")
-	flag.StringVar(&csvFile, "c", "", "CSV file containing app IDs")
-	flag.StringVar(&csvFile, "csv", "", "CSV file containing app IDs (alias for -c)")
-	flag.IntVar(&fieldNum, "f", 1, "CSV field number containing app IDs")
-	flag.IntVar(&fieldNum, "field", 1, "CSV field number (alias for -f)")
-	flag.IntVar(&versionField, "v", 0, "CSV field number containing versions")
-	flag.IntVar(&versionField, "version-field", 0, "CSV field number for versions (alias for -v)")
-	flag.BoolVar(&listVersions, "l", false, "List available versions")
-	flag.BoolVar(&listVersions, "list-versions", false, "List available versions (alias for -l)")
-	flag.StringVar(&options, "o", "", "Additional options (e.g., arch=arm64-v8a,language=en-US)")
-	flag.StringVar(&options, "options", "", "Additional options (alias for -o)")
-	flag.IntVar(&parallel, "r", 4, "Number of parallel downloads")
-	flag.IntVar(&parallel, "parallel", 4, "Number of parallel downloads (alias for -r)")
-	flag.Int64Var(&sleepDuration, "s", 0, "Sleep duration between downloads in milliseconds")
-	flag.Int64Var(&sleepDuration, "sleep-duration", 0, "Sleep duration (alias for -s)")
+func main() {
+	args, globals := extractGlobalFlags(os.Args[1:])
+
+	var err error
+	baseSettings, err = loadSettings(globals.config, globals.profile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	if globals.format != "" {
+		overrideSettings = append(overrideSettings, setting{key: "output_format", value: globals.format, source: "--format"})
+	}
+
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+
+	switch name := args[0]; {
+	case name == "-h" || name == "-help" || name == "--help":
+		printUsage(os.Stdout)
+	case strings.HasPrefix(name, "-"):
+		// No command: the flat flags of earlier releases
+		runCommand(legacyCommand, args)
+	default:
+		cmd := findCommand(name)
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", name)
+			printUsage(os.Stderr)
+			os.Exit(exitUsage)
+		}
+		runCommand(cmd, args[1:])
+	}
 }
 
-func main() {
-	flag.Parse()
+// downloadFlags are the flags of download, which the legacy invocation
+// accepts too
+type downloadFlags struct {
+	apps        appFlags
+	opts        optionFlags
+	allVersions bool
+	maxVersions int
+	since       string
+	until       string
+}
+
+// register defines the download flags on fs
+func (f *downloadFlags) register(fs *flag.FlagSet) {
+	f.apps.register(fs)
+	f.apps.registerBuildFields(fs)
+	f.opts.register(fs)
+	f.opts.registerDownload(fs)
+	fs.BoolVar(&f.allVersions, "all-versions", false, "Download every listed version (bounded by @constraint, --max-versions, --since and --until)")
+	fs.IntVar(&f.maxVersions, "max-versions", 0, "With --all-versions, only the newest N matching versions")
+	fs.StringVar(&f.since, "since", "", "With --all-versions, only versions released on or after this date (YYYY-MM-DD)")
+	fs.StringVar(&f.until, "until", "", "With --all-versions, only versions released on or before this date (YYYY-MM-DD)")
+}
 
-	// Get output path from remaining args
-	args := flag.Args()
-	if !listVersions && len(args) == 0 {
-		fmt.Println("Error: OUTPATH is required when downloading files")
-		flag.Usage()
-		os.Exit(1)
+// downloadCommand implements "apkpure download [flags] OUTDIR"
+func downloadCommand(fs *flag.FlagSet) func(args []string) {
+	var f downloadFlags
+	f.register(fs)
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "OUTDIR is required")
+		}
+		f.download(fs, args[0])
 	}
+}
 
-	if len(args) > 0 {
-		outPath = args[0]
+// listCommand implements "apkpure list [flags]"
+func listCommand(fs *flag.FlagSet) func(args []string) {
+	var apps appFlags
+	var opts optionFlags
+	apps.register(fs)
+	opts.register(fs)
+	return func(args []string) {
+		if len(args) != 0 {
+			exitUsageError(fs, "unexpected arguments: %s", strings.Join(args, " "))
+		}
+		list(fs, &apps, &opts)
 	}
+}
 
-	// Parse app list
-	var apps []apkpure.AppInfo
-	var err error
+// legacySetup defines the flat flags of the invocation without a command:
+// the download flags plus -l to list versions instead
+func legacySetup(fs *flag.FlagSet) func(args []string) {
+	var f downloadFlags
+	var listVersions bool
+	f.register(fs)
+	fs.BoolVar(&listVersions, "l", false, "List available versions")
+	fs.BoolVar(&listVersions, "list-versions", false, "List available versions (alias for -l)")
+	return func(args []string) {
+		if listVersions {
+			list(fs, &f.apps, &f.opts)
+			return
+		}
+		if len(args) == 0 {
+			exitUsageError(fs, "OUTDIR is required when downloading files")
+		}
+		f.download(fs, args[0])
+	}
+}
+
+// list prints the versions of the selected apps
+func list(fs *flag.FlagSet, appFlags *appFlags, optionFlags *optionFlags) {
+	apps := appFlags.requiredApps(fs)
+	opts := optionFlags.settings(fs)
+	client := apkpure.NewClient(opts.DownloadOptions)
 
-	if appID != "" {
-		apps, err = parseAppID(appID)
-	} else if csvFile != "" {
-		apps, err = parseCSVFile(csvFile, fieldNum, versionField)
-	} else {
-		fmt.Println("Error: Either -a/--app or -c/--csv must be specified")
-		flag.Usage()
-		os.Exit(1)
+	// Cancel in-flight work on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	listings := client.GetVersionsContext(ctx, apps)
+	if err := apkpure.WriteVersions(os.Stdout, listings, opts.OutputFormat); err != nil {
+		fmt.Printf("Error listing versions: %v\n", err)
+		os.Exit(exitFailure)
 	}
 
+	errs := make([]error, 0, len(listings))
+	for _, listing := range listings {
+		errs = append(errs, listing.Error)
+	}
+	exitOnErrors(errs)
+}
+
+// download fetches the selected apps into outPath
+func (f *downloadFlags) download(fs *flag.FlagSet, outPath string) {
+	apps := f.apps.requiredApps(fs)
+	opts := f.opts.settings(fs)
+	if err := validateOutPath(outPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	history, err := historyOptions(f.maxVersions, f.since, f.until)
 	if err != nil {
-		fmt.Printf("Error parsing apps: %v\n", err)
-		os.Exit(1)
+		exitUsageError(fs, "%v", err)
 	}
 
-	if len(apps) == 0 {
-		fmt.Println("Error: No apps to process")
-		os.Exit(1)
+	// The progress callback would corrupt JSON output
+	jsonOutput := opts.OutputFormat == apkpure.OutputJSON
+	if !jsonOutput {
+		opts.ProgressCallback = apkpure.SimpleProgressCallback()
 	}
 
-	// Parse options
-	opts := parseOptions(options)
-	opts.Parallel = parallel
-	opts.SleepDuration = time.Duration(sleepDuration) * time.Millisecond
+	client := apkpure.NewClient(opts.DownloadOptions)
+
+	// Cancel in-flight work on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var results []apkpure.DownloadResult
+	switch {
+	case f.allVersions:
+		results = client.DownloadAllVersionsContext(ctx, apps, outPath, history)
+	case len(apps) == 1:
+		// A single download reports only its error, unless structured
+		// output was asked for
+		result := client.DownloadWithResultContext(ctx, apps[0], outPath)
+		if jsonOutput || opts.outputTemplate != nil {
+			if err := writeResults([]apkpure.DownloadResult{result}, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		} else if result.Error != nil {
+			fmt.Printf("Error: %v\n", result.Error)
+		}
+		exitOnErrors([]error{result.Error})
+		return
+	default:
+		results = client.DownloadMultipleContext(ctx, apps, outPath)
+	}
 
-	// Add progress callback if not listing versions
-	if !listVersions {
-		opts.ProgressCallback = apkpure.SimpleProgressCallback()
+	if err := writeResults(results, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
 	}
 
-	// Create client
-	client := apkpure.NewClient(opts)
+	errs := make([]error, 0, len(results))
+	for _, result := range results {
+		errs = append(errs, result.Error)
+	}
+	exitOnErrors(errs)
+}
+
+// unpackCommand implements "apkpure unpack [flags] FILE.xapk [OUTDIR]"
+func unpackCommand(fs *flag.FlagSet) func(args []string) {
+	var optionFlags optionFlags
+	optionFlags.register(fs)
+	return func(args []string) {
+		if len(args) < 1 || len(args) > 2 {
+			exitUsageError(fs, "expected FILE.xapk and an optional OUTDIR")
+		}
 
-	// Execute
-	if listVersions {
-		err = client.ListVersions(apps)
+		xapkPath := args[0]
+		destDir := strings.TrimSuffix(xapkPath, filepath.Ext(xapkPath))
+		if len(args) == 2 {
+			destDir = args[1]
+		}
+
+		opts := optionFlags.settings(fs)
+		info, err := apkpure.UnpackXAPK(xapkPath, destDir)
 		if err != nil {
-			fmt.Printf("Error listing versions: %v\n", err)
-			os.Exit(1)
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
 		}
-	} else {
-		// Validate output path
-		if err := validateOutPath(outPath); err != nil {
+
+		if err := apkpure.WriteXAPKInfo(os.Stdout, info, opts.OutputFormat); err != nil {
 			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitFailure)
 		}
+	}
+}
 
-		if len(apps) == 1 {
-			// Single download
-			err = client.Download(apps[0], outPath)
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
-			// Multiple downloads
-			results := client.DownloadMultiple(apps, outPath)
-
-			// Print summary
-			successCount := 0
-			for _, result := range results {
-				if result.Success {
-					successCount++
-				} else {
-					fmt.Printf("Failed to download %s: %v\n", result.AppInfo.PackageID, result.Error)
-				}
-			}
+// exitOnErrors exits with the code for the non-nil errors in errs, if
+// any. When failures are of different kinds the generic code is used.
+func exitOnErrors(errs []error) {
+	code := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		c := exitCode(err)
+		if code != 0 && code != c {
+			code = exitFailure
+			break
+		}
+		code = c
+	}
 
-			fmt.Printf("\nDownload complete: %d/%d succeeded\n", successCount, len(results))
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// exitCode maps an error to the process exit code for its kind
+func exitCode(err error) int {
+	switch apkpure.ErrorKind(err) {
+	case apkpure.KindPackageNotFound, apkpure.KindVersionNotFound:
+		return exitNotFound
+	case apkpure.KindRateLimited:
+		return exitRateLimited
+	case apkpure.KindHTTPStatus:
+		return exitHTTPStatus
+	case apkpure.KindFileExists:
+		return exitFileExists
+	case apkpure.KindVerificationFailed:
+		return exitVerification
+	case apkpure.KindNotCached:
+		return exitNotCached
+	case apkpure.KindLockDrift:
+		return exitLockDrift
+	case apkpure.KindCanceled:
+		return exitCanceled
+	default:
+		return exitFailure
+	}
+}
+
+// historyOptions builds the --all-versions bounds from the CLI flags. The
+// until date is inclusive, so it is moved to the end of that day.
+func historyOptions(limit int, since, until string) (apkpure.HistoryOptions, error) {
+	opts := apkpure.HistoryOptions{Limit: limit}
+	if limit < 0 {
+		return opts, fmt.Errorf("--max-versions must be 0 or greater")
+	}
+
+	if since != "" {
+		t, err := time.Parse(time.DateOnly, since)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --since date: %w", err)
+		}
+		opts.Since = t
+	}
+	if until != "" {
+		t, err := time.Parse(time.DateOnly, until)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --until date: %w", err)
 		}
+		opts.Until = t.Add(24*time.Hour - time.Nanosecond)
 	}
+
+	return opts, nil
 }
 
-// parseAppID parses a single app ID (with optional version)
+// parseAppID parses a single app ID with optional version constraint,
+// versionCode and checksum, e.g. com.example.app@1.2.3#123456#sha256=<hex>
 func parseAppID(appID string) ([]apkpure.AppInfo, error) {
-	parts := strings.SplitN(appID, "@", 2)
-	app := apkpure.AppInfo{
-		PackageID: parts[0],
-	}
-	if len(parts) > 1 {
-		app.Version = parts[1]
+	app, err := apkpure.ParseAppSpec(appID)
+	if err != nil {
+		return nil, err
 	}
 	return []apkpure.AppInfo{app}, nil
 }
 
-// parseCSVFile parses a CSV file for app IDs
-func parseCSVFile(filename string, fieldNum, versionField int) ([]apkpure.AppInfo, error) {
-	if fieldNum < 1 {
-		return nil, fmt.Errorf("field number must be 1 or greater")
-	}
-	if versionField != 0 && versionField < 1 {
-		return nil, fmt.Errorf("version field number must be 1 or greater")
+// csvFields holds the 1-based CSV column numbers to read; zero means the
+// optional column is absent
+type csvFields struct {
+	app         int
+	version     int
+	versionCode int
+	checksum    int
+}
+
+// validate checks that the columns are positive and distinct
+func (f csvFields) validate() error {
+	if f.app < 1 {
+		return fmt.Errorf("field number must be 1 or greater")
 	}
-	if versionField != 0 && fieldNum == versionField {
-		return nil, fmt.Errorf("app ID and version fields must be different")
+
+	seen := map[int]string{f.app: "app ID"}
+	for _, optional := range []struct {
+		name  string
+		field int
+	}{
+		{"version", f.version},
+		{"versionCode", f.versionCode},
+		{"checksum", f.checksum},
+	} {
+		if optional.field == 0 {
+			continue
+		}
+		if optional.field < 1 {
+			return fmt.Errorf("%s field number must be 1 or greater", optional.name)
+		}
+		if other, ok := seen[optional.field]; ok {
+			return fmt.Errorf("%s and %s fields must be different", other, optional.name)
+		}
+		seen[optional.field] = optional.name
 	}
+	return nil
+}
 
-	file, err := os.Open(filename)
-	if err != nil {
+// parseCSVFile parses a CSV file for app IDs; "-" reads standard input
+func parseCSVFile(filename string, fields csvFields) ([]apkpure.AppInfo, error) {
+	if err := fields.validate(); err != nil {
 		return nil, err
 	}
-	defer func() { _ = file.Close() }()
 
-	reader := csv.NewReader(file)
+	var input io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = file.Close() }()
+		input = file
+	}
+
+	reader := csv.NewReader(input)
 	records, err := reader.ReadAll()
 	if err != nil {
 		return nil, err
 	}
 
-	var apps []apkpure.AppInfo
-	fieldIdx := fieldNum - 1
-	versionIdx := versionField - 1
-
-	for _, record := range records {
-		if len(record) <= fieldIdx {
-			continue
+	// column returns the trimmed value of a 1-based field, or ""
+	column := func(record []string, field int) string {
+		if field < 1 || len(record) < field {
+			return ""
 		}
+		return strings.TrimSpace(record[field-1])
+	}
 
-		appID := strings.TrimSpace(record[fieldIdx])
+	var apps []apkpure.AppInfo
+	for line, record := range records {
+		appID := column(record, fields.app)
 		if appID == "" {
 			continue
 		}
 
+		if err := apkpure.ValidatePackageID(appID); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+1, err)
+		}
 		app := apkpure.AppInfo{
 			PackageID: appID,
 		}
 
-		if versionField > 0 && len(record) > versionIdx {
-			version := strings.TrimSpace(record[versionIdx])
-			if version != "" {
-				app.Version = version
+		if version := column(record, fields.version); version != "" {
+			if _, err := apkpure.ParseVersionConstraint(version); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line+1, err)
+			}
+			app.Version = version
+		}
+
+		if code := column(record, fields.versionCode); code != "" {
+			if !apkpure.IsVersionCode(code) {
+				return nil, fmt.Errorf("line %d: invalid versionCode %q", line+1, code)
+			}
+			app.VersionCode = code
+		}
+
+		if value := column(record, fields.checksum); value != "" {
+			checksum, err := apkpure.ParseChecksum(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line+1, err)
 			}
+			app.Checksum = checksum
 		}
 
 		apps = append(apps, app)
@@ -202,37 +434,33 @@ func parseCSVFile(filename string, fieldNum, versionField int) ([]apkpure.AppInf
 	return apps, nil
 }
 
-// parseOptions parses the options string
-func parseOptions(optStr string) apkpure.DownloadOptions {
-	opts := apkpure.DownloadOptions{}
-
-	if optStr == "" {
-		return opts
+// parsePinFile reads "package,sha256" lines into a map of pinned signer
+// certificates. Lines starting with # are ignored.
+func parsePinFile(filename string) (map[string][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = file.Close() }()
 
-	pairs := strings.Split(optStr, ",")
-	for _, pair := range pairs {
-		kv := strings.SplitN(pair, "=", 2)
-		if len(kv) != 2 {
-			continue
-		}
-
-		key := strings.TrimSpace(kv[0])
-		value := strings.TrimSpace(kv[1])
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
 
-		switch key {
-		case "arch":
-			opts.Arch = value
-		case "language":
-			opts.Language = value
-		case "os_ver":
-			opts.OSVersion = value
-		case "output_format":
-			opts.OutputFormat = value
+	pins := make(map[string][]string)
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected package,sha256", i+1)
 		}
+		packageID := strings.TrimSpace(record[0])
+		pins[packageID] = append(pins[packageID], apkpure.NormalizeCertDigest(record[1]))
 	}
 
-	return opts
+	return pins, nil
 }
 
 // validateOutPath validates the output path
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&apkpure.PackageNotFoundError{PackageID: "com.example"}, exitNotFound},
+		{fmt.Errorf("resolving: %w", &apkpure.VersionNotFoundError{PackageID: "com.example", Version: "1.0"}), exitNotFound},
+		{&apkpure.RateLimitedError{HTTPStatusError: apkpure.HTTPStatusError{StatusCode: 429}}, exitRateLimited},
+		{fmt.Errorf("downloading: %w", &apkpure.HTTPStatusError{StatusCode: 500}), exitHTTPStatus},
+		{&apkpure.FileExistsError{Path: "a.apk"}, exitFileExists},
+		{&apkpure.VerificationError{Path: "a.apk", Check: "sha256"}, exitVerification},
+		{fmt.Errorf("com.example: %w", apkpure.ErrNotCached), exitNotCached},
+		{&apkpure.LockDriftError{Field: "sha256", Err: &apkpure.VerificationError{Check: "sha256"}}, exitLockDrift},
+		{fmt.Errorf("downloading: %w", context.Canceled), exitCanceled},
+		{errors.New("disk full"), exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// proxyCommand implements "apkpure proxy [flags] CACHEDIR"
+func proxyCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		optionFlags optionFlags
+		addr        string
+		maxSize     string
+	)
+	optionFlags.register(fs)
+	optionFlags.registerDownload(fs)
+	fs.StringVar(&addr, "addr", "localhost:8080", "Address to listen on")
+	fs.StringVar(&maxSize, "max-size", "", "Cache size limit, e.g. 500M or 20G (default: unlimited)")
+
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "CACHEDIR is required")
+		}
+		cacheDir := args[0]
+
+		var limit int64
+		if maxSize != "" {
+			var err error
+			if limit, err = parseByteSize(maxSize); err != nil {
+				exitUsageError(fs, "invalid -max-size: %v", err)
+			}
+		}
+
+		opts := optionFlags.settings(fs)
+		client := apkpure.NewClient(opts.DownloadOptions)
+		proxy, err := apkpure.NewProxy(client, cacheDir, limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		httpServer := &http.Server{Addr: addr, Handler: proxy}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		errc := make(chan error, 1)
+		go func() { errc <- httpServer.ListenAndServe() }()
+		fmt.Fprintf(os.Stderr, "Proxying APKPure with cache %s on http://%s\n", cacheDir, addr)
+
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			err = httpServer.Shutdown(shutdownCtx)
+			cancel()
+		}
+		proxy.Close()
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// parseByteSize parses a byte count with an optional K, M, G or T suffix
+// (powers of 1024), e.g. "500M"
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	number := strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	shift := 0
+	if n := len(number); n > 0 {
+		if i := strings.IndexByte("KMGT", number[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			number = number[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
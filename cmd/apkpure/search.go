@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// searchCommand implements "apkpure search [flags] QUERY..."
+func searchCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		optionFlags optionFlags
+		page        int
+		pages       int
+	)
+	optionFlags.register(fs)
+	fs.IntVar(&page, "page", 1, "First page of results to show")
+	fs.IntVar(&pages, "pages", 1, "Number of pages to fetch")
+
+	return func(args []string) {
+		query := strings.TrimSpace(strings.Join(args, " "))
+		if query == "" {
+			exitUsageError(fs, "QUERY is required")
+		}
+		if page < 1 || pages < 1 {
+			exitUsageError(fs, "-page and -pages must be 1 or greater")
+		}
+
+		opts := optionFlags.settings(fs)
+		client := apkpure.NewClient(opts.DownloadOptions)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		// Pages are merged into one result so the CSV stays a single list
+		var results *apkpure.SearchResults
+		for p := page; p < page+pages; p++ {
+			next, err := client.SearchContext(ctx, query, p)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				exitOnErrors([]error{err})
+			}
+			if results == nil {
+				results = next
+			} else {
+				results.Apps = append(results.Apps, next.Apps...)
+				results.Page, results.HasMore = next.Page, next.HasMore
+			}
+			if !next.HasMore {
+				break
+			}
+		}
+
+		if err := apkpure.WriteSearchResults(os.Stdout, results, opts.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchCSVRoundTrip(t *testing.T) {
+	apps := []map[string]string{
+		{"package_name": "com.whatsapp", "version_name": "2.24.1", "title": "WhatsApp Messenger", "developer": "WhatsApp LLC"},
+		{"package_name": "com.example.chat", "version_name": "1.0.3-beta", "title": `Chat, "Quoted" & more`, "developer": "Example, Inc."},
+		{"package_name": "org.multi.line", "version_name": "3", "title": "Two\nlines", "developer": ""},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/search_query_new" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": apps, "has_more": false})
+	}))
+	t.Cleanup(server.Close)
+	useStubAPI(t, server.URL)
+
+	out := captureStdout(t, func() { runCommand(findCommand("search"), []string{"chat"}) })
+	if !strings.HasPrefix(out, "com.whatsapp,2.24.1,WhatsApp Messenger,WhatsApp LLC\n") {
+		t.Errorf("search printed %q", out)
+	}
+
+	// Feed the output to -c - as a pipe would
+	stdin, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stdin.Close() }()
+	if _, err := stdin.WriteString(out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = oldStdin })
+
+	parsed, err := parseCSVFile("-", csvFields{app: 1, version: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, app := range parsed {
+		got = append(got, app.PackageID+"@"+app.Version)
+	}
+	want := []string{"com.whatsapp@2.24.1", "com.example.chat@1.0.3-beta", "org.multi.line@3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("-c - read %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// shutdownTimeout is how long serve waits for open requests on Ctrl+C
+const shutdownTimeout = 10 * time.Second
+
+// serveCommand implements "apkpure serve [flags] OUTDIR"
+func serveCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		optionFlags optionFlags
+		addr        string
+	)
+	optionFlags.register(fs)
+	optionFlags.registerDownload(fs)
+	fs.StringVar(&addr, "addr", "localhost:8080", "Address to listen on")
+
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "OUTDIR is required")
+		}
+		outPath := args[0]
+		if err := validateOutPath(outPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		opts := optionFlags.settings(fs)
+		client := apkpure.NewClient(opts.DownloadOptions)
+		server := apkpure.NewServer(client, outPath)
+		httpServer := &http.Server{Addr: addr, Handler: server}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		errc := make(chan error, 1)
+		go func() { errc <- httpServer.ListenAndServe() }()
+		fmt.Fprintf(os.Stderr, "Serving %s on http://%s\n", outPath, addr)
+
+		var err error
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			err = httpServer.Shutdown(shutdownCtx)
+			cancel()
+		}
+		server.Close()
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// storeCommand implements "apkpure store [flags] ls|gc STOREDIR"
+func storeCommand(fs *flag.FlagSet) func(args []string) {
+	var optionFlags optionFlags
+	optionFlags.register(fs)
+
+	return func(args []string) {
+		if len(args) != 2 || (args[0] != "ls" && args[0] != "gc") {
+			exitUsageError(fs, "expected ls or gc and STOREDIR")
+		}
+		action, dir := args[0], args[1]
+		if err := validateOutPath(dir); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		opts := optionFlags.settings(fs)
+		store, err := apkpure.OpenStore(dir, "")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		if action == "ls" {
+			var entries []apkpure.StoreEntry
+			entries, err = store.Entries()
+			if err == nil {
+				err = apkpure.WriteStoreEntries(os.Stdout, entries, opts.OutputFormat)
+			}
+		} else {
+			var report *apkpure.StoreGCReport
+			report, err = store.GC()
+			if err == nil {
+				err = apkpure.WriteStoreGCReport(os.Stdout, report, opts.OutputFormat)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+}
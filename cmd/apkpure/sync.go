@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/kyungw00k/apkpure-go/pkg/apkpure"
+)
+
+// syncStateFile is the default state file name inside the mirror
+const syncStateFile = "apkpure-state.json"
+
+// syncCommand implements "apkpure sync [flags] OUTDIR"
+func syncCommand(fs *flag.FlagSet) func(args []string) {
+	var (
+		appFlags    appFlags
+		optionFlags optionFlags
+		statePath   string
+		keepLast    int
+		keepSince   string
+	)
+	appFlags.register(fs)
+	optionFlags.register(fs)
+	optionFlags.registerDownload(fs)
+	fs.StringVar(&statePath, "state", "", "State file (default: OUTDIR/"+syncStateFile+")")
+	fs.IntVar(&keepLast, "keep-last", 0, "Keep only the newest N builds per app")
+	fs.StringVar(&keepSince, "keep-since", "", "Keep only builds released on or after this date (YYYY-MM-DD)")
+
+	return func(args []string) {
+		if len(args) != 1 {
+			exitUsageError(fs, "OUTDIR is required")
+		}
+		mirrorPath := args[0]
+		if err := validateOutPath(mirrorPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if statePath == "" {
+			statePath = filepath.Join(mirrorPath, syncStateFile)
+		}
+
+		apps := appFlags.apps()
+
+		retention := apkpure.RetentionPolicy{KeepLast: keepLast}
+		if keepLast < 0 {
+			exitUsageError(fs, "-keep-last must be 0 or greater")
+		}
+		if keepSince != "" {
+			var err error
+			retention.KeepSince, err = time.Parse(time.DateOnly, keepSince)
+			if err != nil {
+				exitUsageError(fs, "invalid -keep-since date: %v", err)
+			}
+		}
+
+		state, err := apkpure.LoadSyncState(statePath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if len(apps) == 0 && len(state.Apps) == 0 {
+			fmt.Println("Error: no apps tracked yet; add some with -a or -c")
+			os.Exit(exitFailure)
+		}
+
+		opts := optionFlags.settings(fs)
+		client := apkpure.NewClient(opts.DownloadOptions)
+
+		// Cancel in-flight work on Ctrl+C; finished builds are still recorded
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		report := client.SyncContext(ctx, state, apps, mirrorPath, retention)
+		if err := state.Save(statePath); err != nil {
+			fmt.Printf("Error saving state: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		if err := apkpure.WriteSyncReport(os.Stdout, report, opts.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		exitOnErrors(report.Errors())
+	}
+}
@@ -18,9 +18,13 @@ func main() {
 		{PackageID: "com.instagram.android"},
 	}
 
-	err := client.ListVersions(apps)
-	if err != nil {
-		log.Fatalf("Error listing versions: %v", err)
+	for _, listing := range client.GetVersions(apps) {
+		if listing.Error != nil {
+			log.Fatalf("Error listing versions: %v", listing.Error)
+		}
+		for _, v := range listing.Versions {
+			fmt.Printf("%s %s (%s)\n", listing.PackageID, v.VersionName, v.APKType)
+		}
 	}
 
 	fmt.Println()
@@ -40,7 +44,7 @@ func main() {
 		PackageID: "com.instagram.android",
 	}
 
-	err = client2.Download(app, ".")
+	err := client2.Download(app, ".")
 	if err != nil {
 		log.Fatalf("Error downloading: %v", err)
 	}
@@ -0,0 +1,696 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // registers SHA-384/512 for crypto.Hash
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// APK signature schemes reported in SignatureInfo.Schemes
+const (
+	SchemeV1 = 1
+	SchemeV2 = 2
+	SchemeV3 = 3
+)
+
+// SignatureInfo describes the verified signatures of an APK
+type SignatureInfo struct {
+	// Schemes lists the signature schemes that were present and verified
+	Schemes []int `json:"schemes"`
+	// SignerCertSHA256 holds the hex-encoded SHA-256 digests of the signer
+	// certificates of the strongest verified scheme
+	SignerCertSHA256 []string `json:"signer_cert_sha256"`
+	// PinMatched is true when a pinned certificate was configured for the
+	// package and one of the signers matched it
+	PinMatched bool `json:"pin_matched,omitempty"`
+}
+
+// IDs of blocks in the APK Signing Block
+const (
+	apkSigBlockV2ID = 0x7109871a
+	apkSigBlockV3ID = 0xf05368c0
+)
+
+const (
+	apkSigBlockMagic    = "APK Sig Block 42"
+	eocdSignature       = 0x06054b50
+	eocdMinSize         = 22
+	maxZipCommentSize   = 0xffff
+	contentDigestChunk  = 1 << 20
+	apkSigBlockMinSize  = 32
+	apkSigBlockFooterSz = 24
+)
+
+// errNoSigningBlock means the APK has no v2+ signatures
+var errNoSigningBlock = errors.New("no APK Signing Block")
+
+// errSchemeStripped means a v1 signature declares a v2+ signature that
+// is missing, as when the signing block was removed to tamper with the APK
+var errSchemeStripped = errors.New("declared APK signature is missing")
+
+// VerifyAPKSignature verifies the v1 (JAR), v2 and v3 signatures of the APK
+// at path. The strongest scheme present must verify; weaker schemes are
+// checked too and reported in Schemes when they pass.
+func VerifyAPKSignature(path string) (*SignatureInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyAPKSignature(file, stat.Size())
+}
+
+// verifyAPKSignature is VerifyAPKSignature for an open APK
+func verifyAPKSignature(r io.ReaderAt, size int64) (*SignatureInfo, error) {
+	info := &SignatureInfo{}
+
+	blocks, layout, err := readSigningBlock(r, size)
+	if err != nil && !errors.Is(err, errNoSigningBlock) {
+		return nil, err
+	}
+
+	var strongest error
+	for _, scheme := range []struct {
+		id      uint32
+		version int
+	}{{apkSigBlockV3ID, SchemeV3}, {apkSigBlockV2ID, SchemeV2}} {
+		block, ok := blocks[scheme.id]
+		if !ok {
+			continue
+		}
+
+		certs, err := verifySchemeBlock(r, layout, block, scheme.version)
+		if err != nil {
+			if len(info.Schemes) == 0 && strongest == nil {
+				strongest = fmt.Errorf("v%d signature: %w", scheme.version, err)
+			}
+			continue
+		}
+
+		info.Schemes = append(info.Schemes, scheme.version)
+		if info.SignerCertSHA256 == nil {
+			info.SignerCertSHA256 = certs
+		}
+	}
+	if strongest != nil {
+		return nil, strongest
+	}
+
+	certs, err := verifyJARSignature(r, size, info.Schemes)
+	switch {
+	case errors.Is(err, errSchemeStripped):
+		return nil, fmt.Errorf("v1 signature: %w", err)
+	case err == nil:
+		info.Schemes = append(info.Schemes, SchemeV1)
+		if info.SignerCertSHA256 == nil {
+			info.SignerCertSHA256 = certs
+		}
+	case len(info.Schemes) == 0:
+		return nil, fmt.Errorf("v1 signature: %w", err)
+	}
+
+	slices.Sort(info.Schemes)
+	return info, nil
+}
+
+// zipLayout locates the sections covered by v2+ content digests
+type zipLayout struct {
+	signingBlockOffset int64
+	cdOffset           int64
+	eocdOffset         int64
+	eocd               []byte
+}
+
+// readSigningBlock finds the APK Signing Block that precedes the central
+// directory and returns its ID-value pairs
+func readSigningBlock(r io.ReaderAt, size int64) (map[uint32][]byte, zipLayout, error) {
+	var layout zipLayout
+
+	// Find the End of Central Directory record
+	tailSize := min(size, int64(maxZipCommentSize+eocdMinSize))
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return nil, layout, err
+	}
+
+	eocdPos := -1
+	for i := len(tail) - eocdMinSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != eocdSignature {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+eocdMinSize+commentLen == len(tail) {
+			eocdPos = i
+			break
+		}
+	}
+	if eocdPos < 0 {
+		return nil, layout, errors.New("not a zip archive: end of central directory not found")
+	}
+
+	layout.eocd = tail[eocdPos:]
+	layout.eocdOffset = size - tailSize + int64(eocdPos)
+	layout.cdOffset = int64(binary.LittleEndian.Uint32(layout.eocd[16:]))
+	cdSize := int64(binary.LittleEndian.Uint32(layout.eocd[12:]))
+	if layout.cdOffset+cdSize != layout.eocdOffset {
+		return nil, layout, errors.New("malformed zip: central directory does not precede end record")
+	}
+
+	// The signing block ends with its size and magic right before the CD
+	if layout.cdOffset < apkSigBlockMinSize {
+		return nil, layout, errNoSigningBlock
+	}
+	footer := make([]byte, apkSigBlockFooterSz)
+	if _, err := r.ReadAt(footer, layout.cdOffset-apkSigBlockFooterSz); err != nil {
+		return nil, layout, err
+	}
+	if string(footer[8:]) != apkSigBlockMagic {
+		return nil, layout, errNoSigningBlock
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint64(footer))
+	layout.signingBlockOffset = layout.cdOffset - blockSize - 8
+	if blockSize < apkSigBlockMinSize-8 || layout.signingBlockOffset < 0 {
+		return nil, layout, errors.New("malformed APK Signing Block size")
+	}
+
+	block := make([]byte, blockSize+8)
+	if _, err := r.ReadAt(block, layout.signingBlockOffset); err != nil {
+		return nil, layout, err
+	}
+	if int64(binary.LittleEndian.Uint64(block)) != blockSize {
+		return nil, layout, errors.New("malformed APK Signing Block: size mismatch")
+	}
+
+	// ID-value pairs, each prefixed by a uint64 length
+	pairs := block[8 : len(block)-apkSigBlockFooterSz]
+	values := make(map[uint32][]byte)
+	for len(pairs) > 0 {
+		if len(pairs) < 12 {
+			return nil, layout, errors.New("malformed APK Signing Block pair")
+		}
+		pairLen := binary.LittleEndian.Uint64(pairs)
+		if pairLen < 4 || pairLen > uint64(len(pairs)-8) {
+			return nil, layout, errors.New("malformed APK Signing Block pair length")
+		}
+		id := binary.LittleEndian.Uint32(pairs[8:])
+		values[id] = pairs[12 : 8+pairLen]
+		pairs = pairs[8+pairLen:]
+	}
+
+	return values, layout, nil
+}
+
+// sigAlgorithm describes an APK Signature Scheme v2/v3 algorithm ID
+type sigAlgorithm struct {
+	hash crypto.Hash
+	// kind is "rsa-pss", "rsa-pkcs1" or "ecdsa"
+	kind string
+	// verity algorithms use a Merkle-tree content digest we don't compute
+	verity bool
+}
+
+var sigAlgorithms = map[uint32]sigAlgorithm{
+	0x0101: {crypto.SHA256, "rsa-pss", false},
+	0x0102: {crypto.SHA512, "rsa-pss", false},
+	0x0103: {crypto.SHA256, "rsa-pkcs1", false},
+	0x0104: {crypto.SHA512, "rsa-pkcs1", false},
+	0x0201: {crypto.SHA256, "ecdsa", false},
+	0x0202: {crypto.SHA512, "ecdsa", false},
+	0x0421: {crypto.SHA256, "rsa-pkcs1", true},
+	0x0422: {crypto.SHA256, "ecdsa", true},
+}
+
+// lpReader decodes the little-endian, length-prefixed structures used in
+// the APK Signing Block
+type lpReader struct {
+	buf []byte
+	err error
+}
+
+func (l *lpReader) uint32() uint32 {
+	if l.err != nil || len(l.buf) < 4 {
+		l.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(l.buf)
+	l.buf = l.buf[4:]
+	return v
+}
+
+func (l *lpReader) bytes() []byte {
+	n := l.uint32()
+	if l.err != nil || uint64(n) > uint64(len(l.buf)) {
+		l.fail()
+		return nil
+	}
+	v := l.buf[:n]
+	l.buf = l.buf[n:]
+	return v
+}
+
+func (l *lpReader) fail() {
+	if l.err == nil {
+		l.err = errors.New("malformed signature block")
+	}
+	l.buf = nil
+}
+
+// sequence splits a length-prefixed sequence of length-prefixed items
+func sequence(data []byte) ([][]byte, error) {
+	l := &lpReader{buf: data}
+	var items [][]byte
+	for len(l.buf) > 0 && l.err == nil {
+		items = append(items, l.bytes())
+	}
+	return items, l.err
+}
+
+// verifySchemeBlock verifies a v2 or v3 signature scheme block and returns
+// the SHA-256 digests of the signer certificates
+func verifySchemeBlock(r io.ReaderAt, layout zipLayout, block []byte, version int) ([]string, error) {
+	l := &lpReader{buf: block}
+	signers, err := sequence(l.bytes())
+	if err == nil {
+		err = l.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no signers")
+	}
+
+	digests := make(map[crypto.Hash][]byte)
+	var certs []string
+	for _, signer := range signers {
+		cert, err := verifySigner(r, layout, signer, version, digests)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// verifySigner verifies one signer of a v2/v3 block. Content digests are
+// memoized in digests since every signer covers the same bytes.
+func verifySigner(r io.ReaderAt, layout zipLayout, signer []byte, version int, digests map[crypto.Hash][]byte) (string, error) {
+	l := &lpReader{buf: signer}
+	signedData := l.bytes()
+	if version == SchemeV3 {
+		l.uint32() // minSdkVersion
+		l.uint32() // maxSdkVersion
+	}
+	signaturesRaw := l.bytes()
+	publicKeyDER := l.bytes()
+	if l.err != nil {
+		return "", l.err
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+
+	// Verify every signature we understand; at least one must exist
+	signatures, err := sequence(signaturesRaw)
+	if err != nil {
+		return "", err
+	}
+	var signatureAlgs []uint32
+	var best *sigAlgorithm
+	var bestID uint32
+	for _, raw := range signatures {
+		sl := &lpReader{buf: raw}
+		algID := sl.uint32()
+		sig := sl.bytes()
+		if sl.err != nil {
+			return "", sl.err
+		}
+		signatureAlgs = append(signatureAlgs, algID)
+
+		alg, ok := sigAlgorithms[algID]
+		if !ok {
+			continue
+		}
+		if err := verifyWithKey(publicKey, alg, signedData, sig); err != nil {
+			return "", fmt.Errorf("signature 0x%04x: %w", algID, err)
+		}
+		if !alg.verity && (best == nil || alg.hash > best.hash) {
+			best, bestID = &alg, algID
+		}
+	}
+	if len(signatureAlgs) == 0 {
+		return "", errors.New("no signatures")
+	}
+
+	// Signed data: digests, certificates, then scheme-specific fields
+	sd := &lpReader{buf: signedData}
+	digestsRaw := sd.bytes()
+	certsRaw := sd.bytes()
+	if sd.err != nil {
+		return "", sd.err
+	}
+
+	digestItems, err := sequence(digestsRaw)
+	if err != nil {
+		return "", err
+	}
+	signedDigests := make(map[uint32][]byte)
+	var digestAlgs []uint32
+	for _, raw := range digestItems {
+		dl := &lpReader{buf: raw}
+		algID := dl.uint32()
+		digest := dl.bytes()
+		if dl.err != nil {
+			return "", dl.err
+		}
+		digestAlgs = append(digestAlgs, algID)
+		signedDigests[algID] = digest
+	}
+	if !slices.Equal(digestAlgs, signatureAlgs) {
+		return "", errors.New("signature and digest algorithm lists differ")
+	}
+
+	certItems, err := sequence(certsRaw)
+	if err != nil {
+		return "", err
+	}
+	if len(certItems) == 0 {
+		return "", errors.New("no certificates")
+	}
+	cert, err := x509.ParseCertificate(certItems[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate: %w", err)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, publicKeyDER) {
+		return "", errors.New("public key does not match certificate")
+	}
+
+	if best == nil {
+		return "", errors.New("no supported signature algorithm")
+	}
+
+	// Check the content digest for the strongest non-verity algorithm
+	actual, ok := digests[best.hash]
+	if !ok {
+		actual, err = contentDigest(r, layout, best.hash)
+		if err != nil {
+			return "", err
+		}
+		digests[best.hash] = actual
+	}
+	if !bytes.Equal(actual, signedDigests[bestID]) {
+		return "", errors.New("content digest mismatch")
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifyWithKey checks sig over data with the given algorithm
+func verifyWithKey(publicKey any, alg sigAlgorithm, data, sig []byte) error {
+	h := alg.hash.New()
+	h.Write(data)
+	hashed := h.Sum(nil)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch alg.kind {
+		case "rsa-pss":
+			return rsa.VerifyPSS(key, alg.hash, hashed, sig, &rsa.PSSOptions{SaltLength: alg.hash.Size()})
+		case "rsa-pkcs1":
+			return rsa.VerifyPKCS1v15(key, alg.hash, hashed, sig)
+		}
+	case *ecdsa.PublicKey:
+		if alg.kind == "ecdsa" {
+			if !ecdsa.VerifyASN1(key, hashed, sig) {
+				return errors.New("ecdsa verification failure")
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key type %T does not match algorithm %s", publicKey, alg.kind)
+}
+
+// contentDigest computes the chunked v2/v3 digest over the zip entries,
+// the central directory and the end record (whose central directory
+// offset is rewritten to point at the signing block)
+func contentDigest(r io.ReaderAt, layout zipLayout, hash crypto.Hash) ([]byte, error) {
+	eocd := bytes.Clone(layout.eocd)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(layout.signingBlockOffset))
+
+	sections := []io.Reader{
+		io.NewSectionReader(r, 0, layout.signingBlockOffset),
+		io.NewSectionReader(r, layout.cdOffset, layout.eocdOffset-layout.cdOffset),
+		bytes.NewReader(eocd),
+	}
+	sizes := []int64{layout.signingBlockOffset, layout.eocdOffset - layout.cdOffset, int64(len(eocd))}
+
+	var chunkCount int64
+	for _, size := range sizes {
+		chunkCount += (size + contentDigestChunk - 1) / contentDigestChunk
+	}
+
+	top := hash.New()
+	header := make([]byte, 5)
+	header[0] = 0x5a
+	binary.LittleEndian.PutUint32(header[1:], uint32(chunkCount))
+	top.Write(header)
+
+	chunk := make([]byte, contentDigestChunk)
+	h := hash.New()
+	for i, section := range sections {
+		remaining := sizes[i]
+		for remaining > 0 {
+			n := min(remaining, contentDigestChunk)
+			if _, err := io.ReadFull(section, chunk[:n]); err != nil {
+				return nil, err
+			}
+			remaining -= n
+
+			h.Reset()
+			header[0] = 0xa5
+			binary.LittleEndian.PutUint32(header[1:], uint32(n))
+			h.Write(header)
+			h.Write(chunk[:n])
+			top.Write(h.Sum(nil))
+		}
+	}
+
+	return top.Sum(nil), nil
+}
+
+// verifyJARSignature verifies the v1 signature of every signer in
+// META-INF and returns the SHA-256 digests of their certificates. Every
+// v2+ scheme a signer declares must be among the verified schemes.
+func verifyJARSignature(r io.ReaderAt, size int64, verified []int) ([]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifestFile, ok := files["META-INF/MANIFEST.MF"]
+	if !ok {
+		return nil, errors.New("no META-INF/MANIFEST.MF")
+	}
+	manifest, err := readZipFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []string
+	for _, f := range zr.File {
+		name := f.Name
+		if !strings.HasPrefix(name, "META-INF/") || strings.Count(name, "/") != 1 || !strings.HasSuffix(name, ".SF") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".SF")
+
+		var blockFile *zip.File
+		for _, ext := range []string{".RSA", ".EC", ".DSA"} {
+			if bf, ok := files[base+ext]; ok {
+				blockFile = bf
+				break
+			}
+		}
+		if blockFile == nil {
+			return nil, fmt.Errorf("no signature block for %s", name)
+		}
+
+		sf, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		block, err := readZipFile(blockFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := verifyPKCS7Detached(block, sf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", blockFile.Name, err)
+		}
+		if err := verifySignatureFile(sf, manifest); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, scheme := range apkSignedSchemes(sf) {
+			if !slices.Contains(verified, scheme) {
+				return nil, fmt.Errorf("%s: %w: v%d", name, errSchemeStripped, scheme)
+			}
+		}
+
+		sum := sha256.Sum256(cert.Raw)
+		certs = append(certs, hex.EncodeToString(sum[:]))
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no JAR signature")
+	}
+
+	if err := verifyManifestEntries(manifest, zr.File); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+// readZipFile reads a whole zip entry into memory
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	return io.ReadAll(rc)
+}
+
+// NormalizeCertDigest lowercases a certificate digest and strips the colons
+// used by keytool and apksigner output
+func NormalizeCertDigest(digest string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(digest), ":", ""))
+}
+
+// verifySignature runs the configured signature checks on a downloaded
+// file. It returns nil info when verification isn't enabled for the
+// package; on failure the file is quarantined.
+func (c *Client) verifySignature(packageID, path, apkType string) (*SignatureInfo, error) {
+	pins := c.options.PinnedCertificates[packageID]
+	if !c.options.VerifySignature && len(pins) == 0 {
+		return nil, nil
+	}
+
+	var info *SignatureInfo
+	var err error
+	if apkType == "XAPK" {
+		info, err = verifyXAPKSignature(path)
+	} else {
+		info, err = VerifyAPKSignature(path)
+	}
+
+	var verifyErr *VerificationError
+	switch {
+	case err != nil:
+		verifyErr = &VerificationError{Path: path, Check: "signature", Err: err}
+	case len(pins) > 0:
+		for _, pin := range pins {
+			if slices.Contains(info.SignerCertSHA256, NormalizeCertDigest(pin)) {
+				info.PinMatched = true
+				break
+			}
+		}
+		if !info.PinMatched {
+			verifyErr = &VerificationError{
+				Path:     path,
+				Check:    "certificate pin",
+				Expected: strings.Join(pins, ","),
+				Actual:   strings.Join(info.SignerCertSHA256, ","),
+			}
+		}
+	}
+
+	if verifyErr != nil {
+		return nil, quarantine(path, path, verifyErr)
+	}
+
+	c.logf("Verified v%v signature of %s\n", info.Schemes, filepath.Base(path))
+	return info, nil
+}
+
+// verifyXAPKSignature verifies every APK inside an XAPK. All of them must
+// be signed by the same certificates.
+func verifyXAPKSignature(path string) (*SignatureInfo, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	var combined *SignatureInfo
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".apk") {
+			continue
+		}
+
+		info, err := verifyZipEntrySignature(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		if combined == nil {
+			combined = info
+			continue
+		}
+		if !slices.Equal(combined.SignerCertSHA256, info.SignerCertSHA256) {
+			return nil, fmt.Errorf("%s: signed by a different certificate", f.Name)
+		}
+		// Only report schemes every split was signed with
+		combined.Schemes = slices.DeleteFunc(combined.Schemes, func(s int) bool {
+			return !slices.Contains(info.Schemes, s)
+		})
+	}
+	if combined == nil {
+		return nil, errors.New("no APKs in XAPK")
+	}
+
+	return combined, nil
+}
+
+// verifyZipEntrySignature copies an APK out of a zip into a temporary
+// file, since signature verification needs random access
+func verifyZipEntrySignature(f *zip.File) (*SignatureInfo, error) {
+	var info *SignatureInfo
+	err := withZipEntryFile(f, func(file *os.File, size int64) error {
+		var err error
+		info, err = verifyAPKSignature(file, size)
+		return err
+	})
+	return info, err
+}
@@ -0,0 +1,324 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigner is a key and self-signed certificate for signing test APKs
+type testSigner struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+// newTestSigner creates a P-256 key and certificate
+func newTestSigner(t *testing.T) testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "apkpure-go test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{key: key, cert: cert}
+}
+
+// certDigest returns the hex SHA-256 of the signer's certificate, as
+// SignatureInfo reports it
+func (s testSigner) certDigest() string {
+	sum := sha256.Sum256(s.cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// v1Options shapes a test JAR signature
+type v1Options struct {
+	// sfMain is added to the main section of the .SF file
+	sfMain string
+	// unsigned entries are stored in the zip but not in the manifest
+	unsigned []jarEntry
+	// extraSections are appended to the manifest after signing, with an
+	// entry of the same name added to the zip
+	extraSections []jarEntry
+	// replace stores different content for the named entries than was
+	// signed
+	replace map[string][]byte
+}
+
+// buildV1APK writes entries as a zip with a v1 signature
+func buildV1APK(t *testing.T, signer testSigner, entries []jarEntry, opts v1Options) []byte {
+	t.Helper()
+
+	var manifest, sections bytes.Buffer
+	manifest.WriteString("Manifest-Version: 1.0\r\n\r\n")
+	for _, entry := range entries {
+		section := fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", entry.name, sha256Base64(entry.data))
+		manifest.WriteString(section)
+		fmt.Fprintf(&sections, "Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", entry.name, sha256Base64([]byte(section)))
+	}
+
+	var sf bytes.Buffer
+	sf.WriteString("Signature-Version: 1.0\r\n" + opts.sfMain)
+	fmt.Fprintf(&sf, "SHA-256-Digest-Manifest: %s\r\n\r\n", sha256Base64(manifest.Bytes()))
+	sf.Write(sections.Bytes())
+
+	block, err := signPKCS7Detached(sf.Bytes(), signer.key, signer.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range opts.extraSections {
+		fmt.Fprintf(&manifest, "Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", entry.name, sha256Base64(entry.data))
+	}
+
+	all := slices.Concat([]jarEntry{
+		{"META-INF/MANIFEST.MF", manifest.Bytes()},
+		{"META-INF/CERT.SF", sf.Bytes()},
+		{"META-INF/CERT.EC", block},
+	}, entries, opts.unsigned, opts.extraSections)
+	for i, entry := range all {
+		if data, ok := opts.replace[entry.name]; ok {
+			all[i].data = data
+		}
+	}
+	return buildZip(t, all)
+}
+
+// buildZip stores entries uncompressed in a zip archive, so tests can find
+// and alter their content
+func buildZip(t *testing.T, entries []jarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// lengthPrefixed joins parts, each prefixed by its uint32 length
+func lengthPrefixed(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(part)))
+		out = append(out, part...)
+	}
+	return out
+}
+
+// uint32LE encodes v as four little-endian bytes
+func uint32LE(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// addSigningBlock inserts an APK Signing Block with one v2 or v3 signer
+// (ECDSA with SHA-256) per scheme before the central directory of apk
+func addSigningBlock(t *testing.T, apk []byte, signer testSigner, schemes ...int) []byte {
+	t.Helper()
+
+	const algECDSASHA256 = 0x0201
+	r := bytes.NewReader(apk)
+	_, layout, err := readSigningBlock(r, int64(len(apk)))
+	if !errors.Is(err, errNoSigningBlock) {
+		t.Fatalf("readSigningBlock: %v", err)
+	}
+	layout.signingBlockOffset = layout.cdOffset
+	digest, err := contentDigest(r, layout, 5) // crypto.SHA256
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&signer.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pairs []byte
+	for _, scheme := range schemes {
+		signedData := lengthPrefixed(
+			lengthPrefixed(slices.Concat(uint32LE(algECDSASHA256), lengthPrefixed(digest))),
+			lengthPrefixed(signer.cert.Raw),
+		)
+		if scheme == SchemeV3 {
+			signedData = slices.Concat(signedData, uint32LE(24), uint32LE(0x7fffffff))
+		}
+		signedData = slices.Concat(signedData, lengthPrefixed(nil))
+
+		hashed := sha256.Sum256(signedData)
+		sig, err := ecdsa.SignASN1(rand.Reader, signer.key, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signerData := lengthPrefixed(signedData)
+		if scheme == SchemeV3 {
+			signerData = slices.Concat(signerData, uint32LE(24), uint32LE(0x7fffffff))
+		}
+		signerData = slices.Concat(signerData,
+			lengthPrefixed(lengthPrefixed(slices.Concat(uint32LE(algECDSASHA256), lengthPrefixed(sig)))),
+			lengthPrefixed(publicKey),
+		)
+		value := lengthPrefixed(lengthPrefixed(signerData))
+
+		id := uint32(apkSigBlockV2ID)
+		if scheme == SchemeV3 {
+			id = apkSigBlockV3ID
+		}
+		pairs = binary.LittleEndian.AppendUint64(pairs, uint64(4+len(value)))
+		pairs = slices.Concat(pairs, uint32LE(id), value)
+	}
+
+	size := uint64(len(pairs) + apkSigBlockFooterSz)
+	block := binary.LittleEndian.AppendUint64(nil, size)
+	block = append(block, pairs...)
+	block = binary.LittleEndian.AppendUint64(block, size)
+	block = append(block, apkSigBlockMagic...)
+
+	signed := slices.Concat(apk[:layout.cdOffset], block, apk[layout.cdOffset:])
+	eocd := len(signed) - len(layout.eocd)
+	binary.LittleEndian.PutUint32(signed[eocd+16:], uint32(layout.cdOffset)+uint32(len(block)))
+	return signed
+}
+
+// flipByte returns a copy of data with the first occurrence of marker
+// altered
+func flipByte(t *testing.T, data []byte, marker string) []byte {
+	t.Helper()
+	i := bytes.Index(data, []byte(marker))
+	if i < 0 {
+		t.Fatalf("marker %q not found", marker)
+	}
+	out := bytes.Clone(data)
+	out[i] ^= 0xff
+	return out
+}
+
+func TestVerifyAPKSignature(t *testing.T) {
+	signer := newTestSigner(t)
+	entries := []jarEntry{
+		{"AndroidManifest.xml", []byte("manifest-payload")},
+		{"classes.dex", []byte("dex-payload")},
+	}
+	v1 := buildV1APK(t, signer, entries, v1Options{})
+	v1SignedV2 := buildV1APK(t, signer, entries, v1Options{sfMain: "X-Android-APK-Signed: 2\r\n"})
+	v1SignedV23 := buildV1APK(t, signer, entries, v1Options{sfMain: "X-Android-APK-Signed: 2, 3\r\n"})
+	unsigned := buildZip(t, entries)
+
+	tests := []struct {
+		name    string
+		apk     []byte
+		schemes []int
+		// wantErr is a substring of the expected error, or "" for success
+		wantErr string
+	}{
+		{name: "v1", apk: v1, schemes: []int{SchemeV1}},
+		{name: "v1 and v2", apk: addSigningBlock(t, v1SignedV2, signer, SchemeV2), schemes: []int{SchemeV1, SchemeV2}},
+		{name: "v2 only", apk: addSigningBlock(t, unsigned, signer, SchemeV2), schemes: []int{SchemeV2}},
+		{name: "v3 only", apk: addSigningBlock(t, unsigned, signer, SchemeV3), schemes: []int{SchemeV3}},
+		{
+			name:    "v1, v2 and v3",
+			apk:     addSigningBlock(t, v1SignedV23, signer, SchemeV3, SchemeV2),
+			schemes: []int{SchemeV1, SchemeV2, SchemeV3},
+		},
+		{name: "unsigned", apk: unsigned, wantErr: "no META-INF/MANIFEST.MF"},
+		{
+			name:    "v1 entry modified",
+			apk:     buildV1APK(t, signer, entries, v1Options{replace: map[string][]byte{"classes.dex": []byte("evil-payload")}}),
+			wantErr: "entry classes.dex digest mismatch",
+		},
+		{
+			name:    "v1 unsigned entry added",
+			apk:     buildV1APK(t, signer, entries, v1Options{unsigned: []jarEntry{{"evil.dex", []byte("x")}}}),
+			wantErr: "entry evil.dex is not signed",
+		},
+		{
+			name:    "v1 entry and manifest section added",
+			apk:     buildV1APK(t, signer, entries, v1Options{extraSections: []jarEntry{{"evil.dex", []byte("x")}}}),
+			wantErr: "manifest entry evil.dex is not covered",
+		},
+		{
+			name:    "v1 signature file modified",
+			apk:     buildV1APK(t, signer, entries, v1Options{replace: map[string][]byte{"META-INF/CERT.SF": []byte("Signature-Version: 1.0\r\n\r\n")}}),
+			wantErr: "CERT.EC",
+		},
+		{name: "v2 stripped", apk: v1SignedV2, wantErr: "declared APK signature is missing: v2"},
+		{
+			name:    "v3 stripped",
+			apk:     addSigningBlock(t, v1SignedV23, signer, SchemeV2),
+			wantErr: "declared APK signature is missing: v3",
+		},
+		{
+			name:    "v2 content modified",
+			apk:     flipByte(t, addSigningBlock(t, unsigned, signer, SchemeV2), "dex-payload"),
+			wantErr: "v2 signature: content digest mismatch",
+		},
+		{
+			name:    "v3 content modified",
+			apk:     flipByte(t, addSigningBlock(t, v1SignedV23, signer, SchemeV3, SchemeV2), "dex-payload"),
+			wantErr: "v3 signature: content digest mismatch",
+		},
+		{
+			name:    "v2 block corrupted",
+			apk:     flipByte(t, addSigningBlock(t, unsigned, signer, SchemeV2), "apkpure-go test"),
+			wantErr: "v2 signature",
+		},
+		{name: "truncated", apk: v1[:len(v1)/2], wantErr: "not a zip archive"},
+		{name: "empty", apk: nil, wantErr: "not a zip archive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := verifyAPKSignature(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(info.Schemes, tt.schemes) {
+				t.Errorf("schemes %v, want %v", info.Schemes, tt.schemes)
+			}
+			if !slices.Equal(info.SignerCertSHA256, []string{signer.certDigest()}) {
+				t.Errorf("signer certificates %v, want %s", info.SignerCertSHA256, signer.certDigest())
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package apkpure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AppDetails is the store listing of a package. Fields the API omits are
+// left at their zero value.
+type AppDetails struct {
+	PackageID   string  `json:"package_id"`
+	Title       string  `json:"title"`
+	Developer   string  `json:"developer,omitempty"`
+	Category    string  `json:"category,omitempty"`
+	Rating      float64 `json:"rating,omitempty"`
+	RatingCount int64   `json:"rating_count,omitempty"`
+	Description string  `json:"description,omitempty"`
+	IconURL     string  `json:"icon_url,omitempty"`
+	// Size is the download size of the latest version in bytes
+	Size              int64     `json:"size,omitempty"`
+	LatestVersion     string    `json:"latest_version,omitempty"`
+	LatestVersionCode string    `json:"latest_version_code,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at,omitzero"`
+	// Extra holds API fields not mapped above, as raw JSON
+	Extra map[string]json.RawMessage `json:"extra,omitempty"`
+}
+
+// GetAppDetails fetches the title, developer, category, rating,
+// description, icon and latest version of a package
+func (c *Client) GetAppDetails(packageID string) (*AppDetails, error) {
+	return c.GetAppDetailsContext(context.Background(), packageID)
+}
+
+// GetAppDetailsContext is like GetAppDetails but honours ctx cancellation.
+// When the listing does not name the latest version, it is taken from the
+// version API.
+func (c *Client) GetAppDetailsContext(ctx context.Context, packageID string) (*AppDetails, error) {
+	url := c.getAppDetailsURL(packageID)
+	key := c.cacheKey(url)
+
+	details, err := fetchCached(ctx, c, OpFetchAppDetails, "details of "+packageID, packageID, url, key, func(body []byte) (*AppDetails, error) {
+		return parseAppDetails(packageID, body)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch app details: %w", err)
+	}
+
+	if details.LatestVersion == "" {
+		latest, err := c.ResolveVersionContext(ctx, AppInfo{PackageID: packageID})
+		if err != nil {
+			return nil, err
+		}
+		details.LatestVersion = latest.VersionName
+		details.LatestVersionCode = latest.VersionCode
+		if details.Size == 0 {
+			details.Size = latest.Size
+		}
+		if details.UpdatedAt.IsZero() {
+			details.UpdatedAt = latest.releaseDate()
+		}
+	}
+
+	return details, nil
+}
+
+// parseAppDetails decodes an app detail response; a listing without a
+// title means the package is unknown
+func parseAppDetails(packageID string, body []byte) (*AppDetails, error) {
+	var fields rawFields
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	details := appDetailsFromFields(fields)
+	if details.Title == "" {
+		return nil, &PackageNotFoundError{PackageID: packageID}
+	}
+	if details.PackageID == "" {
+		details.PackageID = packageID
+	}
+	return details, nil
+}
+
+// appDetailsFromFields maps an app object of the API, as found in detail
+// and search responses, consuming the fields it uses
+func appDetailsFromFields(fields rawFields) *AppDetails {
+	details := &AppDetails{
+		PackageID:         fields.str("package_name"),
+		Title:             fields.str("title"),
+		Developer:         fields.str("developer"),
+		Category:          fields.nestedStr("name", "category"),
+		Rating:            fields.float("score"),
+		RatingCount:       fields.int("score_total"),
+		Description:       fields.str("description"),
+		IconURL:           fields.nestedStr("url", "icon"),
+		Size:              fields.int("size"),
+		LatestVersion:     fields.str("version_name"),
+		LatestVersionCode: fields.str("version_code"),
+	}
+
+	updated := fields.str("update_date")
+	var ok bool
+	if details.UpdatedAt, ok = parseAPIDate(updated); !ok && updated != "" {
+		fields["update_date"], _ = json.Marshal(updated)
+	}
+
+	// The latest version's asset carries the size when the listing does not
+	if raw, ok := fields["asset"]; ok && details.Size == 0 {
+		var asset APIAsset
+		if err := json.Unmarshal(raw, &asset); err == nil {
+			details.Size = asset.Size
+		}
+	}
+
+	if len(fields) > 0 {
+		details.Extra = fields
+	}
+	return details
+}
@@ -0,0 +1,87 @@
+package apkpure
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appDetailResponse is a get_app_detail response body as tapi sends it:
+// the listing is the top-level object
+const appDetailResponse = `{
+  "package_name": "com.example.app",
+  "title": "Example App",
+  "developer": "Example Inc.",
+  "category": {"id": "TOOLS", "name": "Tools"},
+  "score": 4.6,
+  "score_total": "12345",
+  "description": "Does example things.",
+  "icon": {"url": "https://image.winudf.com/v2/image/icon.png"},
+  "version_name": "1.5.0",
+  "version_code": "150",
+  "update_date": "2024-05-01 10:00:00",
+  "asset": {"url": "https://download.pureapk.com/b/APK/com.example.app", "type": "APK", "size": 2048},
+  "whatsnew": "Bug fixes"
+}`
+
+func TestGetAppDetails(t *testing.T) {
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example.app":   {{name: "1.5.0", code: "150", content: testPayload(10, 1)}},
+		"com.example.other": {{name: "2.0", code: "20", content: testPayload(4096, 1)}},
+	})
+	api.details = map[string]string{
+		"com.example.app":      appDetailResponse,
+		"com.example.other":    `{"package_name": "com.example.other", "title": "Other"}`,
+		"com.example.untitled": `{"package_name": "com.example.untitled"}`,
+	}
+	client := newTestClient(api, DownloadOptions{})
+
+	details, err := client.GetAppDetails("com.example.app")
+	if err != nil {
+		t.Fatalf("GetAppDetails: %v", err)
+	}
+	want := AppDetails{
+		PackageID:         "com.example.app",
+		Title:             "Example App",
+		Developer:         "Example Inc.",
+		Category:          "Tools",
+		Rating:            4.6,
+		RatingCount:       12345,
+		Description:       "Does example things.",
+		IconURL:           "https://image.winudf.com/v2/image/icon.png",
+		Size:              2048,
+		LatestVersion:     "1.5.0",
+		LatestVersionCode: "150",
+		UpdatedAt:         time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	}
+	got := *details
+	got.Extra = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	extra := slices.Sorted(maps.Keys(details.Extra))
+	if !slices.Equal(extra, []string{"asset", "whatsnew"}) {
+		t.Errorf("extra fields %v, want asset and whatsnew", extra)
+	}
+
+	// A listing without a version takes it from the version API
+	details, err = client.GetAppDetails("com.example.other")
+	if err != nil {
+		t.Fatalf("GetAppDetails: %v", err)
+	}
+	if details.LatestVersion != "2.0" || details.LatestVersionCode != "20" || details.Size != 4096 {
+		t.Errorf("got version %s (%s), size %d; want 2.0 (20), 4096", details.LatestVersion, details.LatestVersionCode, details.Size)
+	}
+
+	for _, packageID := range []string{"com.example.untitled", "com.example.missing"} {
+		_, err := client.GetAppDetails(packageID)
+		var notFound *PackageNotFoundError
+		if !errors.As(err, &notFound) || !strings.Contains(err.Error(), packageID) {
+			t.Errorf("%s: got error %v, want a *PackageNotFoundError", packageID, err)
+		}
+	}
+}
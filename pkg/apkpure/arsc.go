@@ -0,0 +1,220 @@
+package apkpure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Flags of resource table type chunks and entries
+const (
+	typeFlagSparse   = 0x01
+	typeFlagOffset16 = 0x02
+	entryFlagComplex = 0x0001
+	entryFlagCompact = 0x0008
+	noEntry          = 0xffffffff
+)
+
+// resourceTable indexes the simple values of a resources.arsc so manifest
+// attributes that reference resources can be resolved
+type resourceTable struct {
+	strings stringPool
+	// values maps a resource ID to its value in every configuration, with
+	// the default configuration first when present
+	values map[uint32][]resourceValue
+}
+
+// resourceValue is a Res_value from a resource table entry
+type resourceValue struct {
+	dataType uint8
+	data     uint32
+}
+
+// parseResourceTable decodes a resources.arsc file
+func parseResourceTable(data []byte) (*resourceTable, error) {
+	r := &chunkReader{data: data}
+	if r.u16(0) != resTableType {
+		return nil, errors.New("not a resource table")
+	}
+	headerSize := int(r.u16(2))
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	table := &resourceTable{values: make(map[uint32][]resourceValue)}
+	err := walkChunks(data, headerSize, func(chunkType uint16, chunk []byte) error {
+		switch chunkType {
+		case resStringPoolType:
+			var err error
+			table.strings, err = parseStringPool(chunk)
+			return err
+		case resTablePackage:
+			return table.parsePackage(chunk)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// walkChunks calls fn for each chunk in data starting at off
+func walkChunks(data []byte, off int, fn func(chunkType uint16, chunk []byte) error) error {
+	r := &chunkReader{data: data}
+	for off+8 <= len(data) {
+		chunkType := r.u16(off)
+		size := int(r.u32(off + 4))
+		if size < 8 || off+size > len(data) {
+			return fmt.Errorf("malformed chunk at offset %d", off)
+		}
+		if err := fn(chunkType, data[off:off+size]); err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+// parsePackage indexes the type chunks of a package chunk
+func (t *resourceTable) parsePackage(chunk []byte) error {
+	r := &chunkReader{data: chunk}
+	headerSize := int(r.u16(2))
+	packageID := r.u32(8)
+	if r.err != nil {
+		return r.err
+	}
+
+	return walkChunks(chunk, headerSize, func(chunkType uint16, typeChunk []byte) error {
+		if chunkType != resTableTypeType {
+			return nil
+		}
+		return t.parseType(packageID, typeChunk)
+	})
+}
+
+// parseType records the simple values of one type chunk
+func (t *resourceTable) parseType(packageID uint32, chunk []byte) error {
+	r := &chunkReader{data: chunk}
+	headerSize := int(r.u16(2))
+	typeID := uint32(r.u8(8))
+	flags := r.u8(9)
+	entryCount := int(r.u32(12))
+	entriesStart := int(r.u32(16))
+	defaultConfig := isDefaultConfig(r, 20, headerSize)
+	if r.err != nil {
+		return r.err
+	}
+	if entryCount > len(chunk) {
+		return errors.New("type entry count out of range")
+	}
+
+	for i := 0; i < entryCount; i++ {
+		var index int
+		var offset uint32
+		switch {
+		case flags&typeFlagSparse != 0:
+			index = int(r.u16(headerSize + i*4))
+			offset = uint32(r.u16(headerSize+i*4+2)) * 4
+		case flags&typeFlagOffset16 != 0:
+			index = i
+			offset = uint32(r.u16(headerSize + i*2))
+			if offset == 0xffff {
+				continue
+			}
+			offset *= 4
+		default:
+			index = i
+			offset = r.u32(headerSize + i*4)
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if offset == noEntry {
+			continue
+		}
+
+		entry := entriesStart + int(offset)
+		entryFlags := r.u16(entry + 2)
+		var value resourceValue
+		switch {
+		case entryFlags&entryFlagCompact != 0:
+			// Compact entries keep the data type in the high byte of flags
+			value = resourceValue{dataType: uint8(entryFlags >> 8), data: r.u32(entry + 4)}
+		case entryFlags&entryFlagComplex != 0:
+			continue
+		default:
+			valueOff := entry + int(r.u16(entry))
+			value = resourceValue{dataType: r.u8(valueOff + 3), data: r.u32(valueOff + 4)}
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		id := packageID<<24 | typeID<<16 | uint32(index)
+		if defaultConfig {
+			t.values[id] = append([]resourceValue{value}, t.values[id]...)
+		} else {
+			t.values[id] = append(t.values[id], value)
+		}
+	}
+
+	return nil
+}
+
+// isDefaultConfig reports whether the ResTable_config at off is the
+// default configuration, i.e. every field after its size is zero
+func isDefaultConfig(r *chunkReader, off, end int) bool {
+	size := int(r.u32(off))
+	if off+size > end {
+		size = end - off
+	}
+	for i := off + 4; i < off+size; i++ {
+		if r.u8(i) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the preferred value of a resource, following references
+func (t *resourceTable) lookup(id uint32) (resourceValue, bool) {
+	for depth := 0; depth < 8; depth++ {
+		values := t.values[id]
+		if len(values) == 0 {
+			return resourceValue{}, false
+		}
+		if values[0].dataType != typeReference {
+			return values[0], true
+		}
+		id = values[0].data
+	}
+	return resourceValue{}, false
+}
+
+// resolveString returns the string value of a resource
+func (t *resourceTable) resolveString(id uint32) (string, bool) {
+	v, ok := t.lookup(id)
+	if !ok {
+		return "", false
+	}
+	switch v.dataType {
+	case typeString:
+		return t.strings.get(v.data), true
+	case typeIntDec:
+		return fmt.Sprint(int32(v.data)), true
+	}
+	return "", false
+}
+
+// resolveInt returns the integer value of a resource
+func (t *resourceTable) resolveInt(id uint32) (uint32, bool) {
+	v, ok := t.lookup(id)
+	if !ok {
+		return 0, false
+	}
+	switch v.dataType {
+	case typeIntDec, typeIntHex, typeBoolean:
+		return v.data, true
+	}
+	return 0, false
+}
@@ -0,0 +1,572 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Chunk types used by Android binary XML and resource tables
+const (
+	resStringPoolType  = 0x0001
+	resTableType       = 0x0002
+	resXMLType         = 0x0003
+	resXMLStartElement = 0x0102
+	resXMLEndElement   = 0x0103
+	resXMLResourceMap  = 0x0180
+	resTablePackage    = 0x0200
+	resTableTypeType   = 0x0201
+)
+
+// Typed value data types
+const (
+	typeReference = 0x01
+	typeString    = 0x03
+	typeIntDec    = 0x10
+	typeIntHex    = 0x11
+	typeBoolean   = 0x12
+)
+
+// Framework attribute resource IDs, used when attribute names have been
+// stripped from the string pool
+const (
+	attrLabel            = 0x01010001
+	attrName             = 0x01010003
+	attrMinSDKVersion    = 0x0101020c
+	attrVersionCode      = 0x0101021b
+	attrVersionName      = 0x0101021c
+	attrTargetSDKVersion = 0x01010270
+)
+
+// ManifestInfo holds the fields read from an APK's AndroidManifest.xml
+type ManifestInfo struct {
+	PackageName string `json:"package_name"`
+	VersionCode int64  `json:"version_code"`
+	VersionName string `json:"version_name"`
+	// Label is the application label, resolved through resources.arsc
+	Label       string   `json:"label,omitempty"`
+	MinSDK      int      `json:"min_sdk,omitempty"`
+	TargetSDK   int      `json:"target_sdk,omitempty"`
+	Permissions []string `json:"permissions"`
+	Activities  []string `json:"activities"`
+	Services    []string `json:"services"`
+	Receivers   []string `json:"receivers"`
+	Providers   []string `json:"providers"`
+}
+
+// ParseAPKManifest reads the manifest of the APK at path. String
+// references (e.g. a versionName of @string/version) are resolved through
+// resources.arsc.
+func ParseAPKManifest(path string) (*ManifestInfo, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	return parseAPKManifest(&zr.Reader)
+}
+
+// ParseXAPKManifest reads the manifest of the base APK inside the XAPK at
+// path
+func ParseXAPKManifest(path string) (*ManifestInfo, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	base, err := xapkBaseEntry(&zr.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *ManifestInfo
+	err = withZipEntryFile(base, func(f *os.File, size int64) error {
+		apk, err := zip.NewReader(f, size)
+		if err != nil {
+			return err
+		}
+		info, err = parseAPKManifest(apk)
+		return err
+	})
+	return info, err
+}
+
+// parseAPKManifest reads AndroidManifest.xml and resources.arsc from an
+// opened APK
+func parseAPKManifest(zr *zip.Reader) (*ManifestInfo, error) {
+	var manifest, resources []byte
+	for _, f := range zr.File {
+		var err error
+		switch f.Name {
+		case "AndroidManifest.xml":
+			manifest, err = readZipFile(f)
+		case "resources.arsc":
+			resources, err = readZipFile(f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("APK has no AndroidManifest.xml")
+	}
+
+	var table *resourceTable
+	if resources != nil {
+		var err error
+		if table, err = parseResourceTable(resources); err != nil {
+			return nil, fmt.Errorf("invalid resources.arsc: %w", err)
+		}
+	}
+
+	return parseManifestXML(manifest, table)
+}
+
+// xapkBaseEntry finds the base APK in an XAPK using its manifest.json
+func xapkBaseEntry(zr *zip.Reader) (*zip.File, error) {
+	files := make(map[string]*zip.File, len(zr.File))
+	var apks []*zip.File
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if strings.HasSuffix(strings.ToLower(f.Name), ".apk") {
+			apks = append(apks, f)
+		}
+	}
+
+	if mf, ok := files[xapkManifestName]; ok {
+		data, err := readZipFile(mf)
+		if err != nil {
+			return nil, err
+		}
+		var manifest xapkManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid XAPK %s: %w", xapkManifestName, err)
+		}
+		for _, split := range manifest.SplitAPKs {
+			if split.ID == "base" {
+				if f, ok := files[split.File]; ok {
+					return f, nil
+				}
+			}
+		}
+		if f, ok := files[manifest.PackageName+".apk"]; ok {
+			return f, nil
+		}
+	}
+
+	if len(apks) == 1 {
+		return apks[0], nil
+	}
+	return nil, errors.New("XAPK has no base APK")
+}
+
+// withZipEntryFile copies a zip entry into a temporary file and passes it
+// to fn, for consumers that need random access
+func withZipEntryFile(f *zip.File, fn func(file *os.File, size int64) error) error {
+	tmp, err := os.CreateTemp("", "apkpure-*.apk")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(tmp, rc)
+	_ = rc.Close()
+	if err != nil {
+		return err
+	}
+
+	return fn(tmp, size)
+}
+
+// chunkReader reads little-endian values with bounds checking; after the
+// first out-of-range read every value is zero and err is set
+type chunkReader struct {
+	data []byte
+	err  error
+}
+
+func (r *chunkReader) u8(off int) uint8 {
+	if !r.check(off, 1) {
+		return 0
+	}
+	return r.data[off]
+}
+
+func (r *chunkReader) u16(off int) uint16 {
+	if !r.check(off, 2) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(r.data[off:])
+}
+
+func (r *chunkReader) u32(off int) uint32 {
+	if !r.check(off, 4) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.data[off:])
+}
+
+func (r *chunkReader) check(off, n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if off < 0 || n < 0 || off+n > len(r.data) {
+		r.err = errors.New("truncated chunk")
+		return false
+	}
+	return true
+}
+
+// stringPool is a decoded ResStringPool
+type stringPool []string
+
+// parseStringPool decodes the string pool chunk at the start of data
+func parseStringPool(data []byte) (stringPool, error) {
+	r := &chunkReader{data: data}
+	headerSize := int(r.u16(2))
+	count := int(r.u32(8))
+	flags := r.u32(16)
+	stringsStart := int(r.u32(20))
+	if r.err != nil {
+		return nil, r.err
+	}
+	if count > len(data)/4 {
+		return nil, errors.New("string pool count out of range")
+	}
+
+	utf8 := flags&(1<<8) != 0
+	pool := make(stringPool, count)
+	for i := range pool {
+		off := stringsStart + int(r.u32(headerSize+i*4))
+		if utf8 {
+			pool[i] = readUTF8String(r, off)
+		} else {
+			pool[i] = readUTF16String(r, off)
+		}
+	}
+
+	return pool, r.err
+}
+
+// readUTF8String decodes a length-prefixed UTF-8 pool string
+func readUTF8String(r *chunkReader, off int) string {
+	// UTF-16 length, then UTF-8 length; each 1 or 2 bytes
+	if r.u8(off)&0x80 != 0 {
+		off += 2
+	} else {
+		off++
+	}
+	n := int(r.u8(off))
+	if n&0x80 != 0 {
+		n = (n&0x7f)<<8 | int(r.u8(off+1))
+		off += 2
+	} else {
+		off++
+	}
+	if !r.check(off, n) {
+		return ""
+	}
+	return string(r.data[off : off+n])
+}
+
+// readUTF16String decodes a length-prefixed UTF-16 pool string
+func readUTF16String(r *chunkReader, off int) string {
+	n := int(r.u16(off))
+	if n&0x8000 != 0 {
+		n = (n&0x7fff)<<16 | int(r.u16(off+2))
+		off += 4
+	} else {
+		off += 2
+	}
+	if !r.check(off, n*2) {
+		return ""
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(r.data[off+i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// get returns the string at index i, or "" for an invalid index
+func (p stringPool) get(i uint32) string {
+	if int64(i) >= int64(len(p)) {
+		return ""
+	}
+	return p[i]
+}
+
+// xmlAttr is an attribute of a binary XML start element
+type xmlAttr struct {
+	name       string
+	resourceID uint32
+	raw        string
+	dataType   uint8
+	data       uint32
+}
+
+// parseManifestXML walks a binary AndroidManifest.xml and collects the
+// fields of ManifestInfo
+func parseManifestXML(data []byte, table *resourceTable) (*ManifestInfo, error) {
+	r := &chunkReader{data: data}
+	if r.u16(0) != resXMLType {
+		return nil, errors.New("not a binary XML document")
+	}
+	headerSize := int(r.u16(2))
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	info := &ManifestInfo{
+		Permissions: []string{},
+		Activities:  []string{},
+		Services:    []string{},
+		Receivers:   []string{},
+		Providers:   []string{},
+	}
+
+	var pool stringPool
+	var resourceMap []uint32
+	var path []string
+	seenManifest := false
+
+	err := walkChunks(data, headerSize, func(chunkType uint16, chunk []byte) error {
+		cr := &chunkReader{data: chunk}
+		headerSize := int(cr.u16(2))
+
+		switch chunkType {
+		case resStringPoolType:
+			var err error
+			pool, err = parseStringPool(chunk)
+			return err
+		case resXMLResourceMap:
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resourceMap = append(resourceMap, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case resXMLStartElement:
+			name := pool.get(cr.u32(headerSize + 4))
+			attrStart := int(cr.u16(headerSize + 8))
+			attrSize := int(cr.u16(headerSize + 10))
+			attrCount := int(cr.u16(headerSize + 12))
+
+			attrs := make([]xmlAttr, 0, attrCount)
+			for i := 0; i < attrCount; i++ {
+				a := headerSize + attrStart + i*attrSize
+				nameIdx := cr.u32(a + 4)
+				attr := xmlAttr{
+					name:     pool.get(nameIdx),
+					raw:      pool.get(cr.u32(a + 8)),
+					dataType: cr.u8(a + 15),
+					data:     cr.u32(a + 16),
+				}
+				if int64(nameIdx) < int64(len(resourceMap)) {
+					attr.resourceID = resourceMap[nameIdx]
+				}
+				attrs = append(attrs, attr)
+			}
+			if cr.err != nil {
+				return cr.err
+			}
+
+			path = append(path, name)
+			collectManifestElement(info, path, attrs, pool, table)
+			if len(path) == 1 && name == "manifest" {
+				seenManifest = true
+			}
+		case resXMLEndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !seenManifest {
+		return nil, errors.New("no <manifest> element")
+	}
+	return info, nil
+}
+
+// collectManifestElement records the interesting attributes of one
+// element, identified by its path from the root
+func collectManifestElement(info *ManifestInfo, path []string, attrs []xmlAttr, pool stringPool, table *resourceTable) {
+	// Match by resource ID first, since obfuscated APKs strip names
+	find := func(name string, resourceID uint32) *xmlAttr {
+		for i := range attrs {
+			if resourceID != 0 && attrs[i].resourceID == resourceID {
+				return &attrs[i]
+			}
+		}
+		for i := range attrs {
+			if attrs[i].name == name {
+				return &attrs[i]
+			}
+		}
+		return nil
+	}
+
+	switch strings.Join(path, "/") {
+	case "manifest":
+		if a := find("package", 0); a != nil {
+			info.PackageName = attrString(a, pool, table)
+		}
+		if a := find("versionCode", attrVersionCode); a != nil {
+			info.VersionCode = int64(attrInt(a, table))
+		}
+		if a := find("versionName", attrVersionName); a != nil {
+			info.VersionName = attrString(a, pool, table)
+		}
+	case "manifest/application":
+		if a := find("label", attrLabel); a != nil {
+			info.Label = attrString(a, pool, table)
+		}
+	case "manifest/uses-sdk":
+		if a := find("minSdkVersion", attrMinSDKVersion); a != nil {
+			info.MinSDK = int(attrInt(a, table))
+		}
+		if a := find("targetSdkVersion", attrTargetSDKVersion); a != nil {
+			info.TargetSDK = int(attrInt(a, table))
+		}
+	case "manifest/uses-permission", "manifest/uses-permission-sdk-23":
+		if a := find("name", attrName); a != nil {
+			info.Permissions = append(info.Permissions, attrString(a, pool, table))
+		}
+	case "manifest/application/activity", "manifest/application/activity-alias":
+		info.Activities = appendComponent(info.Activities, info.PackageName, find("name", attrName), pool, table)
+	case "manifest/application/service":
+		info.Services = appendComponent(info.Services, info.PackageName, find("name", attrName), pool, table)
+	case "manifest/application/receiver":
+		info.Receivers = appendComponent(info.Receivers, info.PackageName, find("name", attrName), pool, table)
+	case "manifest/application/provider":
+		info.Providers = appendComponent(info.Providers, info.PackageName, find("name", attrName), pool, table)
+	}
+}
+
+// appendComponent adds a component class name, expanding names relative
+// to the package (".MainActivity")
+func appendComponent(list []string, packageName string, a *xmlAttr, pool stringPool, table *resourceTable) []string {
+	if a == nil {
+		return list
+	}
+	name := attrString(a, pool, table)
+	switch {
+	case strings.HasPrefix(name, "."):
+		name = packageName + name
+	case !strings.Contains(name, "."):
+		name = packageName + "." + name
+	}
+	return append(list, name)
+}
+
+// attrString returns an attribute's value as a string, resolving string
+// resources through the resource table
+func attrString(a *xmlAttr, pool stringPool, table *resourceTable) string {
+	switch a.dataType {
+	case typeString:
+		return pool.get(a.data)
+	case typeReference:
+		if table != nil {
+			if s, ok := table.resolveString(a.data); ok {
+				return s
+			}
+		}
+		return fmt.Sprintf("@0x%08x", a.data)
+	case typeIntDec:
+		return fmt.Sprint(int32(a.data))
+	case typeIntHex:
+		return fmt.Sprintf("0x%x", a.data)
+	case typeBoolean:
+		return fmt.Sprint(a.data != 0)
+	default:
+		return a.raw
+	}
+}
+
+// attrInt returns an attribute's value as an integer, resolving integer
+// resources and parsing numeric strings
+func attrInt(a *xmlAttr, table *resourceTable) uint32 {
+	switch a.dataType {
+	case typeIntDec, typeIntHex, typeBoolean:
+		return a.data
+	case typeReference:
+		if table != nil {
+			if v, ok := table.resolveInt(a.data); ok {
+				return v
+			}
+		}
+	case typeString:
+		var n uint32
+		if _, err := fmt.Sscan(a.raw, &n); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// verifyManifest checks that the downloaded file's manifest declares the
+// requested package and the version the API reported. On a mismatch the
+// file is quarantined.
+func (c *Client) verifyManifest(packageID string, version VersionInfo, path string) (*ManifestInfo, error) {
+	if c.options.SkipManifestCheck {
+		return nil, nil
+	}
+
+	var info *ManifestInfo
+	var err error
+	if version.APKType == "XAPK" {
+		info, err = ParseXAPKManifest(path)
+	} else {
+		info, err = ParseAPKManifest(path)
+	}
+
+	var verifyErr *VerificationError
+	switch {
+	case err != nil:
+		verifyErr = &VerificationError{Path: path, Check: "manifest", Err: err}
+	case info.PackageName != packageID:
+		verifyErr = &VerificationError{
+			Path:     path,
+			Check:    "manifest package",
+			Expected: packageID,
+			Actual:   info.PackageName,
+		}
+	case version.VersionCode != "" && version.VersionCode != strconv.FormatInt(info.VersionCode, 10):
+		verifyErr = &VerificationError{
+			Path:     path,
+			Check:    "manifest versionCode",
+			Expected: version.VersionCode,
+			Actual:   strconv.FormatInt(info.VersionCode, 10),
+		}
+	case version.VersionCode == "" && version.VersionName != "" && version.VersionName != info.VersionName:
+		verifyErr = &VerificationError{
+			Path:     path,
+			Check:    "manifest versionName",
+			Expected: version.VersionName,
+			Actual:   info.VersionName,
+		}
+	}
+
+	if verifyErr != nil {
+		return nil, quarantine(path, path, verifyErr)
+	}
+	return info, nil
+}
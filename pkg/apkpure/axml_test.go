@@ -0,0 +1,279 @@
+package apkpure
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// le appends little-endian encodings of 8, 16 and 32-bit values
+func le(values ...any) []byte {
+	var out []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint8:
+			out = append(out, v)
+		case uint16:
+			out = binary.LittleEndian.AppendUint16(out, v)
+		case uint32:
+			out = binary.LittleEndian.AppendUint32(out, v)
+		case int:
+			out = binary.LittleEndian.AppendUint32(out, uint32(v))
+		default:
+			panic("le: unsupported type")
+		}
+	}
+	return out
+}
+
+// chunk builds a ResChunk with the given header fields (after the common
+// type, header size and size) and body
+func chunk(chunkType uint16, header, body []byte) []byte {
+	headerSize := 8 + len(header)
+	return slices.Concat(le(chunkType, uint16(headerSize), headerSize+len(body)), header, body)
+}
+
+// stringPoolChunk builds a UTF-16 string pool
+func stringPoolChunk(strs ...string) []byte {
+	var offsets, data []byte
+	for _, s := range strs {
+		offsets = append(offsets, le(len(data))...)
+		units := utf16.Encode([]rune(s))
+		data = append(data, le(uint16(len(units)))...)
+		for _, u := range units {
+			data = append(data, le(u)...)
+		}
+		data = append(data, 0, 0)
+	}
+	const headerSize = 28
+	header := le(len(strs), 0, uint32(0), headerSize+len(offsets), 0)
+	return chunk(resStringPoolType, header, slices.Concat(offsets, data))
+}
+
+// testAttr is an attribute of startElement: name is a string pool index
+type testAttr struct {
+	name     uint32
+	dataType uint8
+	data     uint32
+}
+
+// startElement builds a binary XML start element
+func startElement(name uint32, attrs ...testAttr) []byte {
+	body := le(uint32(noEntry), name, uint16(20), uint16(20), uint16(len(attrs)), uint16(0), uint16(0), uint16(0))
+	for _, a := range attrs {
+		raw := uint32(noEntry)
+		if a.dataType == typeString {
+			raw = a.data
+		}
+		body = append(body, le(uint32(noEntry), a.name, raw, uint16(8), uint8(0), a.dataType, a.data)...)
+	}
+	return chunk(resXMLStartElement, le(0, uint32(noEntry)), body)
+}
+
+// endElement builds a binary XML end element
+func endElement(name uint32) []byte {
+	return chunk(resXMLEndElement, le(0, uint32(noEntry)), le(uint32(noEntry), name))
+}
+
+// testManifestXML returns a binary AndroidManifest.xml whose versionName
+// references the string resource 0x7f010000
+func testManifestXML() []byte {
+	const (
+		sManifest = iota
+		sPackage
+		sVersionCode
+		sVersionName
+		sPackageName
+		sUsesPermission
+		sName
+		sInternet
+		sApplication
+		sActivity
+		sMain
+	)
+	pool := stringPoolChunk("manifest", "package", "versionCode", "versionName", "com.example.app",
+		"uses-permission", "name", "android.permission.INTERNET", "application", "activity", ".Main")
+	resourceMap := chunk(resXMLResourceMap, nil, le(0, 0, attrVersionCode, attrVersionName))
+
+	body := slices.Concat(
+		pool,
+		resourceMap,
+		startElement(sManifest,
+			testAttr{sPackage, typeString, sPackageName},
+			testAttr{sVersionCode, typeIntDec, 150},
+			testAttr{sVersionName, typeReference, 0x7f010000},
+		),
+		startElement(sUsesPermission, testAttr{sName, typeString, sInternet}),
+		endElement(sUsesPermission),
+		startElement(sApplication),
+		startElement(sActivity, testAttr{sName, typeString, sMain}),
+		endElement(sActivity),
+		endElement(sApplication),
+		endElement(sManifest),
+	)
+	return chunk(resXMLType, nil, body)
+}
+
+// testResourceTable returns a resources.arsc with the string resource
+// 0x7f010000 = "1.5.0-de" for German, followed by "1.5.0" in the default
+// configuration
+func testResourceTable() []byte {
+	pool := stringPoolChunk("1.5.0", "1.5.0-de")
+	typeChunk := func(config []byte, value uint32) []byte {
+		config = slices.Concat(le(4+len(config)), config)
+		headerSize := 8 + 12 + len(config)
+		header := slices.Concat(le(uint8(1), uint8(0), uint16(0), 1, headerSize+4), config)
+		entry := le(uint16(8), uint16(0), 0, uint16(8), uint8(0), uint8(typeString), value)
+		return chunk(resTableTypeType, header, slices.Concat(le(0), entry))
+	}
+	pkg := chunk(resTablePackage, le(0x7f), slices.Concat(
+		typeChunk(slices.Concat([]byte{0, 0, 0, 0, 'd', 'e'}, make([]byte, 22)), 1),
+		typeChunk(make([]byte, 28), 0),
+	))
+	return chunk(resTableType, le(1), slices.Concat(pool, pkg))
+}
+
+// patch returns a copy of data with value written at off
+func patch(data []byte, off int, value any) []byte {
+	out := slices.Clone(data)
+	copy(out[off:], le(value))
+	return out
+}
+
+func TestParseManifestXML(t *testing.T) {
+	table, err := parseResourceTable(testResourceTable())
+	if err != nil {
+		t.Fatalf("parseResourceTable: %v", err)
+	}
+
+	doc := testManifestXML()
+	info, err := parseManifestXML(doc, table)
+	if err != nil {
+		t.Fatalf("parseManifestXML: %v", err)
+	}
+	if info.PackageName != "com.example.app" || info.VersionCode != 150 || info.VersionName != "1.5.0" {
+		t.Errorf("got %s %d %s, want com.example.app 150 1.5.0", info.PackageName, info.VersionCode, info.VersionName)
+	}
+	if !slices.Equal(info.Permissions, []string{"android.permission.INTERNET"}) {
+		t.Errorf("permissions %v", info.Permissions)
+	}
+	if !slices.Equal(info.Activities, []string{"com.example.app.Main"}) {
+		t.Errorf("activities %v", info.Activities)
+	}
+
+	// Offsets into doc: the document header, then the string pool
+	const (
+		poolStart      = 8
+		poolCount      = poolStart + 8
+		poolFirstIndex = poolStart + 28
+	)
+	manifestStart := indexChunk(t, doc, resXMLStartElement)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "empty", data: nil, wantErr: "not a binary XML document"},
+		{name: "resource table", data: testResourceTable(), wantErr: "not a binary XML document"},
+		{name: "truncated header", data: doc[:3], wantErr: "truncated chunk"},
+		{name: "truncated document", data: doc[:len(doc)-10], wantErr: "malformed chunk"},
+		{name: "chunk smaller than its header", data: patch(doc, poolStart+4, 4), wantErr: "malformed chunk"},
+		{name: "chunk past the end", data: patch(doc, poolStart+4, len(doc)), wantErr: "malformed chunk"},
+		{name: "string count out of range", data: patch(doc, poolCount, 1<<20), wantErr: "string pool count out of range"},
+		{name: "string offset out of range", data: patch(doc, poolFirstIndex, 1<<20), wantErr: "truncated chunk"},
+		{name: "attribute count out of range", data: patch(doc, manifestStart+16+12, uint16(100)), wantErr: "truncated chunk"},
+		{name: "attribute start out of range", data: patch(doc, manifestStart+16+8, uint16(0xfff0)), wantErr: "truncated chunk"},
+		{name: "no manifest element", data: chunk(resXMLType, nil, stringPoolChunk("manifest")), wantErr: "no <manifest> element"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseManifestXML(tt.data, table)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// No prefix of a document may crash the parser
+	for n := range doc {
+		_, _ = parseManifestXML(doc[:n], table)
+	}
+}
+
+func TestParseResourceTable(t *testing.T) {
+	data := testResourceTable()
+	table, err := parseResourceTable(data)
+	if err != nil {
+		t.Fatalf("parseResourceTable: %v", err)
+	}
+	if s, ok := table.resolveString(0x7f010000); !ok || s != "1.5.0" {
+		t.Errorf("resolveString = %q, %v; want the default configuration's 1.5.0", s, ok)
+	}
+	if _, ok := table.resolveString(0x7f010001); ok {
+		t.Errorf("resolved a resource that does not exist")
+	}
+
+	pkgStart := indexChunk(t, data, resTablePackage)
+	typeStart := indexChunk(t, data, resTableTypeType)
+	typeHeaderSize := int(binary.LittleEndian.Uint16(data[typeStart+2:]))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "empty", data: nil, wantErr: "not a resource table"},
+		{name: "binary XML", data: testManifestXML(), wantErr: "not a resource table"},
+		{name: "truncated header", data: data[:3], wantErr: "truncated chunk"},
+		{name: "truncated table", data: data[:len(data)-1], wantErr: "malformed chunk"},
+		{name: "package past the end", data: patch(data, pkgStart+4, len(data)), wantErr: "malformed chunk"},
+		{name: "type chunk smaller than its header", data: patch(data, typeStart+4, 4), wantErr: "malformed chunk"},
+		{name: "entry count out of range", data: patch(data, typeStart+12, 1<<20), wantErr: "type entry count out of range"},
+		{name: "entry offset out of range", data: patch(data, typeStart+typeHeaderSize, 0xfff0), wantErr: "truncated chunk"},
+		{name: "type header past the chunk", data: patch(data, typeStart+2, uint16(0xfff0)), wantErr: "truncated chunk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseResourceTable(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	for n := range data {
+		_, _ = parseResourceTable(data[:n])
+	}
+}
+
+// indexChunk returns the offset of the first chunk of chunkType in data,
+// searching the chunk headers of the document and of package chunks
+func indexChunk(t *testing.T, data []byte, chunkType uint16) int {
+	t.Helper()
+	var find func(off, end int) int
+	find = func(off, end int) int {
+		for off+8 <= end {
+			typ := binary.LittleEndian.Uint16(data[off:])
+			size := int(binary.LittleEndian.Uint32(data[off+4:]))
+			if typ == chunkType {
+				return off
+			}
+			if typ == resTablePackage {
+				headerSize := int(binary.LittleEndian.Uint16(data[off+2:]))
+				if i := find(off+headerSize, off+size); i >= 0 {
+					return i
+				}
+			}
+			off += size
+		}
+		return -1
+	}
+	i := find(int(binary.LittleEndian.Uint16(data[2:])), len(data))
+	if i < 0 {
+		t.Fatalf("no chunk of type 0x%04x", chunkType)
+	}
+	return i
+}
@@ -0,0 +1,229 @@
+package apkpure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MetadataCache stores version API responses between requests. Set
+// DownloadOptions.Cache to a MemoryCache, a DiskCache or your own
+// implementation. Implementations must be safe for concurrent use.
+type MetadataCache interface {
+	// Get returns the entry for key, if any
+	Get(key string) (*CachedResponse, bool)
+	// Set stores entry under key
+	Set(key string, entry *CachedResponse) error
+}
+
+// CachedResponse is a cached version API response
+type CachedResponse struct {
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// MemoryCache is a MetadataCache that lives as long as the process
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]CachedResponse
+}
+
+// NewMemoryCache creates an empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]CachedResponse)}
+}
+
+// Get returns a copy of the entry for key
+func (m *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Set stores a copy of entry under key
+func (m *MemoryCache) Set(key string, entry *CachedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = *entry
+	return nil
+}
+
+// DiskCache is a MetadataCache that keeps one JSON file per entry in a
+// directory, so it survives between runs
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache creates a cache in dir, creating the directory if needed
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// DefaultCacheDir returns the per-user cache directory for apkpure
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "apkpure"), nil
+}
+
+// Get reads the entry for key. Unreadable entries count as misses.
+func (d *DiskCache) Get(key string) (*CachedResponse, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry CachedResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Set writes the entry for key
+func (d *DiskCache) Set(key string, entry *CachedResponse) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path(key), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Clear removes every entry
+func (d *DiskCache) Clear() error {
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" {
+			if err := os.Remove(filepath.Join(d.dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// path maps a key to its file; keys are hashed since they contain URLs
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.dir, key+".json")
+}
+
+// cacheKey identifies an API request. The device info header is part of
+// the key since the API tailors responses to the architecture, language
+// and OS version.
+func (c *Client) cacheKey(url string) string {
+	digest := sha256.Sum256([]byte(url + "\n" + c.buildDeviceInfo()))
+	return hex.EncodeToString(digest[:])
+}
+
+// flightGroup collapses concurrent calls with the same key into one
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// flightCall is an in-progress or finished call
+type flightCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+	// waiters counts the callers still waiting; the last one to give up
+	// cancels the call
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn once for concurrent callers sharing key; later callers wait
+// for and share the first caller's result. fn runs in its own goroutine on
+// a context that keeps the first caller's values, and is canceled only
+// once every caller has given up, so one caller leaving does not fail the
+// others. Each caller stops waiting when its own ctx is done.
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(callCtx, key, call, fn)
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		g.leave(key, call)
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// leave drops a waiter that gave up. Without waiters the call is
+// canceled and forgotten, so the next caller starts afresh.
+func (g *flightGroup[T]) leave(key string, call *flightCall[T]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	call.cancel()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// run makes the shared call. A panic in fn becomes the call's error, so
+// waiters are released and the key can be retried.
+func (g *flightGroup[T]) run(ctx context.Context, key string, call *flightCall[T], fn func(ctx context.Context) (T, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("%s: panic: %v", key, r)
+		}
+		g.mu.Lock()
+		if g.calls[key] == call {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+
+	call.value, call.err = fn(ctx)
+}
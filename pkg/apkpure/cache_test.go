@@ -0,0 +1,132 @@
+package apkpure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+)
+
+func TestFlightGroup(t *testing.T) {
+	t.Run("shares one call", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var g flightGroup[int]
+			var calls atomic.Int32
+			release := make(chan struct{})
+
+			var wg sync.WaitGroup
+			results := make([]int, 5)
+			for i := range results {
+				wg.Go(func() {
+					results[i], _ = g.do(context.Background(), "key", func(context.Context) (int, error) {
+						calls.Add(1)
+						<-release
+						return 42, nil
+					})
+				})
+			}
+			// Every caller has joined once they are all blocked
+			synctest.Wait()
+			close(release)
+			wg.Wait()
+
+			if n := calls.Load(); n != 1 {
+				t.Errorf("fn ran %d times, want 1", n)
+			}
+			for i, v := range results {
+				if v != 42 {
+					t.Errorf("caller %d got %d, want 42", i, v)
+				}
+			}
+		})
+	})
+
+	t.Run("canceled caller leaves the call running", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var g flightGroup[int]
+			release := make(chan struct{})
+			var sharedCtx context.Context
+			fn := func(ctx context.Context) (int, error) {
+				sharedCtx = ctx
+				<-release
+				return 42, nil
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			first := make(chan error, 1)
+			go func() {
+				_, err := g.do(ctx, "key", fn)
+				first <- err
+			}()
+			second := make(chan int, 1)
+			go func() {
+				v, _ := g.do(context.Background(), "key", fn)
+				second <- v
+			}()
+			synctest.Wait()
+
+			cancel()
+			if err := <-first; !errors.Is(err, context.Canceled) {
+				t.Fatalf("canceled caller got %v, want context.Canceled", err)
+			}
+			if sharedCtx.Err() != nil {
+				t.Errorf("the shared call was canceled with its first caller")
+			}
+
+			close(release)
+			if v := <-second; v != 42 {
+				t.Errorf("remaining caller got %d, want 42", v)
+			}
+		})
+	})
+
+	t.Run("last caller leaving cancels the call", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			var g flightGroup[int]
+			canceled := make(chan struct{})
+			fn := func(ctx context.Context) (int, error) {
+				<-ctx.Done()
+				close(canceled)
+				return 0, ctx.Err()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error, 2)
+			for range 2 {
+				go func() {
+					_, err := g.do(ctx, "key", fn)
+					errs <- err
+				}()
+			}
+			synctest.Wait()
+			cancel()
+			for range 2 {
+				if err := <-errs; !errors.Is(err, context.Canceled) {
+					t.Errorf("caller got %v, want context.Canceled", err)
+				}
+			}
+			// fn returns only once its context is canceled
+			<-canceled
+		})
+	})
+
+	t.Run("panic becomes an error", func(t *testing.T) {
+		var g flightGroup[int]
+		_, err := g.do(context.Background(), "key", func(context.Context) (int, error) {
+			panic("boom")
+		})
+		if err == nil || !strings.Contains(err.Error(), "panic: boom") {
+			t.Fatalf("got error %v, want the panic", err)
+		}
+
+		v, err := g.do(context.Background(), "key", func(context.Context) (int, error) {
+			return 7, nil
+		})
+		if err != nil || v != 7 {
+			t.Errorf("retry got %d, %v; want 7", v, err)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package apkpure
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Checksum algorithms accepted by ParseChecksum
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA1   = "sha1"
+	ChecksumMD5    = "md5"
+)
+
+// quarantineSuffix is appended to files that fail verification
+// so they are kept for inspection but never mistaken for a good download
+const quarantineSuffix = ".quarantine"
+
+// Checksum is an expected digest of a downloaded file
+type Checksum struct {
+	// Algorithm is one of ChecksumSHA256, ChecksumSHA1 or ChecksumMD5
+	Algorithm string
+	// Value is the lowercase hex-encoded digest
+	Value string
+}
+
+// String formats c as "algorithm=value"
+func (c Checksum) String() string {
+	return c.Algorithm + "=" + c.Value
+}
+
+// ParseChecksum parses "sha256=<hex>", "sha1=<hex>" or "md5=<hex>". A bare
+// hex string is accepted too, with the algorithm inferred from its length.
+func ParseChecksum(s string) (Checksum, error) {
+	algorithm, value, found := strings.Cut(strings.TrimSpace(s), "=")
+	if !found {
+		value = algorithm
+		switch len(value) {
+		case sha256.Size * 2:
+			algorithm = ChecksumSHA256
+		case sha1.Size * 2:
+			algorithm = ChecksumSHA1
+		case md5.Size * 2:
+			algorithm = ChecksumMD5
+		default:
+			return Checksum{}, fmt.Errorf("cannot infer checksum algorithm from %q", s)
+		}
+	}
+
+	checksum := Checksum{
+		Algorithm: strings.ToLower(strings.TrimSpace(algorithm)),
+		Value:     strings.ToLower(strings.TrimSpace(value)),
+	}
+
+	var size int
+	switch checksum.Algorithm {
+	case ChecksumSHA256:
+		size = sha256.Size
+	case ChecksumSHA1:
+		size = sha1.Size
+	case ChecksumMD5:
+		size = md5.Size
+	default:
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+
+	if decoded, err := hex.DecodeString(checksum.Value); err != nil || len(decoded) != size {
+		return Checksum{}, fmt.Errorf("invalid %s checksum: %s", checksum.Algorithm, value)
+	}
+
+	return checksum, nil
+}
+
+// isHexDigest reports whether s is a lowercase hex-encoded digest of size
+// bytes
+func isHexDigest(s string, size int) bool {
+	if len(s) != size*2 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// digester computes every supported digest in one pass
+type digester struct {
+	sha256 hash.Hash
+	sha1   hash.Hash
+	md5    hash.Hash
+}
+
+// newDigester returns a digester with empty state
+func newDigester() *digester {
+	return &digester{
+		sha256: sha256.New(),
+		sha1:   sha1.New(),
+		md5:    md5.New(),
+	}
+}
+
+// Write feeds p to every hash; it never fails
+func (d *digester) Write(p []byte) (int, error) {
+	d.sha256.Write(p)
+	d.sha1.Write(p)
+	d.md5.Write(p)
+	return len(p), nil
+}
+
+// Reset discards everything written so far
+func (d *digester) Reset() {
+	d.sha256.Reset()
+	d.sha1.Reset()
+	d.md5.Reset()
+}
+
+// stats returns the file statistics for size bytes written
+func (d *digester) stats(size int64) fileStats {
+	return fileStats{
+		size:   size,
+		sha256: hex.EncodeToString(d.sha256.Sum(nil)),
+		sha1:   hex.EncodeToString(d.sha1.Sum(nil)),
+		md5:    hex.EncodeToString(d.md5.Sum(nil)),
+	}
+}
+
+// digest returns the computed digest for algorithm
+func (s fileStats) digest(algorithm string) string {
+	switch algorithm {
+	case ChecksumSHA256:
+		return s.sha256
+	case ChecksumSHA1:
+		return s.sha1
+	case ChecksumMD5:
+		return s.md5
+	default:
+		return ""
+	}
+}
+
+// expectedChecksums collects the digests a download must match: the one
+// given by the user and any the version API advertised
+func expectedChecksums(app AppInfo, version VersionInfo) []Checksum {
+	var expected []Checksum
+	if app.Checksum.Value != "" {
+		expected = append(expected, app.Checksum)
+	}
+	if version.SHA256 != "" {
+		expected = append(expected, Checksum{Algorithm: ChecksumSHA256, Value: version.SHA256})
+	}
+	if version.SHA1 != "" {
+		expected = append(expected, Checksum{Algorithm: ChecksumSHA1, Value: version.SHA1})
+	}
+	if version.MD5 != "" {
+		expected = append(expected, Checksum{Algorithm: ChecksumMD5, Value: version.MD5})
+	}
+	return expected
+}
+
+// verifyChecksums compares stats against expected, returning the
+// algorithms that matched or a *VerificationError for the first mismatch
+func verifyChecksums(path string, stats fileStats, expected []Checksum) ([]string, error) {
+	var verified []string
+	for _, checksum := range expected {
+		actual := stats.digest(checksum.Algorithm)
+		if actual != checksum.Value {
+			return nil, &VerificationError{
+				Path:     path,
+				Check:    checksum.Algorithm,
+				Expected: checksum.Value,
+				Actual:   actual,
+			}
+		}
+		verified = append(verified, checksum.Algorithm)
+	}
+	return verified, nil
+}
+
+// quarantine moves src, a file that failed verification, aside to path's
+// quarantine file and points verifyErr at it. src is path itself or its
+// partial download.
+func quarantine(src, path string, verifyErr *VerificationError) error {
+	quarantinePath := path + quarantineSuffix
+	if err := os.Rename(src, quarantinePath); err != nil {
+		return fmt.Errorf("%w (failed to quarantine: %v)", verifyErr, err)
+	}
+	verifyErr.Path = quarantinePath
+	return verifyErr
+}
+
+// hashFile computes the digests of the file at path
+func hashFile(path string) (fileStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileStats{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	d := newDigester()
+	size, err := io.Copy(d, f)
+	if err != nil {
+		return fileStats{}, err
+	}
+	return d.stats(size), nil
+}
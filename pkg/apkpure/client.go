@@ -4,17 +4,40 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 )
 
+// DefaultAPIBaseURL is the APKPure API used unless
+// DownloadOptions.APIBaseURL names another
+const DefaultAPIBaseURL = "https://tapi.pureapk.com"
+
 const (
-	versionsURLFormat = "https://tapi.pureapk.com/v3/get_app_his_version?hl=en&package_name=%s"
-	defaultUserAgent  = "Dalvik/2.1.0 (Linux; U; Android 15; Pixel 4a (5G) Build/BP1A.250505.005); APKPure/3.20.53 (Aegon)"
+	versionsPathFormat   = "/v3/get_app_his_version?hl=en&package_name=%s"
+	appDetailsPathFormat = "/v3/get_app_detail?hl=en&package_name=%s"
+	searchPathFormat     = "/v3/search_query_new?hl=en&key=%s&page=%d&search_type=active_search"
+	defaultUserAgent     = "Dalvik/2.1.0 (Linux; U; Android 15; Pixel 4a (5G) Build/BP1A.250505.005); APKPure/3.20.53 (Aegon)"
 )
 
 // Client represents an APKPure client
 type Client struct {
 	httpClient *http.Client
 	options    DownloadOptions
+
+	// pathLocks serializes downloads that target the same file
+	pathLocksMu sync.Mutex
+	pathLocks   map[string]*pathLock
+
+	// versionFlights shares one version lookup between concurrent
+	// requests for the same package
+	versionFlights flightGroup[[]VersionInfo]
+}
+
+// pathLock is a reference-counted mutex for one output path
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
 // NewClient creates a new APKPure client with the given options
@@ -33,12 +56,29 @@ func NewClient(opts DownloadOptions) *Client {
 		opts.Parallel = 4
 	}
 	if opts.OutputFormat == "" {
-		opts.OutputFormat = "plaintext"
+		opts.OutputFormat = OutputPlaintext
+	}
+	if opts.Retry == nil {
+		opts.Retry = DefaultRetryPolicy()
+	}
+	if opts.APIBaseURL == "" {
+		opts.APIBaseURL = DefaultAPIBaseURL
 	}
+	opts.APIBaseURL = strings.TrimSuffix(opts.APIBaseURL, "/")
 
 	return &Client{
 		httpClient: &http.Client{},
 		options:    opts,
+		pathLocks:  make(map[string]*pathLock),
+	}
+}
+
+// withOptions returns a client sharing c's HTTP client with other options
+func (c *Client) withOptions(opts DownloadOptions) *Client {
+	return &Client{
+		httpClient: c.httpClient,
+		options:    opts,
+		pathLocks:  make(map[string]*pathLock),
 	}
 }
 
@@ -96,5 +136,50 @@ func (c *Client) buildDeviceInfo() string {
 
 // getVersionsURL returns the URL for fetching app versions
 func (c *Client) getVersionsURL(packageID string) string {
-	return fmt.Sprintf(versionsURLFormat, packageID)
+	return c.options.APIBaseURL + fmt.Sprintf(versionsPathFormat, url.QueryEscape(packageID))
+}
+
+// getSearchURL returns the URL for one page of search results
+func (c *Client) getSearchURL(query string, page int) string {
+	return c.options.APIBaseURL + fmt.Sprintf(searchPathFormat, url.QueryEscape(query), page)
+}
+
+// getAppDetailsURL returns the URL for fetching an app's store listing
+func (c *Client) getAppDetailsURL(packageID string) string {
+	return c.options.APIBaseURL + fmt.Sprintf(appDetailsPathFormat, url.QueryEscape(packageID))
+}
+
+// logf prints human-readable progress messages. It is silent for
+// machine-readable output formats so stdout stays parseable.
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.options.OutputFormat != OutputPlaintext {
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// lockPath blocks until no other download in this client is writing path
+// and returns a function that releases it
+func (c *Client) lockPath(path string) func() {
+	c.pathLocksMu.Lock()
+	lock, ok := c.pathLocks[path]
+	if !ok {
+		lock = &pathLock{}
+		c.pathLocks[path] = lock
+	}
+	lock.refs++
+	c.pathLocksMu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		c.pathLocksMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(c.pathLocks, path)
+		}
+		c.pathLocksMu.Unlock()
+	}
 }
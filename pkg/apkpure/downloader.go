@@ -1,61 +1,147 @@
 package apkpure
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// ListVersions retrieves available versions for the given apps
-func (c *Client) ListVersions(apps []AppInfo) error {
-	for _, app := range apps {
-		if c.options.OutputFormat == "plaintext" {
-			fmt.Printf("Versions available for %s on APKPure:\n", app.PackageID)
-		}
+// GetVersions fetches the available versions for each of the given apps.
+// The returned slice has one entry per app, in the same order; a failed
+// lookup is reported in that entry's Error rather than aborting the rest.
+func (c *Client) GetVersions(apps []AppInfo) []VersionListing {
+	return c.GetVersionsContext(context.Background(), apps)
+}
 
-		versions, err := c.fetchVersions(app.PackageID)
-		if err != nil {
-			if c.options.OutputFormat == "plaintext" {
-				fmt.Printf("| Error: %v\n", err)
-			}
+// GetVersionsContext is like GetVersions but honours ctx cancellation.
+// Apps not yet queried when ctx is done report ctx.Err().
+func (c *Client) GetVersionsContext(ctx context.Context, apps []AppInfo) []VersionListing {
+	listings := make([]VersionListing, len(apps))
+	for i, app := range apps {
+		listings[i].PackageID = app.PackageID
+
+		if err := ctx.Err(); err != nil {
+			listings[i].Error = err
 			continue
 		}
 
-		if c.options.OutputFormat == "plaintext" {
-			versionNames := make([]string, 0, len(versions))
-			for _, v := range versions {
-				versionNames = append(versionNames, v.VersionName)
-			}
-			if len(versionNames) > 0 {
-				fmt.Printf("| ")
-				for i, v := range versionNames {
-					if i > 0 {
-						fmt.Printf(", ")
-					}
-					fmt.Printf("%s", v)
-				}
-				fmt.Printf("\n")
+		versions, err := c.fetchVersions(ctx, app.PackageID)
+		listings[i].Versions = versions
+		listings[i].Error = err
+	}
+
+	return listings
+}
+
+// ResolveVersion returns the build of app that Download would fetch: the
+// newest version matching its version constraint and versionCode
+func (c *Client) ResolveVersion(app AppInfo) (*VersionInfo, error) {
+	return c.ResolveVersionContext(context.Background(), app)
+}
+
+// ResolveVersionContext is like ResolveVersion but honours ctx cancellation
+func (c *Client) ResolveVersionContext(ctx context.Context, app AppInfo) (*VersionInfo, error) {
+	versions, err := c.fetchVersions(ctx, app.PackageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch versions: %w", err)
+	}
+	return resolveVersion(app, versions)
+}
+
+// ListVersions retrieves available versions for the given apps and prints
+// them to stdout in the configured output format
+func (c *Client) ListVersions(apps []AppInfo) error {
+	return c.ListVersionsContext(context.Background(), apps)
+}
+
+// ListVersionsContext is like ListVersions but honours ctx cancellation
+func (c *Client) ListVersionsContext(ctx context.Context, apps []AppInfo) error {
+	listings := c.GetVersionsContext(ctx, apps)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return WriteVersions(os.Stdout, listings, c.options.OutputFormat)
+}
+
+// fetchVersions fetches version information from APKPure API, retrying
+// according to the client's retry policy. Concurrent lookups of the same
+// package share one request, and the metadata cache is consulted first.
+func (c *Client) fetchVersions(ctx context.Context, packageID string) ([]VersionInfo, error) {
+	// Every download resolves its build here, before naming any file
+	if err := ValidatePackageID(packageID); err != nil {
+		return nil, err
+	}
+	url := c.getVersionsURL(packageID)
+	key := c.cacheKey(url)
+
+	return c.versionFlights.do(ctx, key, func(ctx context.Context) ([]VersionInfo, error) {
+		return fetchCached(ctx, c, OpFetchVersions, "versions of "+packageID, packageID, url, key, c.parseVersionResponse)
+	})
+}
+
+// fetchCached GETs an API url. It answers from the metadata cache while
+// the entry is fresh (or always when offline) and otherwise asks the API,
+// revalidating any stale entry. parse decodes the body; only bodies it
+// accepts are cached. subject names what is fetched in errors, and a 404
+// is a PackageNotFoundError for packageID, if set.
+func fetchCached[T any](ctx context.Context, c *Client, op, subject, packageID, url, key string, parse func([]byte) (T, error)) (T, error) {
+	var cached *CachedResponse
+	if c.options.Cache != nil {
+		if entry, ok := c.options.Cache.Get(key); ok {
+			if c.options.Offline || time.Since(entry.FetchedAt) < c.options.CacheTTL {
+				return parse(entry.Body)
 			}
+			cached = entry
 		}
 	}
 
-	return nil
+	var result T
+	if c.options.Offline {
+		return result, fmt.Errorf("%s: %w", subject, ErrNotCached)
+	}
+
+	err := c.withRetry(ctx, op, url, func() error {
+		entry, err := c.fetchAPIOnce(ctx, packageID, url, cached)
+		if err != nil {
+			return err
+		}
+		if result, err = parse(entry.Body); err != nil {
+			return err
+		}
+		c.storeResponse(key, entry)
+		return nil
+	})
+	return result, err
 }
 
-// fetchVersions fetches version information from APKPure API
-func (c *Client) fetchVersions(packageID string) ([]VersionInfo, error) {
-	url := c.getVersionsURL(packageID)
-	req, err := http.NewRequest("GET", url, nil)
+// fetchAPIOnce performs a single API request. With a cached entry the
+// request is conditional and a 304 reuses the cached body.
+func (c *Client) fetchAPIOnce(ctx context.Context, packageID, url string, cached *CachedResponse) (*CachedResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header = c.buildHeaders()
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -63,8 +149,16 @@ func (c *Client) fetchVersions(packageID string) ([]VersionInfo, error) {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		entry := *cached
+		entry.FetchedAt = time.Now()
+		return &entry, nil
+	}
+	if resp.StatusCode == http.StatusNotFound && packageID != "" {
+		return nil, &PackageNotFoundError{PackageID: packageID, Err: newStatusError(resp)}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -72,7 +166,23 @@ func (c *Client) fetchVersions(packageID string) ([]VersionInfo, error) {
 		return nil, err
 	}
 
-	return c.parseVersionResponse(body)
+	return &CachedResponse{
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}, nil
+}
+
+// storeResponse saves a response in the metadata cache, if one is set. A
+// failing cache only costs a later request, so errors are just logged.
+func (c *Client) storeResponse(key string, entry *CachedResponse) {
+	if c.options.Cache == nil {
+		return
+	}
+	if err := c.options.Cache.Set(key, entry); err != nil {
+		c.logf("Warning: failed to cache API response: %v\n", err)
+	}
 }
 
 // parseVersionResponse parses the JSON response from APKPure API
@@ -84,14 +194,15 @@ func (c *Client) parseVersionResponse(body []byte) ([]VersionInfo, error) {
 
 	versions := make([]VersionInfo, 0, len(apiResp.VersionList))
 	for _, v := range apiResp.VersionList {
-		if v.Asset.URL != "" {
-			versions = append(versions, VersionInfo{
-				VersionName: v.VersionName,
-				VersionCode: v.VersionCode,
-				APKType:     v.Asset.Type,
-				DownloadURL: v.Asset.URL,
-			})
+		if v.Asset.URL == "" {
+			continue
+		}
+		// versionCodes end up in file names and store keys
+		if v.VersionCode != "" && !IsVersionCode(v.VersionCode) {
+			c.logf("Warning: skipping %s with invalid versionCode %q\n", v.VersionName, v.VersionCode)
+			continue
 		}
+		versions = append(versions, v.versionInfo())
 	}
 
 	return versions, nil
@@ -99,93 +210,373 @@ func (c *Client) parseVersionResponse(body []byte) ([]VersionInfo, error) {
 
 // Download downloads a single APK
 func (c *Client) Download(app AppInfo, outPath string) error {
-	fmt.Printf("Downloading %s...\n", app.PackageID)
+	return c.DownloadContext(context.Background(), app, outPath)
+}
+
+// DownloadContext is like Download but aborts the version lookup, the
+// transfer and any retry sleeps once ctx is done
+func (c *Client) DownloadContext(ctx context.Context, app AppInfo, outPath string) error {
+	return c.DownloadWithResultContext(ctx, app, outPath).Error
+}
+
+// DownloadWithResult downloads a single APK and reports where it was
+// written, its size, hash and how long it took
+func (c *Client) DownloadWithResult(app AppInfo, outPath string) DownloadResult {
+	return c.DownloadWithResultContext(context.Background(), app, outPath)
+}
+
+// DownloadWithResultContext is like DownloadWithResult but honours ctx
+// cancellation
+func (c *Client) DownloadWithResultContext(ctx context.Context, app AppInfo, outPath string) DownloadResult {
+	start := time.Now()
+	result := DownloadResult{
+		AppInfo:  app,
+		Filename: appString(app),
+	}
 
-	versions, err := c.fetchVersions(app.PackageID)
+	err := c.download(ctx, app, outPath, &result)
+	result.Success = err == nil
+	result.Error = err
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+// download resolves the requested version and fetches it into outPath,
+// filling in result as it goes
+func (c *Client) download(ctx context.Context, app AppInfo, outPath string, result *DownloadResult) error {
+	c.logf("Downloading %s...\n", app.PackageID)
+
+	versions, err := c.fetchVersions(ctx, app.PackageID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch versions: %w", err)
 	}
 
+	targetVersion, err := resolveVersion(app, versions)
+	if err != nil {
+		return err
+	}
+
+	// Name the file after the resolved build, not the request, so
+	// "latest" downloads of different builds never collide either
+	result.Filename = versionFilename(app.PackageID, *targetVersion)
+
+	return c.downloadVersion(ctx, app, *targetVersion, outPath, result)
+}
+
+// resolveVersion picks the build of app to download from its listing
+func resolveVersion(app AppInfo, versions []VersionInfo) (*VersionInfo, error) {
 	if len(versions) == 0 {
-		return fmt.Errorf("no versions available for %s", app.PackageID)
+		return nil, &PackageNotFoundError{PackageID: app.PackageID}
 	}
 
-	// Find the matching version or use the latest
-	var targetVersion *VersionInfo
-	if app.Version != "" {
-		for i := range versions {
-			if versions[i].VersionName == app.Version {
-				targetVersion = &versions[i]
-				break
-			}
+	if app.VersionCode != "" {
+		versions = slices.DeleteFunc(slices.Clone(versions), func(v VersionInfo) bool {
+			return v.VersionCode != app.VersionCode
+		})
+	}
+
+	constraint, err := ParseVersionConstraint(app.Version)
+	if err != nil {
+		return nil, err
+	}
+	targetVersion := constraint.Select(versions)
+	if targetVersion == nil {
+		return nil, &VersionNotFoundError{PackageID: app.PackageID, Version: app.Version, VersionCode: app.VersionCode}
+	}
+	return targetVersion, nil
+}
+
+// downloadVersion fetches a resolved version into outPath as
+// result.Filename, then runs the configured verification steps
+func (c *Client) downloadVersion(ctx context.Context, app AppInfo, version VersionInfo, outPath string, result *DownloadResult) error {
+	result.Version = version
+	if version.VersionCode != "" && !IsVersionCode(version.VersionCode) {
+		return fmt.Errorf("invalid versionCode %q", version.VersionCode)
+	}
+
+	ext := versionExt(version)
+	filename := result.Filename + ext
+
+	// Use the store's copy if there is one, otherwise download with retry
+	expected := expectedChecksums(app, version)
+	useStore := c.options.Store != nil && version.VersionCode != ""
+	var stats fileStats
+	var err error
+	if useStore {
+		stats, result.Skipped, err = c.fetchStored(app.PackageID, version, outPath, filename, expected)
+		if err != nil {
+			return err
 		}
-		if targetVersion == nil {
-			return fmt.Errorf("version %s not found for %s", app.Version, app.PackageID)
+	}
+	if !result.Skipped {
+		stats, err = c.downloadWithRetry(ctx, version, outPath, filename, expected)
+		if err != nil {
+			return err
 		}
-	} else {
-		// Use the first (latest) version
-		targetVersion = &versions[0]
 	}
 
-	// Build filename
-	appString := app.PackageID
-	if app.Version != "" {
-		appString = fmt.Sprintf("%s@%s", app.PackageID, app.Version)
-	}
+	result.Path = filepath.Join(outPath, filename)
+	result.Size = stats.size
+	result.SHA256 = stats.sha256
+	result.SHA1 = stats.sha1
+	result.MD5 = stats.md5
+	result.ChecksumsVerified = stats.verified
 
-	ext := ".apk"
-	if targetVersion.APKType == "XAPK" {
-		ext = ".xapk"
+	result.Signature, err = c.verifySignature(app.PackageID, result.Path, version.APKType)
+	if err != nil {
+		return err
 	}
-	filename := appString + ext
 
-	// Download with retry
-	err = c.downloadWithRetry(targetVersion.DownloadURL, outPath, filename)
+	result.Manifest, err = c.verifyManifest(app.PackageID, version, result.Path)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("%s downloaded successfully!\n", appString)
+	if useStore && !result.Skipped {
+		if err := c.options.Store.add(app.PackageID, version, result.Path, stats); err != nil {
+			return fmt.Errorf("failed to add %s to store: %w", filename, err)
+		}
+	}
+
+	if c.options.UnpackXAPK && version.APKType == "XAPK" {
+		result.XAPK, err = UnpackXAPK(result.Path, strings.TrimSuffix(result.Path, ext))
+		if err != nil {
+			return fmt.Errorf("failed to unpack %s: %w", filename, err)
+		}
+	}
+
+	if result.Skipped {
+		c.logf("%s linked from store\n", result.Filename)
+	} else {
+		c.logf("%s downloaded successfully!\n", result.Filename)
+	}
 	return nil
 }
 
-// downloadWithRetry downloads a file with retry logic (up to 3 attempts)
-func (c *Client) downloadWithRetry(url, outPath, filename string) error {
-	maxRetries := 3
-	var lastErr error
+// ParseAppSpec parses an app spec: a package name with an optional version
+// constraint, versionCode and checksum, e.g.
+// com.example.app@1.2.3#123456#sha256=<hex>
+func ParseAppSpec(spec string) (AppInfo, error) {
+	spec, fragments, _ := strings.Cut(strings.TrimSpace(spec), "#")
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if attempt > 1 {
-			fmt.Printf("Retry #%d...\n", attempt-1)
-		}
+	packageID, version, _ := strings.Cut(spec, "@")
+	app := AppInfo{PackageID: packageID, Version: version}
+	if app.PackageID == "" {
+		return AppInfo{}, fmt.Errorf("missing package name in %q", spec)
+	}
+	if err := ValidatePackageID(app.PackageID); err != nil {
+		return AppInfo{}, err
+	}
+	if _, err := ParseVersionConstraint(app.Version); err != nil {
+		return AppInfo{}, err
+	}
 
-		err := c.downloadFile(url, outPath, filename)
-		if err == nil {
-			return nil
+	// Each #fragment is either a versionCode (digits only) or a checksum
+	if fragments != "" {
+		for _, fragment := range strings.Split(fragments, "#") {
+			if IsVersionCode(fragment) {
+				app.VersionCode = fragment
+				continue
+			}
+			checksum, err := ParseChecksum(fragment)
+			if err != nil {
+				return AppInfo{}, err
+			}
+			app.Checksum = checksum
 		}
+	}
+
+	return app, nil
+}
 
-		lastErr = err
-		if attempt < maxRetries {
-			time.Sleep(time.Second)
+// ValidatePackageID checks that id is an Android package name: dot-separated
+// segments of letters, digits and underscores, each starting with a letter.
+// Package names end up in file and directory names, so every input path
+// runs them through here.
+func ValidatePackageID(id string) error {
+	for _, segment := range strings.Split(id, ".") {
+		valid := segment != ""
+		for i, r := range segment {
+			switch {
+			case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+			case i > 0 && ('0' <= r && r <= '9' || r == '_'):
+			default:
+				valid = false
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid package name %q", id)
 		}
 	}
+	return nil
+}
 
-	return fmt.Errorf("failed after %d attempts: %w", maxRetries, lastErr)
+// IsVersionCode reports whether s looks like a versionCode rather than a
+// bare hex checksum: only digits and short enough to fit an int64
+func IsVersionCode(s string) bool {
+	return len(s) > 0 && len(s) <= 19 && strings.Trim(s, "0123456789") == ""
 }
 
-// downloadFile downloads a file from the given URL
-func (c *Client) downloadFile(url, outPath, filename string) (err error) {
+// appString formats app as "package", "package@version",
+// "package#versionCode" or "package@version#versionCode"
+func appString(app AppInfo) string {
+	s := app.PackageID
+	if app.Version != "" {
+		s += "@" + app.Version
+	}
+	if app.VersionCode != "" {
+		s += "#" + app.VersionCode
+	}
+	return s
+}
+
+// versionExt returns the file extension for a version's asset type
+func versionExt(version VersionInfo) string {
+	if version.APKType == "XAPK" {
+		return ".xapk"
+	}
+	return ".apk"
+}
+
+// versionFilename names a downloaded build "package@version_versionCode",
+// so builds sharing a versionName never collide. Path separators in the
+// versionName, which comes from the API, are replaced.
+func versionFilename(packageID string, version VersionInfo) string {
+	name := packageID + "@" + strings.NewReplacer("/", "_", "\\", "_").Replace(version.VersionName)
+	if version.VersionCode != "" {
+		name += "_" + version.VersionCode
+	}
+	return name
+}
+
+// fileStats describes a file written by downloadFile
+type fileStats struct {
+	size   int64
+	sha256 string
+	sha1   string
+	md5    string
+	// verified lists the checksum algorithms that were checked
+	verified []string
+}
+
+// downloadWithRetry downloads a build, retrying according to the client's
+// retry policy
+func (c *Client) downloadWithRetry(ctx context.Context, version VersionInfo, outPath, filename string, expected []Checksum) (fileStats, error) {
+	var stats fileStats
+	err := c.withRetry(ctx, OpDownload, version.DownloadURL, func() error {
+		var err error
+		stats, err = c.downloadFile(ctx, version, outPath, filename, expected)
+		return err
+	})
+	if err != nil {
+		return fileStats{}, err
+	}
+
+	return stats, nil
+}
+
+// partSuffix is appended to the output filename while a download is in
+// progress. The file is renamed into place only once it is complete, and
+// a leftover .part file is resumed by the next attempt or invocation.
+const partSuffix = ".part"
+
+// partMetaSuffix is appended to a .part file's name for the record of
+// which build and response it holds
+const partMetaSuffix = ".json"
+
+// partMeta identifies the content of a .part file, so a resume never
+// joins the bytes of two different builds
+type partMeta struct {
+	VersionCode  string `json:"version_code"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// downloadFile downloads a build and checks it against the expected
+// checksums before moving it into place
+func (c *Client) downloadFile(ctx context.Context, version VersionInfo, outPath, filename string, expected []Checksum) (fileStats, error) {
 	fullPath := filepath.Join(outPath, filename)
 
+	// Serialize downloads of the same file within this client so they
+	// don't append to the same .part file
+	unlock := c.lockPath(fullPath)
+	defer unlock()
+
 	// Check if file already exists
 	if _, err := os.Stat(fullPath); err == nil {
-		return fmt.Errorf("file already exists: %s", filename)
+		return fileStats{}, &FileExistsError{Path: fullPath}
+	}
+
+	partPath := fullPath + partSuffix
+	stats, err := c.fetchToPart(ctx, version, partPath, filename)
+	if err != nil {
+		return fileStats{}, err
+	}
+	metaPath := partPath + partMetaSuffix
+
+	stats.verified, err = verifyChecksums(fullPath, stats, expected)
+	if err != nil {
+		// Keep the bad file for inspection, out of the way of a retry
+		_ = os.Remove(metaPath)
+		var verifyErr *VerificationError
+		if errors.As(err, &verifyErr) {
+			return fileStats{}, quarantine(partPath, fullPath, verifyErr)
+		}
+		return fileStats{}, err
+	}
+
+	if err := os.Rename(partPath, fullPath); err != nil {
+		return fileStats{}, fmt.Errorf("failed to move file into place: %w", err)
+	}
+	_ = os.Remove(metaPath)
+
+	return stats, nil
+}
+
+// resumeValidator returns the If-Range value under which the .part file
+// described by metaPath may be resumed for version, or "" if it may not:
+// it holds another build, or nothing identifies the response it came from
+func resumeValidator(metaPath string, version VersionInfo) string {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return ""
+	}
+	var meta partMeta
+	if err := json.Unmarshal(data, &meta); err != nil || meta.VersionCode != version.VersionCode {
+		return ""
+	}
+	// Weak ETags can't be used with If-Range
+	if meta.ETag != "" && !strings.HasPrefix(meta.ETag, "W/") {
+		return meta.ETag
 	}
+	return meta.LastModified
+}
 
-	// Create output file
-	outFile, err := os.Create(fullPath)
+// writePartMeta records which build and response a .part file holds
+func writePartMeta(metaPath string, version VersionInfo, resp *http.Response) error {
+	data, err := json.Marshal(partMeta{
+		VersionCode:  version.VersionCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return err
+	}
+	return writeFileAtomic(metaPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// fetchToPart downloads version into partPath, resuming from the end of
+// an existing partial file of the same build when the server honours
+// Range and If-Range requests
+func (c *Client) fetchToPart(ctx context.Context, version VersionInfo, partPath, filename string) (stats fileStats, err error) {
+	// Open (or create) the partial file
+	outFile, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return stats, fmt.Errorf("failed to create file: %w", err)
 	}
 	defer func() {
 		if closeErr := outFile.Close(); closeErr != nil && err == nil {
@@ -193,25 +584,89 @@ func (c *Client) downloadFile(url, outPath, filename string) (err error) {
 		}
 	}()
 
+	// Hash what we already have so the digests cover the whole file
+	hasher := newDigester()
+	offset, err := io.Copy(hasher, outFile)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read partial file: %w", err)
+	}
+
+	restart := func() error {
+		if err := outFile.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate partial file: %w", err)
+		}
+		if _, err := outFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hasher.Reset()
+		offset = 0
+		return nil
+	}
+
+	// Only resume bytes known to come from this build
+	metaPath := partPath + partMetaSuffix
+	validator := ""
+	if offset > 0 {
+		if validator = resumeValidator(metaPath, version); validator == "" {
+			c.logf("Discarding partial %s of another build\n", filename)
+			if err := restart(); err != nil {
+				return stats, err
+			}
+		}
+	}
+
 	// Download
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", version.DownloadURL, nil)
 	if err != nil {
-		return err
+		return stats, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return stats, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("invalid status code: %d", resp.StatusCode)
+	total := resp.ContentLength
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			// Don't guess where the bytes belong; start over next attempt
+			_ = outFile.Truncate(0)
+			return stats, fmt.Errorf("%w: unexpected Content-Range %q for offset %d", errResumeMismatch, resp.Header.Get("Content-Range"), offset)
+		}
+		total = size
+		c.logf("Resuming %s at %d bytes\n", filename, offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file may already hold everything
+		if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == offset {
+			return hasher.stats(offset), nil
+		}
+		_ = outFile.Truncate(0)
+		return stats, fmt.Errorf("%w: %w", errResumeMismatch, newStatusError(resp))
+	case resp.StatusCode == http.StatusOK:
+		// No resume support, a changed file or nothing to resume: start
+		// from scratch
+		if offset > 0 {
+			if err := restart(); err != nil {
+				return stats, err
+			}
+		}
+	default:
+		return stats, newStatusError(resp)
 	}
 
-	// Copy with progress
-	total := resp.ContentLength
-	downloaded := int64(0)
+	if err := writePartMeta(metaPath, version, resp); err != nil {
+		return stats, fmt.Errorf("failed to record partial download: %w", err)
+	}
+
+	// Copy with progress, hashing as we go
+	downloaded := offset
 
 	buffer := make([]byte, 32*1024) // 32KB buffer
 	for {
@@ -219,8 +674,9 @@ func (c *Client) downloadFile(url, outPath, filename string) (err error) {
 		if n > 0 {
 			_, writeErr := outFile.Write(buffer[:n])
 			if writeErr != nil {
-				return writeErr
+				return stats, writeErr
 			}
+			hasher.Write(buffer[:n])
 			downloaded += int64(n)
 
 			// Call progress callback if set
@@ -232,15 +688,61 @@ func (c *Client) downloadFile(url, outPath, filename string) (err error) {
 			break
 		}
 		if err != nil {
-			return err
+			return stats, err
 		}
 	}
 
-	return nil
+	if total > 0 && downloaded != total {
+		return stats, fmt.Errorf("incomplete download: got %d of %d bytes: %w", downloaded, total, io.ErrUnexpectedEOF)
+	}
+
+	return hasher.stats(downloaded), nil
+}
+
+// parseContentRange parses a "bytes start-end/size" or "bytes */size"
+// Content-Range header. ok is false if the header is malformed or the
+// size is unknown.
+func parseContentRange(value string) (start, size int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+
+	rangePart, sizePart, found := strings.Cut(value, "/")
+	if !found || sizePart == "*" {
+		return 0, 0, false
+	}
+
+	size, err := strconv.ParseInt(sizePart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if rangePart == "*" {
+		return 0, size, true
+	}
+
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err = strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return start, size, true
 }
 
 // DownloadMultiple downloads multiple APKs in parallel
 func (c *Client) DownloadMultiple(apps []AppInfo, outPath string) []DownloadResult {
+	return c.DownloadMultipleContext(context.Background(), apps, outPath)
+}
+
+// DownloadMultipleContext is like DownloadMultiple but stops queued and
+// in-flight downloads once ctx is done. Apps that never started report
+// ctx.Err() in their result.
+func (c *Client) DownloadMultipleContext(ctx context.Context, apps []AppInfo, outPath string) []DownloadResult {
 	results := make([]DownloadResult, len(apps))
 	var wg sync.WaitGroup
 
@@ -252,32 +754,79 @@ func (c *Client) DownloadMultiple(apps []AppInfo, outPath string) []DownloadResu
 		go func(idx int, appInfo AppInfo) {
 			defer wg.Done()
 
-			// Acquire semaphore
-			sem <- struct{}{}
-			defer func() { <-sem }()
+			results[idx] = c.acquireAndRun(ctx, sem, appInfo, func() DownloadResult {
+				return c.DownloadWithResultContext(ctx, appInfo, outPath)
+			})
+		}(i, app)
+	}
 
-			// Sleep if configured
-			if c.options.SleepDuration > 0 {
-				time.Sleep(c.options.SleepDuration)
-			}
+	wg.Wait()
+	return results
+}
 
-			// Download
-			err := c.Download(appInfo, outPath)
+// acquireAndRun waits for a free slot in sem and runs the download,
+// giving up early if ctx is done while queued or sleeping
+func (c *Client) acquireAndRun(ctx context.Context, sem chan struct{}, app AppInfo, run func() DownloadResult) DownloadResult {
+	canceled := func(err error) DownloadResult {
+		return DownloadResult{AppInfo: app, Filename: appString(app), Error: err}
+	}
 
-			appString := appInfo.PackageID
-			if appInfo.Version != "" {
-				appString = fmt.Sprintf("%s@%s", appInfo.PackageID, appInfo.Version)
-			}
+	if err := acquire(ctx, sem); err != nil {
+		return canceled(err)
+	}
+	defer func() { <-sem }()
 
-			results[idx] = DownloadResult{
-				AppInfo:  appInfo,
-				Filename: appString,
-				Success:  err == nil,
-				Error:    err,
-			}
-		}(i, app)
+	// Sleep if configured
+	if c.options.SleepDuration > 0 {
+		if err := sleepContext(ctx, c.options.SleepDuration); err != nil {
+			return canceled(err)
+		}
 	}
 
-	wg.Wait()
-	return results
+	return run()
+}
+
+// acquire takes a slot in sem, or returns ctx.Err() if ctx is done first
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory so readers never see a partial file
+func writeFileAtomic(name string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
 }
@@ -0,0 +1,410 @@
+package apkpure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBuild is one build served by fakeAPI
+type fakeBuild struct {
+	name    string
+	code    string
+	content []byte
+	// etag is sent with downloads when set
+	etag string
+	// sha256 is advertised in the listing when set
+	sha256 string
+	// released is sent as the release_date when set
+	released string
+}
+
+// fakeAPI stands in for the APKPure version API and download server
+type fakeAPI struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// builds lists the builds of each package, newest first
+	builds map[string][]fakeBuild
+	// details holds the app detail response body of each package
+	details map[string]string
+	// requests holds the headers of every download request
+	requests []http.Header
+	// listings counts version list requests
+	listings int
+}
+
+// newFakeAPI starts a fake API serving builds
+func newFakeAPI(t *testing.T, builds map[string][]fakeBuild) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{builds: builds}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v3/get_app_his_version", api.handleVersions)
+	mux.HandleFunc("GET /v3/get_app_detail", api.handleDetails)
+	mux.HandleFunc("GET /files/{package}/{code}", api.handleFile)
+	api.server = httptest.NewServer(mux)
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (a *fakeAPI) handleVersions(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.listings++
+	packageID := r.URL.Query().Get("package_name")
+	builds, ok := a.builds[packageID]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	list := make([]map[string]any, len(builds))
+	for i, build := range builds {
+		asset := map[string]any{
+			"url":  a.server.URL + "/files/" + packageID + "/" + url.PathEscape(build.code),
+			"type": "APK",
+			"size": len(build.content),
+		}
+		if build.sha256 != "" {
+			asset["sha256"] = build.sha256
+		}
+		list[i] = map[string]any{
+			"version_name": build.name,
+			"version_code": build.code,
+			"asset":        asset,
+		}
+		if build.released != "" {
+			list[i]["release_date"] = build.released
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"version_list": list})
+}
+
+func (a *fakeAPI) handleDetails(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	body, ok := a.details[r.URL.Query().Get("package_name")]
+	a.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = io.WriteString(w, body)
+}
+
+func (a *fakeAPI) handleFile(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.requests = append(a.requests, r.Header.Clone())
+	var build *fakeBuild
+	for _, b := range a.builds[r.PathValue("package")] {
+		if b.code == r.PathValue("code") {
+			build = &b
+		}
+	}
+	a.mu.Unlock()
+
+	if build == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if build.etag != "" {
+		w.Header().Set("ETag", build.etag)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(build.content))
+}
+
+// downloadRequests returns the headers of the download requests so far
+func (a *fakeAPI) downloadRequests() []http.Header {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]http.Header(nil), a.requests...)
+}
+
+// versionRequests returns the number of version list requests so far
+func (a *fakeAPI) versionRequests() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.listings
+}
+
+// newTestClient returns a quiet client of api that tries everything once
+// and skips the manifest check, as test payloads aren't real APKs
+func newTestClient(api *fakeAPI, opts DownloadOptions) *Client {
+	opts.APIBaseURL = api.server.URL
+	opts.OutputFormat = OutputJSON
+	opts.SkipManifestCheck = true
+	if opts.Retry == nil {
+		opts.Retry = &RetryPolicy{MaxAttempts: 1}
+	}
+	return NewClient(opts)
+}
+
+// testPayload returns n bytes of deterministic content, varied by seed
+func testPayload(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i) ^ seed
+	}
+	return b
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := testPayload(100000, 1)
+	partial := content[:40000]
+
+	tests := []struct {
+		name string
+		// part and meta are the leftovers of an earlier attempt; a nil
+		// meta leaves no record
+		part []byte
+		meta *partMeta
+		// ifRange is the validator a resume must be conditional on, or ""
+		// when the partial file must be discarded without asking
+		ifRange string
+	}{
+		{
+			name:    "same build and ETag",
+			part:    partial,
+			meta:    &partMeta{VersionCode: "10", ETag: `"v10"`},
+			ifRange: `"v10"`,
+		},
+		{
+			name: "other build",
+			part: testPayload(40000, 2),
+			meta: &partMeta{VersionCode: "9", ETag: `"v10"`},
+		},
+		{
+			// The server answers the stale If-Range with the whole file
+			name:    "changed file",
+			part:    testPayload(40000, 2),
+			meta:    &partMeta{VersionCode: "10", ETag: `"old"`},
+			ifRange: `"old"`,
+		},
+		{
+			name: "weak ETag only",
+			part: partial,
+			meta: &partMeta{VersionCode: "10", ETag: `W/"v10"`},
+		},
+		{
+			name: "no record",
+			part: testPayload(40000, 2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, map[string][]fakeBuild{
+				"com.example": {{name: "1.0", code: "10", content: content, etag: `"v10"`}},
+			})
+			client := newTestClient(api, DownloadOptions{})
+			dir := t.TempDir()
+
+			path := filepath.Join(dir, "com.example@1.0_10.apk")
+			if err := os.WriteFile(path+partSuffix, tt.part, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.meta != nil {
+				data, _ := json.Marshal(tt.meta)
+				if err := os.WriteFile(path+partSuffix+partMetaSuffix, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := client.DownloadWithResult(AppInfo{PackageID: "com.example", VersionCode: "10"}, dir)
+			if result.Error != nil {
+				t.Fatalf("download failed: %v", result.Error)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded file differs from the build")
+			}
+			for _, leftover := range []string{path + partSuffix, path + partSuffix + partMetaSuffix} {
+				if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+					t.Errorf("%s was not removed", filepath.Base(leftover))
+				}
+			}
+
+			requests := api.downloadRequests()
+			if len(requests) != 1 {
+				t.Fatalf("got %d download requests, want 1", len(requests))
+			}
+			gotRange := requests[0].Get("Range")
+			if tt.ifRange != "" {
+				want := fmt.Sprintf("bytes=%d-", len(tt.part))
+				if gotRange != want || requests[0].Get("If-Range") != tt.ifRange {
+					t.Errorf("Range %q, If-Range %q; want %q, %q", gotRange, requests[0].Get("If-Range"), want, tt.ifRange)
+				}
+			} else if gotRange != "" {
+				t.Errorf("partial file was resumed with Range %q", gotRange)
+			}
+		})
+	}
+}
+
+func TestDownloadChecksumMismatchQuarantines(t *testing.T) {
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {{name: "1.0", code: "10", content: testPayload(1000, 1)}},
+	})
+	client := newTestClient(api, DownloadOptions{})
+	dir := t.TempDir()
+
+	checksum := Checksum{Algorithm: ChecksumSHA256, Value: strings.Repeat("0", 64)}
+	result := client.DownloadWithResult(AppInfo{PackageID: "com.example", VersionCode: "10", Checksum: checksum}, dir)
+
+	var verifyErr *VerificationError
+	if !errors.As(result.Error, &verifyErr) {
+		t.Fatalf("got error %v, want a *VerificationError", result.Error)
+	}
+	path := filepath.Join(dir, "com.example@1.0_10.apk")
+	if verifyErr.Path != path+quarantineSuffix {
+		t.Errorf("error points at %s, want the quarantined file", verifyErr.Path)
+	}
+	for name, want := range map[string]bool{
+		path:                               false,
+		path + partSuffix:                  false,
+		path + partSuffix + partMetaSuffix: false,
+		path + quarantineSuffix:            true,
+	} {
+		if _, err := os.Stat(name); (err == nil) != want {
+			t.Errorf("%s exists: %v, want %v", filepath.Base(name), err == nil, want)
+		}
+	}
+}
+
+func TestFetchVersionsCanceled(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer server.Close()
+	client := NewClient(DownloadOptions{APIBaseURL: server.URL, Retry: &RetryPolicy{MaxAttempts: 3}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.fetchVersions(ctx, "com.example")
+		errc <- err
+	}()
+	<-started
+	cancel()
+
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the API request outlived its only caller")
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {
+			{name: "2.0", code: "20", content: testPayload(100, 2)},
+			{name: "1.0", code: "11", content: testPayload(100, 3)},
+			{name: "1.0", code: "10", content: testPayload(100, 1)},
+			{name: `0.9/beta\2`, code: "9", content: testPayload(100, 4)},
+			{name: "0.8", code: "1/../../../escaped", content: testPayload(100, 5)},
+		},
+	})
+
+	tests := []struct {
+		app AppInfo
+		// want is the file name, or "" when the download must fail
+		want string
+	}{
+		{app: AppInfo{PackageID: "com.example"}, want: "com.example@2.0_20.apk"},
+		{app: AppInfo{PackageID: "com.example", Version: "latest"}, want: "com.example@2.0_20.apk"},
+		{app: AppInfo{PackageID: "com.example", Version: "1.0"}, want: "com.example@1.0_11.apk"},
+		{app: AppInfo{PackageID: "com.example", VersionCode: "10"}, want: "com.example@1.0_10.apk"},
+		{app: AppInfo{PackageID: "com.example", Version: "<2"}, want: "com.example@1.0_11.apk"},
+		{app: AppInfo{PackageID: "com.example", VersionCode: "9"}, want: "com.example@0.9_beta_2_9.apk"},
+		{app: AppInfo{PackageID: "com.example", VersionCode: "1/../../../escaped"}},
+	}
+	for _, tt := range tests {
+		t.Run(appString(tt.app), func(t *testing.T) {
+			client := newTestClient(api, DownloadOptions{})
+			dir := filepath.Join(t.TempDir(), "a", "b")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			result := client.DownloadWithResult(tt.app, dir)
+			if tt.want == "" {
+				if result.Error == nil {
+					t.Errorf("download succeeded as %s", result.Path)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "..", "..", "escaped.apk")); err == nil {
+					t.Error("the download escaped its output directory")
+				}
+				return
+			}
+			if result.Error != nil {
+				t.Fatalf("download failed: %v", result.Error)
+			}
+			if result.Path != filepath.Join(dir, tt.want) {
+				t.Errorf("downloaded to %s, want %s", filepath.Base(result.Path), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAppSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    AppInfo
+		wantErr string
+	}{
+		{spec: "com.example", want: AppInfo{PackageID: "com.example"}},
+		{spec: " com.example_2.App ", want: AppInfo{PackageID: "com.example_2.App"}},
+		{spec: "com.example@>=1.2#123", want: AppInfo{PackageID: "com.example", Version: ">=1.2", VersionCode: "123"}},
+		{spec: "@1.0", wantErr: "missing package name"},
+		{spec: "../com.example", wantErr: "invalid package name"},
+		{spec: "com/example", wantErr: "invalid package name"},
+		{spec: `com\example`, wantErr: "invalid package name"},
+		{spec: ".example", wantErr: "invalid package name"},
+		{spec: "com..example", wantErr: "invalid package name"},
+		{spec: "com.example.", wantErr: "invalid package name"},
+		{spec: "com.1example", wantErr: "invalid package name"},
+		{spec: "com.ex ample", wantErr: "invalid package name"},
+		{spec: "com.example#abc", wantErr: "checksum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			app, err := ParseAppSpec(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %+v, %v; want error containing %q", app, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || app != tt.want {
+				t.Errorf("got %+v, %v; want %+v", app, err, tt.want)
+			}
+		})
+	}
+}
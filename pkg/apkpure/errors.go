@@ -0,0 +1,250 @@
+package apkpure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Sentinel errors that can be matched with errors.Is. The concrete error
+// types below match their sentinel and carry the details.
+var (
+	ErrPackageNotFound    = errors.New("package not found")
+	ErrVersionNotFound    = errors.New("version not found")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrFileExists         = errors.New("file already exists")
+	ErrVerificationFailed = errors.New("verification failed")
+	ErrNotCached          = errors.New("not in metadata cache")
+	ErrLockDrift          = errors.New("build differs from lockfile")
+)
+
+// Error kinds reported by ErrorKind
+const (
+	KindPackageNotFound    = "package_not_found"
+	KindVersionNotFound    = "version_not_found"
+	KindRateLimited        = "rate_limited"
+	KindHTTPStatus         = "http_status"
+	KindFileExists         = "file_exists"
+	KindVerificationFailed = "verification_failed"
+	KindNotCached          = "not_cached"
+	KindLockDrift          = "lock_drift"
+	KindCanceled           = "canceled"
+	KindOther              = "other"
+)
+
+// maxBodySnippet is how much of an error response body is kept
+const maxBodySnippet = 512
+
+// PackageNotFoundError reports that APKPure has no versions for a package
+type PackageNotFoundError struct {
+	PackageID string
+	// Err is the underlying cause, if any (e.g. an *HTTPStatusError)
+	Err error
+}
+
+func (e *PackageNotFoundError) Error() string {
+	return fmt.Sprintf("package %s not found", e.PackageID)
+}
+
+// Is reports whether target is ErrPackageNotFound
+func (e *PackageNotFoundError) Is(target error) bool { return target == ErrPackageNotFound }
+
+// Unwrap returns the underlying cause
+func (e *PackageNotFoundError) Unwrap() error { return e.Err }
+
+// VersionNotFoundError reports that a requested version is not offered
+type VersionNotFoundError struct {
+	PackageID   string
+	Version     string
+	VersionCode string
+}
+
+func (e *VersionNotFoundError) Error() string {
+	switch {
+	case e.VersionCode != "" && e.Version != "":
+		return fmt.Sprintf("version %s with versionCode %s not found for %s", e.Version, e.VersionCode, e.PackageID)
+	case e.VersionCode != "":
+		return fmt.Sprintf("versionCode %s not found for %s", e.VersionCode, e.PackageID)
+	}
+	return fmt.Sprintf("version %s not found for %s", e.Version, e.PackageID)
+}
+
+// Is reports whether target is ErrVersionNotFound
+func (e *VersionNotFoundError) Is(target error) bool { return target == ErrVersionNotFound }
+
+// HTTPStatusError reports an unexpected HTTP response status
+type HTTPStatusError struct {
+	StatusCode int
+	URL        string
+	// Body holds the start of the response body, for diagnostics
+	Body string
+	// RetryAfter is the delay requested by a Retry-After header, or zero
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("invalid status code: %d", e.StatusCode)
+}
+
+// RateLimitedError reports that APKPure throttled the request: a 429, or
+// a 503 carrying Retry-After. The delay requested by the server, if any,
+// is in the embedded RetryAfter field.
+type RateLimitedError struct {
+	HTTPStatusError
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited (status %d), retry after %s", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("rate limited (status %d)", e.StatusCode)
+}
+
+// Is reports whether target is ErrRateLimited
+func (e *RateLimitedError) Is(target error) bool { return target == ErrRateLimited }
+
+// Unwrap exposes the embedded *HTTPStatusError to errors.As
+func (e *RateLimitedError) Unwrap() error { return &e.HTTPStatusError }
+
+// FileExistsError reports that the output file is already present
+type FileExistsError struct {
+	Path string
+}
+
+func (e *FileExistsError) Error() string {
+	return fmt.Sprintf("file already exists: %s", e.Path)
+}
+
+// Is reports whether target is ErrFileExists
+func (e *FileExistsError) Is(target error) bool { return target == ErrFileExists }
+
+// VerificationError reports that a downloaded file failed an integrity or
+// authenticity check
+type VerificationError struct {
+	Path string
+	// Check names the failed check (e.g. "sha256" or "signature")
+	Check    string
+	Expected string
+	Actual   string
+	// Err is the underlying cause when the check could not be completed
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s verification failed for %s: %v", e.Check, e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s verification failed for %s: expected %s, got %s", e.Check, e.Path, e.Expected, e.Actual)
+}
+
+// Is reports whether target is ErrVerificationFailed
+func (e *VerificationError) Is(target error) bool { return target == ErrVerificationFailed }
+
+// Unwrap returns the underlying cause
+func (e *VerificationError) Unwrap() error { return e.Err }
+
+// LockDriftError reports that a locked build is no longer offered as
+// pinned. Field names what changed: "version_code" when the build is gone,
+// "apk_type" or "sha256".
+type LockDriftError struct {
+	PackageID   string
+	VersionCode string
+	Field       string
+	Locked      string
+	Current     string
+	// Err is the underlying cause, if any (e.g. a *VerificationError)
+	Err error
+}
+
+func (e *LockDriftError) Error() string {
+	if e.Field == "version_code" {
+		return fmt.Sprintf("locked build %s#%s is no longer available", e.PackageID, e.VersionCode)
+	}
+	return fmt.Sprintf("locked build %s#%s changed %s: locked %s, now %s", e.PackageID, e.VersionCode, e.Field, e.Locked, e.Current)
+}
+
+// Is reports whether target is ErrLockDrift
+func (e *LockDriftError) Is(target error) bool { return target == ErrLockDrift }
+
+// Unwrap returns the underlying cause
+func (e *LockDriftError) Unwrap() error { return e.Err }
+
+// ErrorKind classifies err into one of the Kind* constants. It returns
+// "" for a nil error.
+func ErrorKind(err error) string {
+	var statusErr *HTTPStatusError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return KindCanceled
+	case errors.Is(err, ErrPackageNotFound):
+		return KindPackageNotFound
+	case errors.Is(err, ErrLockDrift):
+		return KindLockDrift
+	case errors.Is(err, ErrVersionNotFound):
+		return KindVersionNotFound
+	case errors.Is(err, ErrRateLimited):
+		return KindRateLimited
+	case errors.Is(err, ErrVerificationFailed):
+		return KindVerificationFailed
+	case errors.Is(err, ErrFileExists):
+		return KindFileExists
+	case errors.Is(err, ErrNotCached):
+		return KindNotCached
+	case errors.As(err, &statusErr):
+		return KindHTTPStatus
+	default:
+		return KindOther
+	}
+}
+
+// newStatusError builds the typed error for a non-OK response, consuming
+// up to maxBodySnippet bytes of its body
+func newStatusError(resp *http.Response) error {
+	snippet := make([]byte, maxBodySnippet)
+	n, _ := io.ReadFull(resp.Body, snippet)
+
+	statusErr := HTTPStatusError{
+		StatusCode: resp.StatusCode,
+		URL:        resp.Request.URL.String(),
+		Body:       string(snippet[:n]),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+
+	// A 503 that says when to come back is throttling too; without
+	// Retry-After it is an ordinary outage
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable && statusErr.RetryAfter > 0 {
+		return &RateLimitedError{HTTPStatusError: statusErr}
+	}
+
+	return &statusErr
+}
+
+// parseRetryAfter parses a Retry-After header given either as seconds or
+// as an HTTP date. It returns zero if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
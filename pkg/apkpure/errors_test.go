@@ -0,0 +1,55 @@
+package apkpure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	statusErr := &HTTPStatusError{StatusCode: 500, URL: "https://example.com"}
+	verifyErr := &VerificationError{Path: "a.apk", Check: "sha256", Expected: "aa", Actual: "bb"}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"package not found", &PackageNotFoundError{PackageID: "com.example"}, KindPackageNotFound},
+		{"package not found from status", &PackageNotFoundError{PackageID: "com.example", Err: &HTTPStatusError{StatusCode: 404}}, KindPackageNotFound},
+		{"package not found sentinel", ErrPackageNotFound, KindPackageNotFound},
+		{"version not found", &VersionNotFoundError{PackageID: "com.example", Version: "1.0"}, KindVersionNotFound},
+		{"rate limited", &RateLimitedError{HTTPStatusError{StatusCode: 429}}, KindRateLimited},
+		{"http status", statusErr, KindHTTPStatus},
+		{"file exists", &FileExistsError{Path: "a.apk"}, KindFileExists},
+		{"verification", verifyErr, KindVerificationFailed},
+		{"verification with cause", &VerificationError{Path: "a.apk", Check: "signature", Err: errors.New("no signing block")}, KindVerificationFailed},
+		{"not cached", fmt.Errorf("com.example: %w", ErrNotCached), KindNotCached},
+		{"lock drift", &LockDriftError{PackageID: "com.example", VersionCode: "1", Field: "version_code"}, KindLockDrift},
+		{"lock drift from verification", &LockDriftError{PackageID: "com.example", VersionCode: "1", Field: "sha256", Err: verifyErr}, KindLockDrift},
+		{"canceled", context.Canceled, KindCanceled},
+		{"deadline", context.DeadlineExceeded, KindCanceled},
+		{"other", fs.ErrPermission, KindOther},
+
+		{"wrapped package not found", fmt.Errorf("fetching: %w", &PackageNotFoundError{PackageID: "com.example"}), KindPackageNotFound},
+		{"wrapped version not found", fmt.Errorf("resolving: %w", &VersionNotFoundError{PackageID: "com.example", VersionCode: "1"}), KindVersionNotFound},
+		{"wrapped rate limited", fmt.Errorf("listing: %w", &RateLimitedError{HTTPStatusError{StatusCode: 503}}), KindRateLimited},
+		{"wrapped http status", fmt.Errorf("downloading: %w", statusErr), KindHTTPStatus},
+		{"wrapped file exists", fmt.Errorf("saving: %w", &FileExistsError{Path: "a.apk"}), KindFileExists},
+		{"wrapped verification", fmt.Errorf("checking: %w", verifyErr), KindVerificationFailed},
+		{"wrapped lock drift", fmt.Errorf("installing: %w", &LockDriftError{Field: "apk_type"}), KindLockDrift},
+		{"wrapped canceled", fmt.Errorf("downloading: %w", context.Canceled), KindCanceled},
+		{"joined", errors.Join(errors.New("first"), &FileExistsError{Path: "a.apk"}), KindFileExists},
+		{"wrapped other", fmt.Errorf("opening: %w", fs.ErrNotExist), KindOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorKind(tt.err); got != tt.want {
+				t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,519 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"cmp"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// F-Droid index file names
+const (
+	fdroidIndexV1    = "index-v1.json"
+	fdroidIndexV1JAR = "index-v1.jar"
+	fdroidIndexV2    = "index-v2.json"
+	fdroidEntry      = "entry.json"
+	fdroidEntryJAR   = "entry.jar"
+	// fdroidIndexVersion is the repo format version written to the index
+	fdroidIndexVersion = 20002
+)
+
+// RepoOptions describes the F-Droid repository written by
+// WriteFDroidIndex
+type RepoOptions struct {
+	Name        string
+	Description string
+	// Address is the public URL of the directory holding the APKs
+	Address string
+	// Timestamp of the index; zero uses the current time
+	Timestamp time.Time
+	// Signer signs index-v1.jar and entry.jar when set
+	Signer *RepoSigner
+}
+
+// RepoSigner holds the key F-Droid index JARs are signed with
+type RepoSigner struct {
+	Key         crypto.Signer
+	Certificate *x509.Certificate
+}
+
+// RepoIndexSummary describes a written F-Droid index
+type RepoIndexSummary struct {
+	Dir      string `json:"dir"`
+	Packages int    `json:"packages"`
+	APKs     int    `json:"apks"`
+	// Files lists the index files written
+	Files []string `json:"files"`
+	// Skipped maps file names that were not indexed to the reason
+	Skipped map[string]string `json:"skipped,omitempty"`
+	// Fingerprint is the SHA-256 of the signing certificate, which F-Droid
+	// clients pin when adding the repository
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// fdroidAPK is the metadata gathered for one APK in the repository
+type fdroidAPK struct {
+	file      string
+	size      int64
+	sha256    string
+	added     time.Time
+	manifest  *ManifestInfo
+	signers   []string
+	nativeABI []string
+}
+
+// LoadRepoSigner reads a PEM private key (PKCS#8, PKCS#1 or EC) and a
+// PEM certificate. A Java keystore can be converted with keytool and
+// openssl first.
+func LoadRepoSigner(keyPath, certPath string) (*RepoSigner, error) {
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no PEM data in %s", keyPath)
+	}
+	var key any
+	switch keyBlock.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %w", keyPath, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no PEM data in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate %s: %w", certPath, err)
+	}
+
+	return &RepoSigner{Key: signer, Certificate: cert}, nil
+}
+
+// WriteFDroidIndex scans dir for APKs written by Client.Download and
+// writes an F-Droid repository index next to them: index-v1.json,
+// index-v2.json and entry.json, plus index-v1.jar and entry.jar when
+// opts.Signer is set. XAPKs are skipped since F-Droid cannot install them.
+func WriteFDroidIndex(dir string, opts RepoOptions) (*RepoIndexSummary, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timestamp.IsZero() {
+		opts.Timestamp = time.Now()
+	}
+
+	summary := &RepoIndexSummary{Dir: dir, Files: []string{}, Skipped: make(map[string]string)}
+	var apks []fdroidAPK
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".apk":
+		case ".xapk":
+			summary.Skipped[name] = "XAPKs are not supported by F-Droid"
+			continue
+		default:
+			continue
+		}
+
+		apk, err := readFDroidAPK(filepath.Join(dir, name))
+		if err != nil {
+			summary.Skipped[name] = err.Error()
+			continue
+		}
+		apks = append(apks, *apk)
+	}
+
+	// Group by package, newest versionCode first
+	slices.SortFunc(apks, func(a, b fdroidAPK) int {
+		if c := strings.Compare(a.manifest.PackageName, b.manifest.PackageName); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.manifest.VersionCode, a.manifest.VersionCode)
+	})
+
+	v1 := buildFDroidIndexV1(apks, opts)
+	v2 := buildFDroidIndexV2(apks, opts)
+
+	v1Data, err := json.MarshalIndent(v1, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	v2Data, err := json.MarshalIndent(v2, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	v2Sum := sha256.Sum256(v2Data)
+	entryData, err := json.MarshalIndent(fdroidEntryJSON{
+		Timestamp: opts.Timestamp.UnixMilli(),
+		Version:   fdroidIndexVersion,
+		Index: fdroidFileV2{
+			Name:        "/" + fdroidIndexV2,
+			SHA256:      hex.EncodeToString(v2Sum[:]),
+			Size:        int64(len(v2Data)),
+			NumPackages: len(v2.Packages),
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []jarEntry{{fdroidIndexV1, v1Data}, {fdroidIndexV2, v2Data}, {fdroidEntry, entryData}}
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(dir, f.name), func(w io.Writer) error {
+			_, err := w.Write(f.data)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		summary.Files = append(summary.Files, f.name)
+	}
+
+	if opts.Signer != nil {
+		for _, jar := range []struct {
+			name  string
+			entry jarEntry
+		}{
+			{fdroidIndexV1JAR, files[0]},
+			{fdroidEntryJAR, files[2]},
+		} {
+			err := writeFileAtomic(filepath.Join(dir, jar.name), func(w io.Writer) error {
+				return writeSignedJAR(w, []jarEntry{jar.entry}, opts.Signer.Key, opts.Signer.Certificate)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign %s: %w", jar.name, err)
+			}
+			summary.Files = append(summary.Files, jar.name)
+		}
+		fingerprint := sha256.Sum256(opts.Signer.Certificate.Raw)
+		summary.Fingerprint = hex.EncodeToString(fingerprint[:])
+	}
+
+	summary.Packages = len(v2.Packages)
+	summary.APKs = len(apks)
+	return summary, nil
+}
+
+// readFDroidAPK gathers the index metadata of one APK
+func readFDroidAPK(apkPath string) (*fdroidAPK, error) {
+	info, err := os.Stat(apkPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(apkPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := parseAPKManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	apk := &fdroidAPK{
+		file:     filepath.Base(apkPath),
+		size:     info.Size(),
+		sha256:   hex.EncodeToString(h.Sum(nil)),
+		added:    info.ModTime(),
+		manifest: manifest,
+	}
+
+	// The signer is informational; an unverifiable APK is still indexed
+	if sig, err := verifyAPKSignature(f, info.Size()); err == nil {
+		apk.signers = sig.SignerCertSHA256
+	}
+
+	for _, file := range zr.File {
+		if abi, ok := strings.CutPrefix(path.Dir(file.Name), "lib/"); ok && !strings.Contains(abi, "/") {
+			if !slices.Contains(apk.nativeABI, abi) {
+				apk.nativeABI = append(apk.nativeABI, abi)
+			}
+		}
+	}
+	slices.Sort(apk.nativeABI)
+
+	return apk, nil
+}
+
+// appName returns the label to show for an APK's app
+func (a fdroidAPK) appName() string {
+	if a.manifest.Label != "" && !strings.HasPrefix(a.manifest.Label, "@") {
+		return a.manifest.Label
+	}
+	return a.manifest.PackageName
+}
+
+// fdroidIndexV1JSON is the index-v1.json document
+type fdroidIndexV1JSON struct {
+	Repo     fdroidRepoV1                 `json:"repo"`
+	Requests fdroidRequestsV1             `json:"requests"`
+	Apps     []fdroidAppV1                `json:"apps"`
+	Packages map[string][]fdroidPackageV1 `json:"packages"`
+}
+
+type fdroidRepoV1 struct {
+	Timestamp   int64  `json:"timestamp"`
+	Version     int    `json:"version"`
+	Name        string `json:"name"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+type fdroidRequestsV1 struct {
+	Install   []string `json:"install"`
+	Uninstall []string `json:"uninstall"`
+}
+
+type fdroidAppV1 struct {
+	PackageName          string `json:"packageName"`
+	Name                 string `json:"name"`
+	SuggestedVersionCode string `json:"suggestedVersionCode"`
+	SuggestedVersionName string `json:"suggestedVersionName"`
+	License              string `json:"license"`
+	Added                int64  `json:"added"`
+	LastUpdated          int64  `json:"lastUpdated"`
+}
+
+type fdroidPackageV1 struct {
+	APKName          string   `json:"apkName"`
+	Hash             string   `json:"hash"`
+	HashType         string   `json:"hashType"`
+	PackageName      string   `json:"packageName"`
+	Size             int64    `json:"size"`
+	VersionCode      int64    `json:"versionCode"`
+	VersionName      string   `json:"versionName"`
+	MinSDKVersion    int      `json:"minSdkVersion,omitempty"`
+	TargetSDKVersion int      `json:"targetSdkVersion,omitempty"`
+	Signer           string   `json:"signer,omitempty"`
+	UsesPermission   [][]any  `json:"uses-permission,omitempty"`
+	NativeCode       []string `json:"nativecode,omitempty"`
+	Added            int64    `json:"added"`
+}
+
+// buildFDroidIndexV1 assembles index-v1.json from APKs sorted by package
+// and newest first
+func buildFDroidIndexV1(apks []fdroidAPK, opts RepoOptions) fdroidIndexV1JSON {
+	index := fdroidIndexV1JSON{
+		Repo: fdroidRepoV1{
+			Timestamp:   opts.Timestamp.UnixMilli(),
+			Version:     fdroidIndexVersion,
+			Name:        opts.Name,
+			Address:     opts.Address,
+			Description: opts.Description,
+		},
+		Requests: fdroidRequestsV1{Install: []string{}, Uninstall: []string{}},
+		Apps:     []fdroidAppV1{},
+		Packages: make(map[string][]fdroidPackageV1),
+	}
+
+	for _, apk := range apks {
+		m := apk.manifest
+		pkg := fdroidPackageV1{
+			APKName:          apk.file,
+			Hash:             apk.sha256,
+			HashType:         "sha256",
+			PackageName:      m.PackageName,
+			Size:             apk.size,
+			VersionCode:      m.VersionCode,
+			VersionName:      m.VersionName,
+			MinSDKVersion:    m.MinSDK,
+			TargetSDKVersion: m.TargetSDK,
+			NativeCode:       apk.nativeABI,
+			Added:            apk.added.UnixMilli(),
+		}
+		if len(apk.signers) > 0 {
+			pkg.Signer = apk.signers[0]
+		}
+		for _, permission := range m.Permissions {
+			pkg.UsesPermission = append(pkg.UsesPermission, []any{permission, nil})
+		}
+
+		if _, seen := index.Packages[m.PackageName]; !seen {
+			index.Apps = append(index.Apps, fdroidAppV1{
+				PackageName:          m.PackageName,
+				Name:                 apk.appName(),
+				SuggestedVersionCode: fmt.Sprint(m.VersionCode),
+				SuggestedVersionName: m.VersionName,
+				License:              "Unknown",
+			})
+		}
+		index.Packages[m.PackageName] = append(index.Packages[m.PackageName], pkg)
+
+		app := &index.Apps[len(index.Apps)-1]
+		added := apk.added.UnixMilli()
+		if app.Added == 0 || added < app.Added {
+			app.Added = added
+		}
+		app.LastUpdated = max(app.LastUpdated, added)
+	}
+
+	return index
+}
+
+// fdroidIndexV2JSON is the index-v2.json document
+type fdroidIndexV2JSON struct {
+	Repo     fdroidRepoV2               `json:"repo"`
+	Packages map[string]fdroidPackageV2 `json:"packages"`
+}
+
+type fdroidRepoV2 struct {
+	Name        map[string]string `json:"name"`
+	Description map[string]string `json:"description"`
+	Address     string            `json:"address"`
+	Timestamp   int64             `json:"timestamp"`
+}
+
+type fdroidPackageV2 struct {
+	Metadata fdroidMetadataV2           `json:"metadata"`
+	Versions map[string]fdroidVersionV2 `json:"versions"`
+}
+
+type fdroidMetadataV2 struct {
+	Added       int64             `json:"added"`
+	LastUpdated int64             `json:"lastUpdated"`
+	Name        map[string]string `json:"name"`
+	License     string            `json:"license"`
+}
+
+type fdroidVersionV2 struct {
+	Added    int64            `json:"added"`
+	File     fdroidFileV2     `json:"file"`
+	Manifest fdroidManifestV2 `json:"manifest"`
+}
+
+type fdroidFileV2 struct {
+	Name        string `json:"name"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	NumPackages int    `json:"numPackages,omitempty"`
+}
+
+type fdroidManifestV2 struct {
+	VersionName    string               `json:"versionName"`
+	VersionCode    int64                `json:"versionCode"`
+	UsesSDK        *fdroidUsesSDKV2     `json:"usesSdk,omitempty"`
+	Signer         *fdroidSignerV2      `json:"signer,omitempty"`
+	UsesPermission []fdroidPermissionV2 `json:"usesPermission,omitempty"`
+	NativeCode     []string             `json:"nativecode,omitempty"`
+}
+
+type fdroidUsesSDKV2 struct {
+	MinSDKVersion    int `json:"minSdkVersion"`
+	TargetSDKVersion int `json:"targetSdkVersion"`
+}
+
+type fdroidSignerV2 struct {
+	SHA256 []string `json:"sha256"`
+}
+
+type fdroidPermissionV2 struct {
+	Name string `json:"name"`
+}
+
+type fdroidEntryJSON struct {
+	Timestamp int64        `json:"timestamp"`
+	Version   int          `json:"version"`
+	Index     fdroidFileV2 `json:"index"`
+}
+
+// buildFDroidIndexV2 assembles index-v2.json. Versions are keyed by the
+// APK's SHA-256.
+func buildFDroidIndexV2(apks []fdroidAPK, opts RepoOptions) fdroidIndexV2JSON {
+	index := fdroidIndexV2JSON{
+		Repo: fdroidRepoV2{
+			Name:        map[string]string{"en-US": opts.Name},
+			Description: map[string]string{"en-US": opts.Description},
+			Address:     opts.Address,
+			Timestamp:   opts.Timestamp.UnixMilli(),
+		},
+		Packages: make(map[string]fdroidPackageV2),
+	}
+
+	for _, apk := range apks {
+		m := apk.manifest
+		version := fdroidVersionV2{
+			Added: apk.added.UnixMilli(),
+			File: fdroidFileV2{
+				Name:   "/" + apk.file,
+				SHA256: apk.sha256,
+				Size:   apk.size,
+			},
+			Manifest: fdroidManifestV2{
+				VersionName: m.VersionName,
+				VersionCode: m.VersionCode,
+				NativeCode:  apk.nativeABI,
+			},
+		}
+		if m.MinSDK != 0 || m.TargetSDK != 0 {
+			version.Manifest.UsesSDK = &fdroidUsesSDKV2{MinSDKVersion: m.MinSDK, TargetSDKVersion: m.TargetSDK}
+		}
+		if len(apk.signers) > 0 {
+			version.Manifest.Signer = &fdroidSignerV2{SHA256: apk.signers}
+		}
+		for _, permission := range m.Permissions {
+			version.Manifest.UsesPermission = append(version.Manifest.UsesPermission, fdroidPermissionV2{Name: permission})
+		}
+
+		pkg, ok := index.Packages[m.PackageName]
+		if !ok {
+			pkg = fdroidPackageV2{
+				Metadata: fdroidMetadataV2{
+					Added:   version.Added,
+					Name:    map[string]string{"en-US": apk.appName()},
+					License: "Unknown",
+				},
+				Versions: make(map[string]fdroidVersionV2),
+			}
+		}
+		pkg.Metadata.Added = min(pkg.Metadata.Added, version.Added)
+		pkg.Metadata.LastUpdated = max(pkg.Metadata.LastUpdated, version.Added)
+		pkg.Versions[apk.sha256] = version
+		index.Packages[m.PackageName] = pkg
+	}
+
+	return index
+}
@@ -0,0 +1,167 @@
+package apkpure
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HistoryOptions bounds which versions DownloadAllVersions fetches. The
+// AppInfo version constraint and versionCode apply as well.
+type HistoryOptions struct {
+	// Limit keeps only the newest N matching versions (0 keeps all)
+	Limit int
+	// Since and Until bound the release date, inclusive (zero means no
+	// bound). Versions without a date are skipped when either is set.
+	Since time.Time
+	Until time.Time
+}
+
+// DownloadAllVersions downloads every listed version of each app that
+// matches opts. Builds already present in outPath are skipped.
+func (c *Client) DownloadAllVersions(apps []AppInfo, outPath string, opts HistoryOptions) []DownloadResult {
+	return c.DownloadAllVersionsContext(context.Background(), apps, outPath, opts)
+}
+
+// DownloadAllVersionsContext is like DownloadAllVersions but stops once
+// ctx is done. Results are grouped by app in input order, newest version
+// first; an app whose listing fails contributes a single failed result.
+func (c *Client) DownloadAllVersionsContext(ctx context.Context, apps []AppInfo, outPath string, opts HistoryOptions) []DownloadResult {
+	perApp := make([][]DownloadResult, len(apps))
+	var wg sync.WaitGroup
+
+	// One semaphore bounds listings and downloads across all apps
+	sem := make(chan struct{}, c.options.Parallel)
+
+	for i, app := range apps {
+		wg.Add(1)
+		go func(idx int, appInfo AppInfo) {
+			defer wg.Done()
+
+			perApp[idx] = c.downloadHistory(ctx, sem, appInfo, outPath, opts)
+		}(i, app)
+	}
+
+	wg.Wait()
+	return slices.Concat(perApp...)
+}
+
+// downloadHistory lists one app's versions and downloads the selected ones
+// in parallel
+func (c *Client) downloadHistory(ctx context.Context, sem chan struct{}, app AppInfo, outPath string, opts HistoryOptions) []DownloadResult {
+	failed := func(err error) []DownloadResult {
+		return []DownloadResult{{AppInfo: app, Filename: appString(app), Error: err}}
+	}
+
+	if err := acquire(ctx, sem); err != nil {
+		return failed(err)
+	}
+	versions, err := c.fetchVersions(ctx, app.PackageID)
+	<-sem
+	if err != nil {
+		return failed(err)
+	}
+	if len(versions) == 0 {
+		return failed(&PackageNotFoundError{PackageID: app.PackageID})
+	}
+
+	selected, err := selectHistory(app, versions, opts)
+	if err != nil {
+		return failed(err)
+	}
+	if len(selected) == 0 {
+		return failed(&VersionNotFoundError{PackageID: app.PackageID, Version: app.Version, VersionCode: app.VersionCode})
+	}
+
+	// A checksum pins one file, so it can't apply to a whole history
+	app.Checksum = Checksum{}
+
+	results := make([]DownloadResult, len(selected))
+	var wg sync.WaitGroup
+	for i, version := range selected {
+		wg.Add(1)
+		go func(idx int, version VersionInfo) {
+			defer wg.Done()
+
+			results[idx] = c.acquireAndRun(ctx, sem, app, func() DownloadResult {
+				return c.downloadVersionWithResult(ctx, app, version, outPath)
+			})
+		}(i, version)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// downloadVersionWithResult downloads one listed version, skipping it if
+// the file is already in outPath
+func (c *Client) downloadVersionWithResult(ctx context.Context, app AppInfo, version VersionInfo, outPath string) DownloadResult {
+	start := time.Now()
+	result := DownloadResult{
+		AppInfo:  app,
+		Filename: versionFilename(app.PackageID, version),
+		Version:  version,
+	}
+
+	path := filepath.Join(outPath, result.Filename+versionExt(version))
+	if info, err := os.Stat(path); err == nil {
+		c.logf("Skipping %s, already downloaded\n", result.Filename)
+		result.Path = path
+		result.Size = info.Size()
+		result.Success = true
+		result.Skipped = true
+		return result
+	}
+
+	err := c.downloadVersion(ctx, app, version, outPath, &result)
+	result.Success = err == nil
+	result.Error = err
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+// selectHistory filters a listing (newest first) down to the versions of
+// app that match opts, keeping the API order
+func selectHistory(app AppInfo, versions []VersionInfo, opts HistoryOptions) ([]VersionInfo, error) {
+	constraint, err := ParseVersionConstraint(app.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []VersionInfo
+	for _, v := range versions {
+		if app.VersionCode != "" && v.VersionCode != app.VersionCode {
+			continue
+		}
+		if !constraint.Match(v) {
+			continue
+		}
+		if !opts.Since.IsZero() || !opts.Until.IsZero() {
+			date := v.releaseDate()
+			if date.IsZero() ||
+				(!opts.Since.IsZero() && date.Before(opts.Since)) ||
+				(!opts.Until.IsZero() && date.After(opts.Until)) {
+				continue
+			}
+		}
+		selected = append(selected, v)
+	}
+
+	if opts.Limit > 0 && len(selected) > opts.Limit {
+		selected = selected[:opts.Limit]
+	}
+	return selected, nil
+}
+
+// releaseDate returns when a version was released, falling back to its
+// last update
+func (v VersionInfo) releaseDate() time.Time {
+	if !v.ReleasedAt.IsZero() {
+		return v.ReleasedAt
+	}
+	return v.UpdatedAt
+}
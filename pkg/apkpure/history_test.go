@@ -0,0 +1,178 @@
+package apkpure
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDownloadAllVersions(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	builds := []fakeBuild{
+		{name: "3.1", code: "310", content: testPayload(100, 4), released: "2024-06-01"},
+		{name: "3.0", code: "300", content: testPayload(100, 3), released: "2024-03-01"},
+		{name: "2.0", code: "200", content: testPayload(100, 2)},
+		{name: "1.0", code: "100", content: testPayload(100, 1), released: "2023-01-01"},
+	}
+
+	tests := []struct {
+		name string
+		app  AppInfo
+		opts HistoryOptions
+		// existing are versionCodes already in the output directory
+		existing []string
+		// want are the versionCodes of the results, in order
+		want []string
+		// skipped are the versionCodes that were not downloaded again
+		skipped []string
+		// fails is set when the app yields a single failed result,
+		// matching wantErr if that is set
+		fails   bool
+		wantErr error
+	}{
+		{
+			name: "all",
+			want: []string{"310", "300", "200", "100"},
+		},
+		{
+			name: "limit",
+			opts: HistoryOptions{Limit: 2},
+			want: []string{"310", "300"},
+		},
+		{
+			name: "limit above count",
+			opts: HistoryOptions{Limit: 10},
+			want: []string{"310", "300", "200", "100"},
+		},
+		{
+			name: "since skips undated",
+			opts: HistoryOptions{Since: day("2024-03-01")},
+			want: []string{"310", "300"},
+		},
+		{
+			name: "until",
+			opts: HistoryOptions{Until: day("2024-03-01")},
+			want: []string{"300", "100"},
+		},
+		{
+			name: "since and until",
+			opts: HistoryOptions{Since: day("2023-06-01"), Until: day("2024-05-01")},
+			want: []string{"300"},
+		},
+		{
+			name: "range then limit",
+			opts: HistoryOptions{Until: day("2024-12-31"), Limit: 1},
+			want: []string{"310"},
+		},
+		{
+			name: "constraint",
+			app:  AppInfo{Version: ">=2.0 <3.1"},
+			want: []string{"300", "200"},
+		},
+		{
+			name: "exact versionCode",
+			app:  AppInfo{VersionCode: "200"},
+			want: []string{"200"},
+		},
+		{
+			name:     "existing files skipped",
+			existing: []string{"300", "100"},
+			want:     []string{"310", "300", "200", "100"},
+			skipped:  []string{"300", "100"},
+		},
+		{
+			name:    "nothing matches",
+			app:     AppInfo{Version: ">=4"},
+			fails:   true,
+			wantErr: ErrVersionNotFound,
+		},
+		{
+			name:  "invalid constraint",
+			app:   AppInfo{Version: ">=>1"},
+			fails: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, map[string][]fakeBuild{"com.example": builds})
+			client := newTestClient(api, DownloadOptions{Parallel: 2})
+			dir := t.TempDir()
+
+			for _, code := range tt.existing {
+				i := slices.IndexFunc(builds, func(b fakeBuild) bool { return b.code == code })
+				name := "com.example@" + builds[i].name + "_" + code + ".apk"
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			app := tt.app
+			app.PackageID = "com.example"
+			results := client.DownloadAllVersions([]AppInfo{app}, dir, tt.opts)
+
+			if tt.fails {
+				if len(results) != 1 || results[0].Error == nil ||
+					(tt.wantErr != nil && !errors.Is(results[0].Error, tt.wantErr)) {
+					t.Fatalf("results = %+v, want one failure matching %v", results, tt.wantErr)
+				}
+				if n := len(api.downloadRequests()); n != 0 {
+					t.Errorf("%d downloads were made for a failed selection", n)
+				}
+				return
+			}
+
+			var got, skipped []string
+			for _, r := range results {
+				if !r.Success {
+					t.Fatalf("%s failed: %v", r.Filename, r.Error)
+				}
+				got = append(got, r.Version.VersionCode)
+				if r.Skipped {
+					skipped = append(skipped, r.Version.VersionCode)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("downloaded %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("skipped %v, want %v", skipped, tt.skipped)
+			}
+			if n, want := len(api.downloadRequests()), len(tt.want)-len(tt.skipped); n != want {
+				t.Errorf("made %d download requests, want %d", n, want)
+			}
+			for _, code := range tt.skipped {
+				i := slices.IndexFunc(results, func(r DownloadResult) bool { return r.Version.VersionCode == code })
+				if data, err := os.ReadFile(results[i].Path); err != nil || string(data) != "old" {
+					t.Errorf("existing file for %s was replaced", code)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadAllVersionsUnknownPackage(t *testing.T) {
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {{name: "1.0", code: "1", content: testPayload(10, 1)}},
+	})
+	client := newTestClient(api, DownloadOptions{})
+
+	results := client.DownloadAllVersions([]AppInfo{{PackageID: "com.missing"}, {PackageID: "com.example"}}, t.TempDir(), HistoryOptions{})
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if results[0].Success || results[0].AppInfo.PackageID != "com.missing" {
+		t.Errorf("first result = %+v, want a failure for com.missing", results[0])
+	}
+	if !results[1].Success || results[1].Version.VersionCode != "1" {
+		t.Errorf("second result = %+v, want com.example 1", results[1])
+	}
+}
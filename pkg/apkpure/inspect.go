@@ -0,0 +1,157 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FileInfo describes a local APK or XAPK
+type FileInfo struct {
+	Path string `json:"path"`
+	// Type is "APK" or "XAPK"
+	Type   string `json:"type"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	SHA1   string `json:"sha1"`
+	MD5    string `json:"md5"`
+
+	Manifest *ManifestInfo `json:"manifest,omitempty"`
+	// ManifestError is set when the manifest could not be decoded
+	ManifestError string `json:"manifest_error,omitempty"`
+
+	Signature *SignatureInfo `json:"signature,omitempty"`
+	// SignatureError is set when the signature did not verify
+	SignatureError string `json:"signature_error,omitempty"`
+}
+
+// VerifyOptions lists what VerifyFile checks besides the signature.
+// Empty fields are not checked.
+type VerifyOptions struct {
+	// Checksum is the digest the file must have
+	Checksum Checksum
+	// PackageID and VersionCode are compared with the manifest
+	PackageID   string
+	VersionCode string
+	// PinnedCertificates maps package names to allowed signer
+	// certificate digests; the package in the file's manifest selects
+	// the pins that apply
+	PinnedCertificates map[string][]string
+}
+
+// InspectFile hashes the APK or XAPK at path and decodes its manifest and
+// signature. A bad manifest or signature is reported in the FileInfo; only
+// a file that cannot be read as a zip archive is an error.
+func InspectFile(path string) (*FileInfo, error) {
+	fileType, err := detectFileType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FileInfo{
+		Path:   path,
+		Type:   fileType,
+		Size:   stats.size,
+		SHA256: stats.sha256,
+		SHA1:   stats.sha1,
+		MD5:    stats.md5,
+	}
+
+	if fileType == "XAPK" {
+		info.Manifest, err = ParseXAPKManifest(path)
+	} else {
+		info.Manifest, err = ParseAPKManifest(path)
+	}
+	if err != nil {
+		info.ManifestError = err.Error()
+	}
+
+	if fileType == "XAPK" {
+		info.Signature, err = verifyXAPKSignature(path)
+	} else {
+		info.Signature, err = VerifyAPKSignature(path)
+	}
+	if err != nil {
+		info.SignatureError = err.Error()
+	}
+
+	return info, nil
+}
+
+// VerifyFile inspects the APK or XAPK at path and checks it: the
+// signature must verify and the file must match opts. The first failed
+// check is returned as a *VerificationError together with the FileInfo.
+func VerifyFile(path string, opts VerifyOptions) (*FileInfo, error) {
+	info, err := InspectFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(check, expected, actual string) (*FileInfo, error) {
+		return info, &VerificationError{Path: path, Check: check, Expected: expected, Actual: actual}
+	}
+
+	if opts.Checksum.Value != "" {
+		stats := fileStats{sha256: info.SHA256, sha1: info.SHA1, md5: info.MD5}
+		if _, err := verifyChecksums(path, stats, []Checksum{opts.Checksum}); err != nil {
+			return info, err
+		}
+	}
+
+	if info.SignatureError != "" {
+		return info, &VerificationError{Path: path, Check: "signature", Err: fmt.Errorf("%s", info.SignatureError)}
+	}
+	var pins []string
+	if info.Manifest != nil {
+		pins = opts.PinnedCertificates[info.Manifest.PackageName]
+	}
+	if len(pins) > 0 {
+		for _, pin := range pins {
+			if slices.Contains(info.Signature.SignerCertSHA256, NormalizeCertDigest(pin)) {
+				info.Signature.PinMatched = true
+				break
+			}
+		}
+		if !info.Signature.PinMatched {
+			return fail("certificate pin", strings.Join(pins, ","), strings.Join(info.Signature.SignerCertSHA256, ","))
+		}
+	}
+
+	if opts.PackageID != "" || opts.VersionCode != "" {
+		if info.ManifestError != "" {
+			return info, &VerificationError{Path: path, Check: "manifest", Err: fmt.Errorf("%s", info.ManifestError)}
+		}
+		if opts.PackageID != "" && info.Manifest.PackageName != opts.PackageID {
+			return fail("manifest package", opts.PackageID, info.Manifest.PackageName)
+		}
+		if code := strconv.FormatInt(info.Manifest.VersionCode, 10); opts.VersionCode != "" && code != opts.VersionCode {
+			return fail("manifest versionCode", opts.VersionCode, code)
+		}
+	}
+
+	return info, nil
+}
+
+// detectFileType tells an APK from an XAPK: only APKs have a manifest at
+// the root of the archive
+func detectFileType(path string) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = zr.Close() }()
+
+	for _, f := range zr.File {
+		if f.Name == "AndroidManifest.xml" {
+			return "APK", nil
+		}
+	}
+	return "XAPK", nil
+}
@@ -0,0 +1,529 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// PKCS#7 object identifiers used by JAR signatures
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// verifyPKCS7Detached verifies a detached PKCS#7 SignedData signature
+// over content and returns the signer certificate
+func verifyPKCS7Detached(der, content []byte) (*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7: %w", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("PKCS#7 content is not SignedData")
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 SignedData: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("PKCS#7 has no signers")
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	// Android only considers the first signer
+	signer := sd.SignerInfos[0]
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, signer.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("signer certificate not found")
+	}
+
+	hash, err := hashForOID(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// With authenticated attributes the signature covers them (re-tagged
+	// as a SET), and they carry the digest of the content
+	signed := content
+	if len(signer.AuthenticatedAttributes.Bytes) > 0 {
+		digest, err := messageDigestAttribute(signer.AuthenticatedAttributes.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(content)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return nil, errors.New("PKCS#7 message digest mismatch")
+		}
+
+		signed = bytes.Clone(signer.AuthenticatedAttributes.FullBytes)
+		signed[0] = 0x31 // SET
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	hashed := h.Sum(nil)
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, hash, hashed, signer.EncryptedDigest)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, signer.EncryptedDigest) {
+			err = errors.New("ecdsa verification failure")
+		}
+	default:
+		err = fmt.Errorf("unsupported key type %T", cert.PublicKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// messageDigestAttribute extracts the messageDigest value from DER encoded
+// PKCS#9 attributes
+func messageDigestAttribute(der []byte) ([]byte, error) {
+	for len(der) > 0 {
+		var attr pkcs7Attribute
+		rest, err := asn1.Unmarshal(der, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PKCS#7 attribute: %w", err)
+		}
+		der = rest
+
+		if attr.Type.Equal(oidMessageDigest) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+				return nil, fmt.Errorf("invalid message digest: %w", err)
+			}
+			return digest, nil
+		}
+	}
+	return nil, errors.New("PKCS#7 message digest attribute missing")
+}
+
+// hashForOID maps a digest algorithm OID to its hash
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+	}
+}
+
+// manifestSection is one section of a JAR manifest or signature file
+type manifestSection struct {
+	// raw holds the exact bytes of the section, including the blank line
+	// that terminates it
+	raw []byte
+	// attrs maps lowercased attribute names to values
+	attrs map[string]string
+}
+
+// parseManifest splits a JAR manifest into sections
+func parseManifest(data []byte) []manifestSection {
+	var sections []manifestSection
+	current := manifestSection{attrs: make(map[string]string)}
+	start := 0
+	lastKey := ""
+
+	for pos := 0; pos < len(data); {
+		// Find the end of the line, accepting CRLF, LF or CR
+		end := pos
+		for end < len(data) && data[end] != '\n' && data[end] != '\r' {
+			end++
+		}
+		line := string(data[pos:end])
+		next := end
+		if next < len(data) && data[next] == '\r' {
+			next++
+		}
+		if next < len(data) && data[next] == '\n' {
+			next++
+		}
+
+		switch {
+		case line == "":
+			current.raw = data[start:next]
+			if len(current.attrs) > 0 {
+				sections = append(sections, current)
+			}
+			current = manifestSection{attrs: make(map[string]string)}
+			start = next
+			lastKey = ""
+		case strings.HasPrefix(line, " ") && lastKey != "":
+			current.attrs[lastKey] += line[1:]
+		default:
+			if key, value, ok := strings.Cut(line, ": "); ok {
+				lastKey = strings.ToLower(key)
+				current.attrs[lastKey] = value
+			}
+		}
+		pos = next
+	}
+
+	if len(current.attrs) > 0 {
+		current.raw = data[start:]
+		sections = append(sections, current)
+	}
+
+	return sections
+}
+
+// jarDigestAlgorithms lists the digest attribute prefixes we understand,
+// strongest first
+var jarDigestAlgorithms = []struct {
+	name string
+	hash crypto.Hash
+}{
+	{"sha-512", crypto.SHA512},
+	{"sha-384", crypto.SHA384},
+	{"sha-256", crypto.SHA256},
+	{"sha1", crypto.SHA1},
+	{"sha-1", crypto.SHA1},
+}
+
+// digestAttribute returns the strongest "<alg><suffix>" attribute present
+func digestAttribute(attrs map[string]string, suffix string) (crypto.Hash, []byte, bool) {
+	for _, alg := range jarDigestAlgorithms {
+		value, ok := attrs[alg.name+suffix]
+		if !ok {
+			continue
+		}
+		digest, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		return alg.hash, digest, true
+	}
+	return 0, nil, false
+}
+
+// digestMatches reports whether data hashes to digest
+func digestMatches(hash crypto.Hash, data, digest []byte) bool {
+	h := hash.New()
+	h.Write(data)
+	return bytes.Equal(h.Sum(nil), digest)
+}
+
+// verifySignatureFile checks that a .SF file covers the manifest: either
+// the digest of the whole manifest matches, or the main attributes (when
+// the .SF has their digest) and every named manifest section match a
+// digest in the .SF
+func verifySignatureFile(sf, manifest []byte) error {
+	sfSections := parseManifest(sf)
+	if len(sfSections) == 0 {
+		return errors.New("empty signature file")
+	}
+
+	if hash, digest, ok := digestAttribute(sfSections[0].attrs, "-digest-manifest"); ok {
+		if digestMatches(hash, manifest, digest) {
+			return nil
+		}
+	}
+
+	mfSections := parseManifest(manifest)
+	if len(mfSections) == 0 {
+		return errors.New("empty manifest")
+	}
+	if hash, digest, ok := digestAttribute(sfSections[0].attrs, "-digest-manifest-main-attributes"); ok {
+		if !digestMatches(hash, mfSections[0].raw, digest) {
+			return errors.New("manifest main attributes digest mismatch")
+		}
+	}
+
+	signed := make(map[string]manifestSection)
+	for _, section := range sfSections[1:] {
+		signed[section.attrs["name"]] = section
+	}
+
+	named := make(map[string]bool)
+	for _, mfSection := range mfSections[1:] {
+		name, ok := mfSection.attrs["name"]
+		if !ok {
+			continue
+		}
+		named[name] = true
+
+		section, ok := signed[name]
+		if !ok {
+			return fmt.Errorf("manifest entry %s is not covered by the signature file", name)
+		}
+		hash, digest, ok := digestAttribute(section.attrs, "-digest")
+		if !ok {
+			return fmt.Errorf("no supported digest for %s", name)
+		}
+		if !digestMatches(hash, mfSection.raw, digest) {
+			return fmt.Errorf("manifest section %s digest mismatch", name)
+		}
+	}
+
+	for name := range signed {
+		if !named[name] {
+			return fmt.Errorf("signature file entry %s not in manifest", name)
+		}
+	}
+
+	return nil
+}
+
+// apkSignedSchemes returns the v2+ schemes a .SF file declares in its
+// X-Android-APK-Signed attribute. A v1 signature of an APK that also had
+// these schemes is only valid alongside them, which stops them from being
+// stripped.
+func apkSignedSchemes(sf []byte) []int {
+	sections := parseManifest(sf)
+	if len(sections) == 0 {
+		return nil
+	}
+
+	var schemes []int
+	for _, field := range strings.Split(sections[0].attrs["x-android-apk-signed"], ",") {
+		if scheme, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && scheme >= SchemeV2 {
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
+// verifyManifestEntries checks every zip entry against its manifest digest
+// and makes sure no entry is left unsigned
+func verifyManifestEntries(manifest []byte, files []*zip.File) error {
+	sections := make(map[string]manifestSection)
+	for _, section := range parseManifest(manifest) {
+		if name, ok := section.attrs["name"]; ok {
+			sections[name] = section
+		}
+	}
+
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, "/") || isJARSignatureFile(f.Name) {
+			continue
+		}
+
+		section, ok := sections[f.Name]
+		if !ok {
+			return fmt.Errorf("entry %s is not signed", f.Name)
+		}
+		hash, digest, ok := digestAttribute(section.attrs, "-digest")
+		if !ok {
+			return fmt.Errorf("no supported digest for %s", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		_, err = io.Copy(h, rc)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return fmt.Errorf("entry %s digest mismatch", f.Name)
+		}
+	}
+
+	return nil
+}
+
+// isJARSignatureFile reports whether name is part of the JAR signature
+// itself and therefore not listed in the manifest
+func isJARSignatureFile(name string) bool {
+	rest, ok := strings.CutPrefix(name, "META-INF/")
+	if !ok || strings.Contains(rest, "/") {
+		return false
+	}
+	upper := strings.ToUpper(rest)
+	return upper == "MANIFEST.MF" ||
+		strings.HasPrefix(upper, "SIG-") ||
+		strings.HasSuffix(upper, ".SF") ||
+		strings.HasSuffix(upper, ".RSA") ||
+		strings.HasSuffix(upper, ".DSA") ||
+		strings.HasSuffix(upper, ".EC")
+}
+
+// Object identifiers used when signing
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// jarEntry is a file to store in a signed JAR
+type jarEntry struct {
+	name string
+	data []byte
+}
+
+// writeSignedJAR writes entries to w as a JAR signed with key and cert
+// using a SHA-256 v1 signature, as F-Droid expects for its index JARs
+func writeSignedJAR(w io.Writer, entries []jarEntry, key crypto.Signer, cert *x509.Certificate) error {
+	const createdBy = "Created-By: apkpure-go\r\n"
+
+	var manifest bytes.Buffer
+	manifest.WriteString("Manifest-Version: 1.0\r\n" + createdBy + "\r\n")
+	var sections bytes.Buffer
+	for _, entry := range entries {
+		section := fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", entry.name, sha256Base64(entry.data))
+		manifest.WriteString(section)
+		fmt.Fprintf(&sections, "Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", entry.name, sha256Base64([]byte(section)))
+	}
+
+	var sf bytes.Buffer
+	sf.WriteString("Signature-Version: 1.0\r\n" + createdBy)
+	fmt.Fprintf(&sf, "SHA-256-Digest-Manifest: %s\r\n\r\n", sha256Base64(manifest.Bytes()))
+	sf.Write(sections.Bytes())
+
+	block, err := signPKCS7Detached(sf.Bytes(), key, cert)
+	if err != nil {
+		return err
+	}
+	blockName := "META-INF/CERT.RSA"
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		blockName = "META-INF/CERT.EC"
+	}
+
+	zw := zip.NewWriter(w)
+	all := append([]jarEntry{
+		{"META-INF/MANIFEST.MF", manifest.Bytes()},
+		{"META-INF/CERT.SF", sf.Bytes()},
+		{blockName, block},
+	}, entries...)
+	for _, entry := range all {
+		fw, err := zw.Create(entry.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// signPKCS7Detached creates a detached PKCS#7 SignedData signature over
+// content without authenticated attributes
+func signPKCS7Detached(content []byte, key crypto.Signer, cert *x509.Certificate) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var encryption pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		encryption = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		encryption = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key.Public())
+	}
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:           sha256Alg,
+			DigestEncryptionAlgorithm: encryption,
+			EncryptedDigest:           signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// sha256Base64 returns the base64-encoded SHA-256 digest of data
+func sha256Base64(data []byte) string {
+	digest := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
@@ -0,0 +1,339 @@
+package apkpure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockfileName is the conventional name of a lockfile
+const LockfileName = "apkpure.lock"
+
+// lockfileVersion is the format version written to new lockfiles
+const lockfileVersion = 1
+
+// Lockfile pins apps to exact builds so that fetches are reproducible.
+// It is created by Client.Lock, written with Save and read back with
+// LoadLockfile.
+type Lockfile struct {
+	LockfileVersion int             `json:"lockfile_version"`
+	Packages        []LockedPackage `json:"packages"`
+}
+
+// LockedPackage is one pinned build
+type LockedPackage struct {
+	PackageID string `json:"package_id"`
+	// Constraint and RequestedVersionCode record what was asked for, so
+	// the entry can be resolved again
+	Constraint           string `json:"constraint,omitempty"`
+	RequestedVersionCode string `json:"requested_version_code,omitempty"`
+
+	VersionName string `json:"version_name"`
+	VersionCode string `json:"version_code"`
+	APKType     string `json:"apk_type"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+// App returns the request the package was locked from
+func (p LockedPackage) App() AppInfo {
+	return AppInfo{PackageID: p.PackageID, Version: p.Constraint, VersionCode: p.RequestedVersionCode}
+}
+
+// Find returns the entry locked from the same request as app: the same
+// package, version constraint and versionCode
+func (l *Lockfile) Find(app AppInfo) (LockedPackage, bool) {
+	for _, pkg := range l.Packages {
+		locked := pkg.App()
+		if locked.PackageID == app.PackageID && locked.Version == app.Version && locked.VersionCode == app.VersionCode {
+			return pkg, true
+		}
+	}
+	return LockedPackage{}, false
+}
+
+// LoadLockfile reads a lockfile
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+	if lock.LockfileVersion != lockfileVersion {
+		return nil, fmt.Errorf("unsupported lockfile version %d in %s", lock.LockfileVersion, path)
+	}
+	for i := range lock.Packages {
+		pkg := &lock.Packages[i]
+		if pkg.PackageID == "" || pkg.VersionCode == "" || pkg.SHA256 == "" {
+			return nil, fmt.Errorf("invalid lockfile %s: incomplete entry for %q", path, pkg.PackageID)
+		}
+		if err := ValidatePackageID(pkg.PackageID); err != nil {
+			return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+		}
+		if !IsVersionCode(pkg.VersionCode) || pkg.RequestedVersionCode != "" && !IsVersionCode(pkg.RequestedVersionCode) {
+			return nil, fmt.Errorf("invalid lockfile %s: invalid versionCode for %q", path, pkg.PackageID)
+		}
+		// Hashes are compared as lowercase hex; accept hand-edited ones
+		// in either case
+		pkg.SHA256 = strings.ToLower(pkg.SHA256)
+		if !isHexDigest(pkg.SHA256, sha256.Size) {
+			return nil, fmt.Errorf("invalid lockfile %s: invalid sha256 for %q", path, pkg.PackageID)
+		}
+	}
+	return &lock, nil
+}
+
+// Save writes the lockfile to path, replacing it atomically
+func (l *Lockfile) Save(path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return writeJSON(w, l)
+	})
+}
+
+// Lock resolves apps to their current builds and pins them in a new
+// lockfile. Builds whose SHA-256 the version API does not report are
+// downloaded to a temporary directory to hash them.
+func (c *Client) Lock(apps []AppInfo) (*Lockfile, error) {
+	return c.LockContext(context.Background(), apps)
+}
+
+// LockContext is like Lock but stops once ctx is done. On error the
+// lockfile holds the apps that did resolve, and the error joins one
+// error per failed app.
+func (c *Client) LockContext(ctx context.Context, apps []AppInfo) (*Lockfile, error) {
+	packages := make([]LockedPackage, len(apps))
+	errs := make([]error, len(apps))
+	sem := make(chan struct{}, c.options.Parallel)
+	var wg sync.WaitGroup
+
+	for i, app := range apps {
+		wg.Add(1)
+		go func(idx int, app AppInfo) {
+			defer wg.Done()
+
+			if err := acquire(ctx, sem); err != nil {
+				errs[idx] = fmt.Errorf("%s: %w", appString(app), err)
+				return
+			}
+			defer func() { <-sem }()
+
+			packages[idx], errs[idx] = c.lockApp(ctx, app)
+			if errs[idx] != nil {
+				errs[idx] = fmt.Errorf("%s: %w", appString(app), errs[idx])
+			}
+		}(i, app)
+	}
+	wg.Wait()
+
+	lock := &Lockfile{LockfileVersion: lockfileVersion, Packages: []LockedPackage{}}
+	for i, pkg := range packages {
+		if errs[i] == nil {
+			lock.Packages = append(lock.Packages, pkg)
+		}
+	}
+	return lock, errors.Join(errs...)
+}
+
+// lockApp resolves one app to the build it pins
+func (c *Client) lockApp(ctx context.Context, app AppInfo) (LockedPackage, error) {
+	versions, err := c.fetchVersions(ctx, app.PackageID)
+	if err != nil {
+		return LockedPackage{}, fmt.Errorf("failed to fetch versions: %w", err)
+	}
+	version, err := resolveVersion(app, versions)
+	if err != nil {
+		return LockedPackage{}, err
+	}
+
+	sha256, size := strings.ToLower(version.SHA256), version.Size
+	if sha256 == "" || (app.Checksum.Value != "" && app.Checksum.Algorithm != ChecksumSHA256) {
+		stats, err := c.hashVersion(ctx, app, *version)
+		if err != nil {
+			return LockedPackage{}, err
+		}
+		sha256, size = stats.sha256, stats.size
+	} else if app.Checksum.Value != "" && app.Checksum.Value != sha256 {
+		return LockedPackage{}, &VerificationError{
+			Path:     version.DownloadURL,
+			Check:    ChecksumSHA256,
+			Expected: app.Checksum.Value,
+			Actual:   sha256,
+		}
+	}
+
+	return LockedPackage{
+		PackageID:            app.PackageID,
+		Constraint:           app.Version,
+		RequestedVersionCode: app.VersionCode,
+		VersionName:          version.VersionName,
+		VersionCode:          version.VersionCode,
+		APKType:              version.APKType,
+		SHA256:               sha256,
+		Size:                 size,
+	}, nil
+}
+
+// hashVersion downloads a build to a temporary directory and returns its
+// digests, checked against any the user or the API gave
+func (c *Client) hashVersion(ctx context.Context, app AppInfo, version VersionInfo) (fileStats, error) {
+	dir, err := os.MkdirTemp("", "apkpure-lock-*")
+	if err != nil {
+		return fileStats{}, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	filename := versionFilename(app.PackageID, version) + versionExt(version)
+	return c.downloadWithRetry(ctx, version, dir, filename, expectedChecksums(app, version))
+}
+
+// FetchLocked downloads exactly the builds pinned in lock into outPath.
+// Files already in outPath that match their pinned SHA-256 are skipped. A
+// build that is no longer offered, or whose type or SHA-256 changed, fails
+// with a *LockDriftError.
+func (c *Client) FetchLocked(lock *Lockfile, outPath string) []DownloadResult {
+	return c.FetchLockedContext(context.Background(), lock, outPath)
+}
+
+// FetchLockedContext is like FetchLocked but stops queued and in-flight
+// downloads once ctx is done
+func (c *Client) FetchLockedContext(ctx context.Context, lock *Lockfile, outPath string) []DownloadResult {
+	results := make([]DownloadResult, len(lock.Packages))
+	sem := make(chan struct{}, c.options.Parallel)
+	var wg sync.WaitGroup
+
+	for i, pkg := range lock.Packages {
+		wg.Add(1)
+		go func(idx int, pkg LockedPackage) {
+			defer wg.Done()
+
+			app := pkg.pinnedApp()
+			results[idx] = c.acquireAndRun(ctx, sem, app, func() DownloadResult {
+				return c.fetchLockedWithResult(ctx, pkg, outPath)
+			})
+		}(i, pkg)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// fetchLockedWithResult downloads one pinned build
+func (c *Client) fetchLockedWithResult(ctx context.Context, pkg LockedPackage, outPath string) DownloadResult {
+	start := time.Now()
+	app := pkg.pinnedApp()
+	result := DownloadResult{
+		AppInfo:  app,
+		Filename: appString(app),
+	}
+
+	err := c.fetchLocked(ctx, pkg, outPath, &result)
+	result.Success = err == nil
+	result.Error = err
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+// fetchLocked checks that the pinned build is still offered unchanged
+// and downloads it, requiring the pinned SHA-256
+func (c *Client) fetchLocked(ctx context.Context, pkg LockedPackage, outPath string, result *DownloadResult) error {
+	if skipped, err := c.skipLocked(pkg, outPath, result); skipped || err != nil {
+		return err
+	}
+	c.logf("Downloading %s...\n", appString(result.AppInfo))
+
+	versions, err := c.fetchVersions(ctx, pkg.PackageID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch versions: %w", err)
+	}
+
+	idx := slices.IndexFunc(versions, func(v VersionInfo) bool { return v.VersionCode == pkg.VersionCode })
+	if idx < 0 {
+		return &LockDriftError{
+			PackageID:   pkg.PackageID,
+			VersionCode: pkg.VersionCode,
+			Field:       "version_code",
+			Locked:      pkg.VersionCode,
+			Err:         &VersionNotFoundError{PackageID: pkg.PackageID, VersionCode: pkg.VersionCode},
+		}
+	}
+	version := versions[idx]
+
+	drift := func(field, locked, current string) error {
+		return &LockDriftError{PackageID: pkg.PackageID, VersionCode: pkg.VersionCode, Field: field, Locked: locked, Current: current}
+	}
+	if version.APKType != pkg.APKType {
+		return drift("apk_type", pkg.APKType, version.APKType)
+	}
+	if version.SHA256 != "" && !strings.EqualFold(version.SHA256, pkg.SHA256) {
+		return drift("sha256", pkg.SHA256, strings.ToLower(version.SHA256))
+	}
+
+	result.Filename = versionFilename(pkg.PackageID, version)
+	err = c.downloadVersion(ctx, result.AppInfo, version, outPath, result)
+
+	var verifyErr *VerificationError
+	if errors.As(err, &verifyErr) && verifyErr.Check == ChecksumSHA256 && verifyErr.Expected == pkg.SHA256 {
+		return &LockDriftError{
+			PackageID:   pkg.PackageID,
+			VersionCode: pkg.VersionCode,
+			Field:       "sha256",
+			Locked:      pkg.SHA256,
+			Current:     verifyErr.Actual,
+			Err:         verifyErr,
+		}
+	}
+	return err
+}
+
+// skipLocked reports whether the pinned build is already in outPath. A
+// file of that name with another SHA-256 is a *FileExistsError.
+func (c *Client) skipLocked(pkg LockedPackage, outPath string, result *DownloadResult) (bool, error) {
+	version := VersionInfo{VersionName: pkg.VersionName, VersionCode: pkg.VersionCode, APKType: pkg.APKType}
+	filename := versionFilename(pkg.PackageID, version)
+	path := filepath.Join(outPath, filename+versionExt(version))
+
+	stats, err := hashFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !strings.EqualFold(stats.sha256, pkg.SHA256) {
+		return false, &FileExistsError{Path: path}
+	}
+
+	c.logf("Skipping %s, already downloaded\n", filename)
+	result.Filename = filename
+	result.Version = version
+	result.Path = path
+	result.Size = stats.size
+	result.SHA256 = stats.sha256
+	result.Skipped = true
+	return true, nil
+}
+
+// pinnedApp returns the exact build the entry pins, with its SHA-256 as
+// the required checksum
+func (p LockedPackage) pinnedApp() AppInfo {
+	return AppInfo{
+		PackageID:   p.PackageID,
+		VersionCode: p.VersionCode,
+		Checksum:    Checksum{Algorithm: ChecksumSHA256, Value: p.SHA256},
+	}
+}
@@ -0,0 +1,164 @@
+package apkpure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchLocked(t *testing.T) {
+	content := testPayload(1000, 1)
+	sum := sha256.Sum256(content)
+	pinned := LockedPackage{
+		PackageID:   "com.example",
+		VersionName: "1.0",
+		VersionCode: "10",
+		APKType:     "APK",
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	const filename = "com.example@1.0_10.apk"
+
+	tests := []struct {
+		name string
+		// builds are offered by the API
+		builds []fakeBuild
+		// existing is already in the output directory, if not nil
+		existing []byte
+		// downloads is the number of download requests expected
+		downloads   int
+		wantSkipped bool
+		wantErr     error
+	}{
+		{
+			name:      "download",
+			builds:    []fakeBuild{{name: "1.0", code: "10", content: content}},
+			downloads: 1,
+		},
+		{
+			name:        "existing file matches",
+			builds:      []fakeBuild{{name: "1.0", code: "10", content: content}},
+			existing:    content,
+			wantSkipped: true,
+		},
+		{
+			name:     "existing file differs",
+			builds:   []fakeBuild{{name: "1.0", code: "10", content: content}},
+			existing: testPayload(1000, 2),
+			wantErr:  ErrFileExists,
+		},
+		{
+			name:    "build gone",
+			builds:  []fakeBuild{{name: "1.1", code: "11", content: content}},
+			wantErr: ErrLockDrift,
+		},
+		{
+			name:      "build changed",
+			builds:    []fakeBuild{{name: "1.0", code: "10", content: testPayload(1000, 3)}},
+			downloads: 1,
+			wantErr:   ErrLockDrift,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, map[string][]fakeBuild{"com.example": tt.builds})
+			client := newTestClient(api, DownloadOptions{})
+			dir := t.TempDir()
+			if tt.existing != nil {
+				if err := os.WriteFile(filepath.Join(dir, filename), tt.existing, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lock := &Lockfile{LockfileVersion: lockfileVersion, Packages: []LockedPackage{pinned}}
+			result := client.FetchLocked(lock, dir)[0]
+			if tt.wantErr != nil {
+				if !errors.Is(result.Error, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", result.Error, tt.wantErr)
+				}
+			} else if result.Error != nil {
+				t.Fatalf("fetch failed: %v", result.Error)
+			}
+			if result.Skipped != tt.wantSkipped {
+				t.Errorf("skipped %v, want %v", result.Skipped, tt.wantSkipped)
+			}
+			if got := len(api.downloadRequests()); got != tt.downloads {
+				t.Errorf("got %d downloads, want %d", got, tt.downloads)
+			}
+		})
+	}
+}
+
+func TestLockfileFind(t *testing.T) {
+	lock := &Lockfile{Packages: []LockedPackage{
+		{PackageID: "com.example", VersionCode: "10"},
+		{PackageID: "com.example", Constraint: ">=2", VersionCode: "20"},
+		{PackageID: "com.other", RequestedVersionCode: "5", VersionCode: "5"},
+	}}
+
+	tests := []struct {
+		app  AppInfo
+		want string
+	}{
+		{app: AppInfo{PackageID: "com.example"}, want: "10"},
+		{app: AppInfo{PackageID: "com.example", Version: ">=2"}, want: "20"},
+		{app: AppInfo{PackageID: "com.example", Version: ">=3"}},
+		{app: AppInfo{PackageID: "com.other", VersionCode: "5"}, want: "5"},
+		{app: AppInfo{PackageID: "com.other"}},
+	}
+	for _, tt := range tests {
+		pkg, ok := lock.Find(tt.app)
+		if ok != (tt.want != "") || pkg.VersionCode != tt.want {
+			t.Errorf("Find(%s) = %s, %v; want %q", appString(tt.app), pkg.VersionCode, ok, tt.want)
+		}
+	}
+}
+
+func TestLoadLockfileRejectsInvalidPackages(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	valid := LockedPackage{PackageID: "com.example", VersionCode: "10", SHA256: digest}
+
+	tests := []struct {
+		name    string
+		edit    func(pkg *LockedPackage)
+		wantErr string
+	}{
+		{name: "valid", edit: func(*LockedPackage) {}},
+		{name: "uppercase hash", edit: func(pkg *LockedPackage) { pkg.SHA256 = strings.ToUpper(digest) }},
+		{name: "package path", edit: func(pkg *LockedPackage) { pkg.PackageID = "../../escape" }, wantErr: "invalid package name"},
+		{name: "missing hash", edit: func(pkg *LockedPackage) { pkg.SHA256 = "" }, wantErr: "incomplete entry"},
+		{name: "short hash", edit: func(pkg *LockedPackage) { pkg.SHA256 = digest[:63] }, wantErr: "invalid sha256"},
+		{name: "non-hex hash", edit: func(pkg *LockedPackage) { pkg.SHA256 = strings.Repeat("zz", 32) }, wantErr: "invalid sha256"},
+		{name: "versionCode path", edit: func(pkg *LockedPackage) { pkg.VersionCode = "1/../2" }, wantErr: "invalid versionCode"},
+		{name: "requested versionCode", edit: func(pkg *LockedPackage) { pkg.RequestedVersionCode = "x" }, wantErr: "invalid versionCode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg := valid
+			tt.edit(&pkg)
+			path := filepath.Join(t.TempDir(), LockfileName)
+			lock := &Lockfile{LockfileVersion: lockfileVersion, Packages: []LockedPackage{pkg}}
+			if err := lock.Save(path); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadLockfile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLockfile: %v", err)
+			}
+			if got := loaded.Packages[0].SHA256; got != digest {
+				t.Errorf("loaded hash %s, want %s", got, digest)
+			}
+		})
+	}
+}
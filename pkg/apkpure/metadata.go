@@ -0,0 +1,344 @@
+package apkpure
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion is one entry of the version_list in an APIResponse. Fields
+// the API omits are left at their zero value.
+type APIVersion struct {
+	VersionName string
+	VersionCode string
+	Title       string
+	UpdateDate  string
+	ReleaseDate string
+	MinSDK      int
+	TargetSDK   int
+	ABIs        []string
+	Signatures  []string
+	Changelog   string
+	Asset       APIAsset
+	// Extra holds the fields not decoded above, keyed by their JSON name
+	Extra map[string]json.RawMessage
+}
+
+// APIAsset describes the downloadable file of an APIVersion
+type APIAsset struct {
+	URL    string
+	Type   string
+	Size   int64
+	SHA1   string
+	SHA256 string
+	MD5    string
+	// Extra holds the fields not decoded above, keyed by their JSON name
+	Extra map[string]json.RawMessage
+}
+
+// UnmarshalJSON decodes a version entry, tolerating numbers sent as
+// strings (and vice versa) and keeping unknown fields in Extra
+func (v *APIVersion) UnmarshalJSON(data []byte) error {
+	var fields rawFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*v = APIVersion{
+		VersionName: fields.str("version_name"),
+		VersionCode: fields.str("version_code"),
+		Title:       fields.str("title"),
+		UpdateDate:  fields.str("update_date"),
+		ReleaseDate: fields.str("release_date"),
+		MinSDK:      int(fields.int("min_sdk_version")),
+		TargetSDK:   int(fields.int("target_sdk_version")),
+		ABIs:        fields.strings("native_code"),
+		Signatures:  fields.strings("signatures"),
+		Changelog:   fields.str("whatsnew"),
+	}
+
+	if raw, ok := fields["asset"]; ok {
+		if err := json.Unmarshal(raw, &v.Asset); err != nil {
+			return err
+		}
+		delete(fields, "asset")
+	}
+
+	if len(fields) > 0 {
+		v.Extra = fields
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes an asset, keeping unknown fields in Extra.
+// Digests end up in store paths, so malformed ones are left in Extra
+// rather than decoded.
+func (a *APIAsset) UnmarshalJSON(data []byte) error {
+	var fields rawFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*a = APIAsset{
+		URL:    fields.str("url"),
+		Type:   fields.str("type"),
+		Size:   fields.int("size"),
+		SHA1:   fields.digest("sha1", sha1.Size),
+		SHA256: fields.digest("sha256", sha256.Size),
+		MD5:    fields.digest("md5", md5.Size),
+	}
+
+	if len(fields) > 0 {
+		a.Extra = fields
+	}
+	return nil
+}
+
+// versionInfo converts an API entry into the public VersionInfo
+func (v *APIVersion) versionInfo() VersionInfo {
+	info := VersionInfo{
+		VersionName: v.VersionName,
+		VersionCode: v.VersionCode,
+		APKType:     v.Asset.Type,
+		DownloadURL: v.Asset.URL,
+		Title:       v.Title,
+		Size:        v.Asset.Size,
+		SHA1:        v.Asset.SHA1,
+		SHA256:      v.Asset.SHA256,
+		MD5:         v.Asset.MD5,
+		MinSDK:      v.MinSDK,
+		TargetSDK:   v.TargetSDK,
+		ABIs:        v.ABIs,
+		Signatures:  v.Signatures,
+		Changelog:   v.Changelog,
+	}
+
+	extra := make(map[string]json.RawMessage)
+	for k, raw := range v.Extra {
+		extra[k] = raw
+	}
+	for k, raw := range v.Asset.Extra {
+		extra["asset."+k] = raw
+	}
+
+	// Keep dates we can't interpret rather than dropping them
+	var ok bool
+	if info.UpdatedAt, ok = parseAPIDate(v.UpdateDate); !ok && v.UpdateDate != "" {
+		extra["update_date"], _ = json.Marshal(v.UpdateDate)
+	}
+	if info.ReleasedAt, ok = parseAPIDate(v.ReleaseDate); !ok && v.ReleaseDate != "" {
+		extra["release_date"], _ = json.Marshal(v.ReleaseDate)
+	}
+
+	if len(extra) > 0 {
+		info.Extra = extra
+	}
+	return info
+}
+
+// apiDateLayouts are the date formats seen in API responses
+var apiDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseAPIDate parses a date given in one of apiDateLayouts or as Unix
+// seconds
+func parseAPIDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	for _, layout := range apiDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+		return time.Unix(seconds, 0).UTC(), true
+	}
+
+	return time.Time{}, false
+}
+
+// rawFields is a decoded JSON object whose accessors consume the keys
+// they read, so whatever remains is unknown
+type rawFields map[string]json.RawMessage
+
+// str returns the first of keys present as a string or number
+func (f rawFields) str(keys ...string) string {
+	for _, key := range keys {
+		raw, ok := f[key]
+		if !ok {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			delete(f, key)
+			return s
+		}
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err == nil {
+			delete(f, key)
+			return n.String()
+		}
+		if string(raw) == "null" {
+			delete(f, key)
+		}
+	}
+	return ""
+}
+
+// int returns the first of keys present as a number or numeric string
+func (f rawFields) int(keys ...string) int64 {
+	for _, key := range keys {
+		raw, ok := f[key]
+		if !ok {
+			continue
+		}
+
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				continue
+			}
+			n = json.Number(strings.TrimSpace(s))
+		}
+		if i, err := n.Int64(); err == nil {
+			delete(f, key)
+			return i
+		}
+	}
+	return 0
+}
+
+// strings returns the first of keys present as a string array or a
+// comma-separated string
+func (f rawFields) strings(keys ...string) []string {
+	for _, key := range keys {
+		raw, ok := f[key]
+		if !ok {
+			continue
+		}
+
+		var list []string
+		if err := json.Unmarshal(raw, &list); err == nil {
+			delete(f, key)
+			return list
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			delete(f, key)
+			if s == "" {
+				return nil
+			}
+			for _, part := range strings.Split(s, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					list = append(list, part)
+				}
+			}
+			return list
+		}
+	}
+	return nil
+}
+
+// digest returns key as a lowercase hex digest of size bytes. A value
+// that is not one is left in f.
+func (f rawFields) digest(key string, size int) string {
+	var s string
+	if err := json.Unmarshal(f[key], &s); err != nil {
+		return ""
+	}
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s != "" && !isHexDigest(s, size) {
+		return ""
+	}
+	delete(f, key)
+	return s
+}
+
+// float returns the first of keys present as a number or numeric string
+func (f rawFields) float(keys ...string) float64 {
+	for _, key := range keys {
+		raw, ok := f[key]
+		if !ok {
+			continue
+		}
+
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				continue
+			}
+			n = json.Number(strings.TrimSpace(s))
+		}
+		if x, err := n.Float64(); err == nil {
+			delete(f, key)
+			return x
+		}
+	}
+	return 0
+}
+
+// nestedStr is like str but also accepts an object holding the value
+// under field, as in {"icon": {"url": "..."}}
+func (f rawFields) nestedStr(field string, keys ...string) string {
+	for _, key := range keys {
+		if s := f.str(key); s != "" {
+			return s
+		}
+
+		var inner rawFields
+		if err := json.Unmarshal(f[key], &inner); err != nil || inner == nil {
+			continue
+		}
+		if s := inner.str(field); s != "" {
+			delete(f, key)
+			return s
+		}
+	}
+	return ""
+}
+
+// bool returns the first of keys present as a boolean, or as a number or
+// string holding one, and whether any was found
+func (f rawFields) bool(keys ...string) (value, ok bool) {
+	for _, key := range keys {
+		raw, present := f[key]
+		if !present {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			s = string(raw)
+		}
+		if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			delete(f, key)
+			return b, true
+		}
+	}
+	return false, false
+}
+
+// unwrap returns the object under the first of keys present, or f itself
+// when none holds an object
+func (f rawFields) unwrap(keys ...string) rawFields {
+	for _, key := range keys {
+		var inner rawFields
+		if err := json.Unmarshal(f[key], &inner); err == nil && inner != nil {
+			return inner
+		}
+	}
+	return f
+}
@@ -0,0 +1,120 @@
+package apkpure
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// versionListResponse is a get_app_his_version response body as tapi
+// sends it: numbers may arrive as strings and vice versa
+const versionListResponse = `{
+  "version_list": [
+    {
+      "version_name": "1.5.0",
+      "version_code": 150,
+      "title": "Example App",
+      "update_date": "2024-05-01 10:00:00",
+      "release_date": "1714521600",
+      "min_sdk_version": "24",
+      "target_sdk_version": 34,
+      "native_code": ["arm64-v8a", "armeabi-v7a"],
+      "signatures": ["b4d9a2c7"],
+      "whatsnew": "Bug fixes",
+      "package_name": "com.example.app",
+      "asset": {
+        "url": "https://download.pureapk.com/b/XAPK/com.example.app?version=150",
+        "type": "XAPK",
+        "size": "2048",
+        "sha1": "A9993E364706816ABA3E25717850C26C9CD0D89D",
+        "sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+        "md5": "not-a-digest",
+        "obb": true
+      }
+    },
+    {
+      "version_name": "1.4.0",
+      "version_code": "140",
+      "update_date": "last week",
+      "asset": {"url": "https://download.pureapk.com/b/APK/com.example.app?version=140", "type": "APK"}
+    },
+    {
+      "version_name": "1.3.0",
+      "version_code": "130",
+      "asset": {"type": "APK"}
+    }
+  ]
+}`
+
+func TestParseVersionResponse(t *testing.T) {
+	client := NewClient(DownloadOptions{})
+	versions, err := client.parseVersionResponse([]byte(versionListResponse))
+	if err != nil {
+		t.Fatalf("parseVersionResponse: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions, want the 2 with a download URL", len(versions))
+	}
+
+	got := versions[0]
+	extra := got.Extra
+	got.Extra = nil
+	want := VersionInfo{
+		VersionName: "1.5.0",
+		VersionCode: "150",
+		APKType:     "XAPK",
+		DownloadURL: "https://download.pureapk.com/b/XAPK/com.example.app?version=150",
+		Title:       "Example App",
+		Size:        2048,
+		SHA1:        "a9993e364706816aba3e25717850c26c9cd0d89d",
+		SHA256:      "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		UpdatedAt:   time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		ReleasedAt:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		MinSDK:      24,
+		TargetSDK:   34,
+		ABIs:        []string{"arm64-v8a", "armeabi-v7a"},
+		Signatures:  []string{"b4d9a2c7"},
+		Changelog:   "Bug fixes",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// Unmapped fields and the malformed digest are kept as sent
+	wantExtra := map[string]string{
+		"package_name": `"com.example.app"`,
+		"asset.md5":    `"not-a-digest"`,
+		"asset.obb":    `true`,
+	}
+	if keys := slices.Sorted(maps.Keys(extra)); !slices.Equal(keys, slices.Sorted(maps.Keys(wantExtra))) {
+		t.Errorf("extra keys %v, want %v", keys, slices.Sorted(maps.Keys(wantExtra)))
+	}
+	for key, raw := range wantExtra {
+		if string(extra[key]) != raw {
+			t.Errorf("extra[%s] = %s, want %s", key, extra[key], raw)
+		}
+	}
+
+	// A date that can't be parsed is kept rather than dropped
+	if older := versions[1]; !older.UpdatedAt.IsZero() || string(older.Extra["update_date"]) != `"last week"` {
+		t.Errorf("unparsed date: got %v and extra %s", older.UpdatedAt, older.Extra["update_date"])
+	}
+}
+
+func TestAPIVersionUnmappedAliases(t *testing.T) {
+	// Keys the API doesn't send are not guessed at
+	var v APIVersion
+	data := `{"version_name": "1.0", "app_name": "Example", "min_sdk": 21, "changelog": "x"}`
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Title != "" || v.MinSDK != 0 || v.Changelog != "" {
+		t.Errorf("aliases were mapped: %+v", v)
+	}
+	if keys := slices.Sorted(maps.Keys(v.Extra)); !slices.Equal(keys, []string{"app_name", "changelog", "min_sdk"}) {
+		t.Errorf("extra keys %v, want the aliases kept", keys)
+	}
+}
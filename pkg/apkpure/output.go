@@ -0,0 +1,720 @@
+package apkpure
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Supported values for DownloadOptions.OutputFormat
+const (
+	OutputPlaintext = "plaintext"
+	OutputJSON      = "json"
+)
+
+// DownloadSummary aggregates the results of a batch download
+type DownloadSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Skipped counts successes that were already on disk
+	Skipped int `json:"skipped"`
+}
+
+// Summarize counts successes and failures in results
+func Summarize(results []DownloadResult) DownloadSummary {
+	summary := DownloadSummary{Total: len(results)}
+	for _, result := range results {
+		if result.Success {
+			summary.Succeeded++
+			if result.Skipped {
+				summary.Skipped++
+			}
+		} else {
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// versionListingJSON is the JSON form of a VersionListing
+type versionListingJSON struct {
+	PackageID string        `json:"package_id"`
+	Versions  []VersionInfo `json:"versions"`
+	Error     string        `json:"error,omitempty"`
+	ErrorKind string        `json:"error_kind,omitempty"`
+}
+
+// downloadResultJSON is the JSON form of a DownloadResult
+type downloadResultJSON struct {
+	PackageID   string         `json:"package_id"`
+	Requested   string         `json:"requested_version,omitempty"`
+	RequestedVC string         `json:"requested_version_code,omitempty"`
+	VersionName string         `json:"version_name,omitempty"`
+	VersionCode string         `json:"version_code,omitempty"`
+	APKType     string         `json:"apk_type,omitempty"`
+	Filename    string         `json:"filename"`
+	Path        string         `json:"path,omitempty"`
+	Size        int64          `json:"size"`
+	SHA256      string         `json:"sha256,omitempty"`
+	SHA1        string         `json:"sha1,omitempty"`
+	MD5         string         `json:"md5,omitempty"`
+	Verified    []string       `json:"checksums_verified,omitempty"`
+	Signature   *SignatureInfo `json:"signature,omitempty"`
+	Manifest    *ManifestInfo  `json:"manifest,omitempty"`
+	XAPK        *XAPKInfo      `json:"xapk,omitempty"`
+	DurationMS  int64          `json:"duration_ms"`
+	Success     bool           `json:"success"`
+	Skipped     bool           `json:"skipped,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	ErrorKind   string         `json:"error_kind,omitempty"`
+}
+
+// downloadReportJSON is the top-level JSON document for download results
+type downloadReportJSON struct {
+	Results []downloadResultJSON `json:"results"`
+	Summary DownloadSummary      `json:"summary"`
+}
+
+// syncChangeJSON is the JSON form of a SyncChange
+type syncChangeJSON struct {
+	PackageID string               `json:"package_id"`
+	Added     []SyncedBuild        `json:"added"`
+	Removed   []SyncedBuild        `json:"removed"`
+	Failed    []downloadResultJSON `json:"failed"`
+	Error     string               `json:"error,omitempty"`
+	ErrorKind string               `json:"error_kind,omitempty"`
+}
+
+// SyncSummary aggregates a sync report
+type SyncSummary struct {
+	Apps    int `json:"apps"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Failed  int `json:"failed"`
+}
+
+// syncReportJSON is the top-level JSON document for a sync
+type syncReportJSON struct {
+	Apps    []syncChangeJSON `json:"apps"`
+	Summary SyncSummary      `json:"summary"`
+}
+
+// Summary counts the changes in a sync report. Apps that could not be
+// listed count as failed.
+func (r *SyncReport) Summary() SyncSummary {
+	summary := SyncSummary{Apps: len(r.Apps)}
+	for _, change := range r.Apps {
+		summary.Added += len(change.Added)
+		summary.Removed += len(change.Removed)
+		summary.Failed += len(change.Failed)
+		if change.Error != nil {
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// WriteVersions renders version listings to w in the given output format
+func WriteVersions(w io.Writer, listings []VersionListing, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		return writeVersionsPlaintext(w, listings)
+	case OutputJSON:
+		return writeVersionsJSON(w, listings)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteResults renders download results and their summary to w in the
+// given output format
+func WriteResults(w io.Writer, results []DownloadResult, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		return writeResultsPlaintext(w, results)
+	case OutputJSON:
+		return writeResultsJSON(w, results)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteResultsTemplate writes one line per result by executing tmpl on
+// the result's JSON fields, e.g. "{{.package_id}} {{.sha256}}"
+func WriteResultsTemplate(w io.Writer, results []DownloadResult, tmpl *template.Template) error {
+	for _, result := range results {
+		data, err := json.Marshal(newDownloadResultJSON(result))
+		if err != nil {
+			return err
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+
+		var line bytes.Buffer
+		if err := tmpl.Execute(&line, fields); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+			line.WriteByte('\n')
+		}
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteXAPKInfo renders an unpacked XAPK summary to w in the given output
+// format
+func WriteXAPKInfo(w io.Writer, info *XAPKInfo, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		if _, err := fmt.Fprintf(w, "%s (version code %s) unpacked to %s\n", info.PackageName, info.VersionCode, info.Dir); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "| base: %s\n", info.BaseAPK); err != nil {
+			return err
+		}
+		for _, split := range info.Splits {
+			if _, err := fmt.Fprintf(w, "| split %s: %s\n", split.Name, split.Path); err != nil {
+				return err
+			}
+		}
+		for _, obb := range info.OBBFiles {
+			if _, err := fmt.Fprintf(w, "| obb: %s\n", obb); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputJSON:
+		return writeJSON(w, info)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteSyncReport renders a sync change report to w in the given output
+// format
+func WriteSyncReport(w io.Writer, report *SyncReport, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		return writeSyncReportPlaintext(w, report)
+	case OutputJSON:
+		return writeSyncReportJSON(w, report)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteRepoIndexSummary renders the result of WriteFDroidIndex to w in
+// the given output format
+func WriteRepoIndexSummary(w io.Writer, summary *RepoIndexSummary, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		lines := []string{
+			fmt.Sprintf("Indexed %d APKs of %d packages in %s", summary.APKs, summary.Packages, summary.Dir),
+			"| wrote: " + strings.Join(summary.Files, ", "),
+		}
+		for _, name := range slices.Sorted(maps.Keys(summary.Skipped)) {
+			lines = append(lines, fmt.Sprintf("| skipped %s: %s", name, summary.Skipped[name]))
+		}
+		if summary.Fingerprint != "" {
+			lines = append(lines, "| fingerprint: "+summary.Fingerprint)
+		}
+		_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+		return err
+	case OutputJSON:
+		return writeJSON(w, summary)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteLockfile writes the builds pinned in lock to w in the given format
+func WriteLockfile(w io.Writer, lock *Lockfile, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		for _, pkg := range lock.Packages {
+			if _, err := fmt.Fprintf(w, "%s@%s (%s) %s sha256=%s\n",
+				pkg.PackageID, pkg.VersionName, pkg.VersionCode, pkg.APKType, pkg.SHA256); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputJSON:
+		return writeJSON(w, lock)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteStoreEntries writes the index of a store to w in the given format
+func WriteStoreEntries(w io.Writer, entries []StoreEntry, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		for _, entry := range entries {
+			if _, err := fmt.Fprintf(w, "%s@%s (%s)  %s  %d bytes\n",
+				entry.PackageID, entry.VersionName, entry.VersionCode, entry.SHA256, entry.Size); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputJSON:
+		if entries == nil {
+			entries = []StoreEntry{}
+		}
+		return writeJSON(w, entries)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteStoreGCReport writes the outcome of a store GC to w in the given
+// format
+func WriteStoreGCReport(w io.Writer, report *StoreGCReport, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		lines := []string{
+			fmt.Sprintf("Removed %d blobs (%d bytes), kept %d", len(report.Removed), report.Freed, report.Kept),
+		}
+		for _, digest := range report.Removed {
+			lines = append(lines, "- "+digest)
+		}
+		for _, build := range report.Dangling {
+			lines = append(lines, "! "+build+": blob missing, dropped from index")
+		}
+		_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+		return err
+	case OutputJSON:
+		return writeJSON(w, report)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// FileReport is the outcome of inspecting or verifying one local file
+type FileReport struct {
+	Path  string
+	Info  *FileInfo
+	Error error
+}
+
+// fileReportJSON is the JSON form of a FileReport
+type fileReportJSON struct {
+	*FileInfo
+	Path      string `json:"path"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+// WriteFileReports writes inspected or verified files to w in the given
+// format
+func WriteFileReports(w io.Writer, reports []FileReport, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		for _, report := range reports {
+			if err := writeFileReportPlaintext(w, report); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputJSON:
+		out := make([]fileReportJSON, 0, len(reports))
+		for _, report := range reports {
+			entry := fileReportJSON{FileInfo: report.Info, Path: report.Path, OK: report.Error == nil}
+			if report.Error != nil {
+				entry.Error = report.Error.Error()
+				entry.ErrorKind = ErrorKind(report.Error)
+			}
+			out = append(out, entry)
+		}
+		return writeJSON(w, out)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// writeFileReportPlaintext renders one file as an indented block
+func writeFileReportPlaintext(w io.Writer, report FileReport) error {
+	lines := []string{report.Path}
+	if info := report.Info; info != nil {
+		lines = append(lines,
+			fmt.Sprintf("| %s, %d bytes", info.Type, info.Size),
+			"| sha256: "+info.SHA256)
+		if m := info.Manifest; m != nil {
+			lines = append(lines,
+				fmt.Sprintf("| package: %s %s (%d)", m.PackageName, m.VersionName, m.VersionCode),
+				fmt.Sprintf("| sdk: min %d, target %d", m.MinSDK, m.TargetSDK))
+			if m.Label != "" {
+				lines = append(lines, "| label: "+m.Label)
+			}
+			if len(m.Permissions) > 0 {
+				lines = append(lines, "| permissions: "+strings.Join(m.Permissions, ", "))
+			}
+		} else if info.ManifestError != "" {
+			lines = append(lines, "| manifest: "+info.ManifestError)
+		}
+		if sig := info.Signature; sig != nil {
+			schemes := make([]string, len(sig.Schemes))
+			for i, scheme := range sig.Schemes {
+				schemes[i] = fmt.Sprintf("v%d", scheme)
+			}
+			lines = append(lines,
+				"| signature: "+strings.Join(schemes, ", "),
+				"| signer sha256: "+strings.Join(sig.SignerCertSHA256, ", "))
+		} else if info.SignatureError != "" {
+			lines = append(lines, "| signature: "+info.SignatureError)
+		}
+	}
+	if report.Error != nil {
+		lines = append(lines, fmt.Sprintf("| Error: %v", report.Error))
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// WriteVersionDetails writes one version of a package to w in the given
+// format
+func WriteVersionDetails(w io.Writer, packageID string, version VersionInfo, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		rows := [][2]string{
+			{"Package", packageID},
+			{"Version", fmt.Sprintf("%s (%s)", version.VersionName, version.VersionCode)},
+			{"Type", version.APKType},
+		}
+		optional := [][2]string{
+			{"Title", version.Title},
+			{"Size", formatSize(version.Size)},
+			{"Released", formatDate(version.releaseDate())},
+			{"Min SDK", formatInt(version.MinSDK)},
+			{"Target SDK", formatInt(version.TargetSDK)},
+			{"ABIs", strings.Join(version.ABIs, ", ")},
+			{"SHA-256", version.SHA256},
+			{"Download", version.DownloadURL},
+		}
+		for _, row := range optional {
+			if row[1] != "" {
+				rows = append(rows, row)
+			}
+		}
+		return writeTable(w, rows)
+	case OutputJSON:
+		return writeJSON(w, struct {
+			PackageID string      `json:"package_id"`
+			Version   VersionInfo `json:"version"`
+		}{packageID, version})
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteAppDetails writes the store listing of a package to w in the given
+// format. The plaintext form is a table followed by the description.
+func WriteAppDetails(w io.Writer, details *AppDetails, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		rows := [][2]string{
+			{"Package", details.PackageID},
+			{"Title", details.Title},
+		}
+		latest := details.LatestVersion
+		if details.LatestVersionCode != "" {
+			latest += " (" + details.LatestVersionCode + ")"
+		}
+		rating := ""
+		if details.Rating > 0 {
+			rating = strconv.FormatFloat(details.Rating, 'f', -1, 64)
+			if details.RatingCount > 0 {
+				rating += fmt.Sprintf(" (%d ratings)", details.RatingCount)
+			}
+		}
+		optional := [][2]string{
+			{"Developer", details.Developer},
+			{"Category", details.Category},
+			{"Rating", rating},
+			{"Latest", latest},
+			{"Size", formatSize(details.Size)},
+			{"Updated", formatDate(details.UpdatedAt)},
+			{"Icon", details.IconURL},
+		}
+		for _, row := range optional {
+			if row[1] != "" {
+				rows = append(rows, row)
+			}
+		}
+		if err := writeTable(w, rows); err != nil {
+			return err
+		}
+		if details.Description != "" {
+			_, err := fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(details.Description))
+			return err
+		}
+		return nil
+	case OutputJSON:
+		return writeJSON(w, details)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// WriteSearchResults writes search results to w in the given format. The
+// plaintext form is CSV without a header, one app per line with the
+// package ID, latest version, title and developer, so it can be fed to
+// the CLI's -c option as is.
+func WriteSearchResults(w io.Writer, results *SearchResults, format string) error {
+	switch format {
+	case "", OutputPlaintext:
+		cw := csv.NewWriter(w)
+		for _, app := range results.Apps {
+			if err := cw.Write([]string{app.PackageID, app.LatestVersion, app.Title, app.Developer}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case OutputJSON:
+		return writeJSON(w, results)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// writeTable renders label/value rows with the values aligned
+func writeTable(w io.Writer, rows [][2]string) error {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row[0]))
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(w, "%-*s  %s\n", width+1, row[0]+":", row[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatSize renders a byte count, or "" when unknown
+func formatSize(size int64) string {
+	if size <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// formatDate renders a date, or "" when unknown
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateOnly)
+}
+
+// formatInt renders a positive number, or "" when unknown
+func formatInt(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// writeVersionsPlaintext renders listings in the human-readable format
+func writeVersionsPlaintext(w io.Writer, listings []VersionListing) error {
+	for _, listing := range listings {
+		if _, err := fmt.Fprintf(w, "Versions available for %s on APKPure:\n", listing.PackageID); err != nil {
+			return err
+		}
+
+		if listing.Error != nil {
+			if _, err := fmt.Fprintf(w, "| Error: %v\n", listing.Error); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(listing.Versions) == 0 {
+			continue
+		}
+
+		// Show versionCodes too, since several builds may share a name
+		versionNames := make([]string, 0, len(listing.Versions))
+		for _, v := range listing.Versions {
+			if v.VersionCode != "" {
+				versionNames = append(versionNames, fmt.Sprintf("%s (%s)", v.VersionName, v.VersionCode))
+			} else {
+				versionNames = append(versionNames, v.VersionName)
+			}
+		}
+		if _, err := fmt.Fprintf(w, "| %s\n", strings.Join(versionNames, ", ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeVersionsJSON renders listings as a JSON array
+func writeVersionsJSON(w io.Writer, listings []VersionListing) error {
+	out := make([]versionListingJSON, 0, len(listings))
+	for _, listing := range listings {
+		entry := versionListingJSON{
+			PackageID: listing.PackageID,
+			Versions:  listing.Versions,
+		}
+		if entry.Versions == nil {
+			entry.Versions = []VersionInfo{}
+		}
+		if listing.Error != nil {
+			entry.Error = listing.Error.Error()
+			entry.ErrorKind = ErrorKind(listing.Error)
+		}
+		out = append(out, entry)
+	}
+
+	return writeJSON(w, out)
+}
+
+// writeResultsPlaintext lists failed downloads followed by a summary line
+func writeResultsPlaintext(w io.Writer, results []DownloadResult) error {
+	for _, result := range results {
+		if result.Success {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "Failed to download %s: %v\n", result.Filename, result.Error); err != nil {
+			return err
+		}
+	}
+
+	summary := Summarize(results)
+	if summary.Skipped > 0 {
+		_, err := fmt.Fprintf(w, "\nDownload complete: %d/%d succeeded (%d already present)\n", summary.Succeeded, summary.Total, summary.Skipped)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nDownload complete: %d/%d succeeded\n", summary.Succeeded, summary.Total)
+	return err
+}
+
+// writeResultsJSON renders results and their summary as one JSON object
+func writeResultsJSON(w io.Writer, results []DownloadResult) error {
+	report := downloadReportJSON{
+		Results: make([]downloadResultJSON, 0, len(results)),
+		Summary: Summarize(results),
+	}
+
+	for _, result := range results {
+		report.Results = append(report.Results, newDownloadResultJSON(result))
+	}
+
+	return writeJSON(w, report)
+}
+
+// writeSyncReportPlaintext lists added (+), removed (-) and failed (!)
+// builds per app
+func writeSyncReportPlaintext(w io.Writer, report *SyncReport) error {
+	for _, change := range report.Apps {
+		var lines []string
+		if change.Error != nil {
+			lines = append(lines, fmt.Sprintf("| Error: %v", change.Error))
+		}
+		for _, build := range change.Added {
+			lines = append(lines, fmt.Sprintf("| + %s (%s)", build.VersionName, build.VersionCode))
+		}
+		for _, build := range change.Removed {
+			lines = append(lines, fmt.Sprintf("| - %s (%s)", build.VersionName, build.VersionCode))
+		}
+		for _, result := range change.Failed {
+			lines = append(lines, fmt.Sprintf("| ! %s (%s): %v", result.Version.VersionName, result.Version.VersionCode, result.Error))
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "| up to date")
+		}
+
+		if _, err := fmt.Fprintf(w, "%s:\n%s\n", change.PackageID, strings.Join(lines, "\n")); err != nil {
+			return err
+		}
+	}
+
+	summary := report.Summary()
+	_, err := fmt.Fprintf(w, "\nSync complete: %d apps, %d added, %d removed, %d failed\n",
+		summary.Apps, summary.Added, summary.Removed, summary.Failed)
+	return err
+}
+
+// writeSyncReportJSON renders a sync report as one JSON object
+func writeSyncReportJSON(w io.Writer, report *SyncReport) error {
+	doc := syncReportJSON{
+		Apps:    make([]syncChangeJSON, 0, len(report.Apps)),
+		Summary: report.Summary(),
+	}
+
+	for _, change := range report.Apps {
+		entry := syncChangeJSON{
+			PackageID: change.PackageID,
+			Added:     change.Added,
+			Removed:   change.Removed,
+			Failed:    make([]downloadResultJSON, 0, len(change.Failed)),
+		}
+		for _, result := range change.Failed {
+			entry.Failed = append(entry.Failed, newDownloadResultJSON(result))
+		}
+		if change.Error != nil {
+			entry.Error = change.Error.Error()
+			entry.ErrorKind = ErrorKind(change.Error)
+		}
+		doc.Apps = append(doc.Apps, entry)
+	}
+
+	return writeJSON(w, doc)
+}
+
+// newDownloadResultJSON converts a DownloadResult to its JSON form
+func newDownloadResultJSON(result DownloadResult) downloadResultJSON {
+	entry := downloadResultJSON{
+		PackageID:   result.AppInfo.PackageID,
+		Requested:   result.AppInfo.Version,
+		RequestedVC: result.AppInfo.VersionCode,
+		VersionName: result.Version.VersionName,
+		VersionCode: result.Version.VersionCode,
+		APKType:     result.Version.APKType,
+		Filename:    result.Filename,
+		Path:        result.Path,
+		Size:        result.Size,
+		SHA256:      result.SHA256,
+		SHA1:        result.SHA1,
+		MD5:         result.MD5,
+		Verified:    result.ChecksumsVerified,
+		Signature:   result.Signature,
+		Manifest:    result.Manifest,
+		XAPK:        result.XAPK,
+		DurationMS:  result.Duration.Milliseconds(),
+		Success:     result.Success,
+		Skipped:     result.Skipped,
+	}
+	if result.Error != nil {
+		entry.Error = result.Error.Error()
+		entry.ErrorKind = ErrorKind(result.Error)
+	}
+	return entry
+}
+
+// writeJSON encodes v as indented JSON followed by a newline
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	// Keep version constraints such as ">=150 <160" readable
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
@@ -0,0 +1,226 @@
+package apkpure
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteVersions(t *testing.T) {
+	listings := []VersionListing{
+		{
+			PackageID: "com.example",
+			Versions: []VersionInfo{
+				{
+					VersionName: "2.0",
+					VersionCode: "20",
+					APKType:     "XAPK",
+					DownloadURL: "https://example.com/20",
+					Size:        1024,
+					SHA256:      "ab",
+					ReleasedAt:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+					ABIs:        []string{"arm64-v8a"},
+					Extra:       map[string]json.RawMessage{"asset.label": json.RawMessage(`"beta"`)},
+				},
+				{VersionName: "1.0", APKType: "APK", DownloadURL: "https://example.com/10"},
+			},
+		},
+		{PackageID: "com.empty"},
+		{PackageID: "com.missing", Error: &PackageNotFoundError{PackageID: "com.missing"}},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{OutputPlaintext, `Versions available for com.example on APKPure:
+| 2.0 (20), 1.0
+Versions available for com.empty on APKPure:
+Versions available for com.missing on APKPure:
+| Error: package com.missing not found
+`},
+		{OutputJSON, `[
+  {
+    "package_id": "com.example",
+    "versions": [
+      {
+        "version_name": "2.0",
+        "version_code": "20",
+        "apk_type": "XAPK",
+        "download_url": "https://example.com/20",
+        "size": 1024,
+        "sha256": "ab",
+        "released_at": "2024-03-01T00:00:00Z",
+        "abis": [
+          "arm64-v8a"
+        ],
+        "extra": {
+          "asset.label": "beta"
+        }
+      },
+      {
+        "version_name": "1.0",
+        "version_code": "",
+        "apk_type": "APK",
+        "download_url": "https://example.com/10"
+      }
+    ]
+  },
+  {
+    "package_id": "com.empty",
+    "versions": []
+  },
+  {
+    "package_id": "com.missing",
+    "versions": [],
+    "error": "package com.missing not found",
+    "error_kind": "package_not_found"
+  }
+]
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteVersions(&buf, listings, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("WriteVersions output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if err := WriteVersions(&bytes.Buffer{}, listings, "yaml"); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	results := []DownloadResult{
+		{
+			AppInfo:           AppInfo{PackageID: "com.example", Version: ">=2.0 <3"},
+			Filename:          "com.example@2.0_20",
+			Success:           true,
+			Version:           VersionInfo{VersionName: "2.0", VersionCode: "20", APKType: "APK"},
+			Path:              "/out/com.example@2.0_20.apk",
+			Size:              1024,
+			SHA256:            "ab",
+			ChecksumsVerified: []string{"sha256"},
+			Duration:          1500 * time.Millisecond,
+		},
+		{
+			AppInfo:  AppInfo{PackageID: "com.cached", VersionCode: "7"},
+			Filename: "com.cached@1.0_7",
+			Success:  true,
+			Skipped:  true,
+			Version:  VersionInfo{VersionName: "1.0", VersionCode: "7", APKType: "XAPK"},
+			Path:     "/out/com.cached@1.0_7.xapk",
+			Size:     2048,
+		},
+		{
+			AppInfo:  AppInfo{PackageID: "com.missing"},
+			Filename: "com.missing",
+			Error:    &PackageNotFoundError{PackageID: "com.missing"},
+		},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{OutputPlaintext, `Failed to download com.missing: package com.missing not found
+
+Download complete: 2/3 succeeded (1 already present)
+`},
+		{OutputJSON, `{
+  "results": [
+    {
+      "package_id": "com.example",
+      "requested_version": ">=2.0 <3",
+      "version_name": "2.0",
+      "version_code": "20",
+      "apk_type": "APK",
+      "filename": "com.example@2.0_20",
+      "path": "/out/com.example@2.0_20.apk",
+      "size": 1024,
+      "sha256": "ab",
+      "checksums_verified": [
+        "sha256"
+      ],
+      "duration_ms": 1500,
+      "success": true
+    },
+    {
+      "package_id": "com.cached",
+      "requested_version_code": "7",
+      "version_name": "1.0",
+      "version_code": "7",
+      "apk_type": "XAPK",
+      "filename": "com.cached@1.0_7",
+      "path": "/out/com.cached@1.0_7.xapk",
+      "size": 2048,
+      "duration_ms": 0,
+      "success": true,
+      "skipped": true
+    },
+    {
+      "package_id": "com.missing",
+      "filename": "com.missing",
+      "size": 0,
+      "duration_ms": 0,
+      "success": false,
+      "error": "package com.missing not found",
+      "error_kind": "package_not_found"
+    }
+  ],
+  "summary": {
+    "total": 3,
+    "succeeded": 2,
+    "failed": 1,
+    "skipped": 1
+  }
+}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteResults(&buf, results, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("WriteResults output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteResultsEmpty(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{OutputPlaintext, "\nDownload complete: 0/0 succeeded\n"},
+		{OutputJSON, `{
+  "results": [],
+  "summary": {
+    "total": 0,
+    "succeeded": 0,
+    "failed": 0,
+    "skipped": 0
+  }
+}
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteResults(&buf, nil, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: WriteResults(nil) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package apkpure
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyFillAttempts bounds how often a request downloads a build that
+// other downloads evict before it can be served
+const proxyFillAttempts = 3
+
+// proxyCacheTTL is how long the proxy reuses a version listing when its
+// client has no metadata cache of its own
+const proxyCacheTTL = 5 * time.Minute
+
+// ProxyStats counts what a Proxy has served since it started
+type ProxyStats struct {
+	// Entries and Size describe the builds in the cache
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+	// MaxSize is the cache size limit (0 means unlimited)
+	MaxSize  int64 `json:"max_size"`
+	Requests int64 `json:"requests"`
+	// Hits were served from the cache; Misses waited for a download,
+	// which concurrent misses of one build share
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Downloads and DownloadedBytes count builds fetched from APKPure
+	Downloads       int64 `json:"downloads"`
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	ServedBytes     int64 `json:"served_bytes"`
+	Evictions       int64 `json:"evictions"`
+	EvictedBytes    int64 `json:"evicted_bytes"`
+	// Errors counts requests that failed to resolve or download a build
+	Errors int64 `json:"errors"`
+}
+
+// Proxy is a caching APK proxy for fleets of machines that need the same
+// builds. A request for an app spec is resolved against the version API,
+// the build is downloaded into the cache directory once and every request
+// for it is then served from disk, with ETag and Range support. A spec
+// naming only a versionCode is served from the cache without asking the
+// API. When the cache outgrows its limit, the least recently used builds
+// are deleted.
+type Proxy struct {
+	client  *Client
+	dir     string
+	maxSize int64
+	mux     *http.ServeMux
+
+	// sem bounds upstream requests across all HTTP requests
+	sem chan struct{}
+	// fills shares one download between concurrent misses of a build
+	fills flightGroup[*proxyEntry]
+
+	mu sync.Mutex
+	// entries holds the cached builds by filename; lru orders them, most
+	// recently used first
+	entries map[string]*proxyEntry
+	lru     *list.List
+	stats   ProxyStats
+	// pending is the expected size of the downloads in progress; their
+	// partial files count toward the limit
+	pending int64
+
+	// ctx outlives the HTTP requests that start downloads and is canceled
+	// by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// proxyEntry is one cached build
+type proxyEntry struct {
+	name string
+	size int64
+	// etag is the quoted SHA-256 of the file, or "" until it is known
+	etag string
+	// readers counts responses streaming the file; it is not evicted
+	// while they do
+	readers int
+	elem    *list.Element
+}
+
+// NewProxy creates a proxy that caches builds in dir, downloading them
+// with client. Builds already in dir are kept, oldest first in line for
+// eviction. A maxSize of zero or less disables the size limit. Version
+// listings are reused for the client's CacheTTL; a client without a
+// metadata cache gets an in-memory one kept for five minutes.
+func NewProxy(client *Client, dir string, maxSize int64) (*Proxy, error) {
+	if client.options.Cache == nil {
+		opts := client.options
+		opts.Cache = NewMemoryCache()
+		if opts.CacheTTL <= 0 {
+			opts.CacheTTL = proxyCacheTTL
+		}
+		client = client.withOptions(opts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Proxy{
+		client:  client,
+		dir:     dir,
+		maxSize: max(maxSize, 0),
+		mux:     http.NewServeMux(),
+		sem:     make(chan struct{}, client.options.Parallel),
+		entries: make(map[string]*proxyEntry),
+		lru:     list.New(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	p.stats.MaxSize = p.maxSize
+
+	if err := p.load(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	p.mux.HandleFunc("GET /v1/apk/{spec}", p.handleAPK)
+	p.mux.HandleFunc("GET /v1/stats", p.handleStats)
+	return p, nil
+}
+
+// ServeHTTP routes a request to the proxy:
+//
+//	GET /v1/apk/{spec}  the build of an app spec, e.g. com.example.app@1.2.3
+//	GET /v1/stats       cache and traffic counters
+//
+// A versionCode in the spec is written as %23, e.g. com.example.app%23123.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// Close cancels running downloads and waits for them to stop
+func (p *Proxy) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Stats returns the current counters
+func (p *Proxy) Stats() ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Entries = len(p.entries)
+	return stats
+}
+
+// load indexes the builds already in the cache directory by modification
+// time. Partial and quarantined downloads of an earlier run are deleted,
+// as nothing accounts for their size.
+func (p *Proxy) load() error {
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+
+	type cachedFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var cached []cachedFile
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		if isProxyLeftover(file.Name()) {
+			if err := os.Remove(filepath.Join(p.dir, file.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		if ext := filepath.Ext(file.Name()); ext != ".apk" && ext != ".xapk" {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		cached = append(cached, cachedFile{name: file.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	slices.SortFunc(cached, func(a, b cachedFile) int {
+		return b.modTime.Compare(a.modTime)
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, file := range cached {
+		entry := &proxyEntry{name: file.name, size: file.size}
+		entry.elem = p.lru.PushBack(entry)
+		p.entries[file.name] = entry
+		p.stats.Size += file.size
+	}
+	p.evictLocked()
+	return nil
+}
+
+// handleAPK serves GET /v1/apk/{spec}
+func (p *Proxy) handleAPK(w http.ResponseWriter, r *http.Request) {
+	p.count(func(s *ProxyStats) { s.Requests++ })
+
+	entry, err := p.lookup(r.Context(), r.PathValue("spec"))
+	if err != nil {
+		if r.Context().Err() == nil {
+			p.count(func(s *ProxyStats) { s.Errors++ })
+		}
+		writeHTTPError(w, err)
+		return
+	}
+	defer p.release(entry)
+
+	path := filepath.Join(p.dir, entry.name)
+	etag, err := p.etag(entry, path)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	counter := &countingResponseWriter{ResponseWriter: w}
+	serveAPK(counter, r, path)
+	p.count(func(s *ProxyStats) { s.ServedBytes += counter.n })
+}
+
+// handleStats serves GET /v1/stats
+func (p *Proxy) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, http.StatusOK, p.Stats())
+}
+
+// lookup resolves spec and returns its cached build, downloading it on a
+// miss. The entry is held for reading until release.
+func (p *Proxy) lookup(ctx context.Context, spec string) (*proxyEntry, error) {
+	app, err := ParseAppSpec(spec)
+	if err != nil {
+		return nil, &specError{err: err}
+	}
+
+	// A versionCode alone names one build, so a cached copy needs no lookup
+	if app.Version == "" && app.VersionCode != "" {
+		if entry, ok := p.holdBuild(app.PackageID, app.VersionCode); ok {
+			p.count(func(s *ProxyStats) { s.Hits++ })
+			return entry, nil
+		}
+	}
+
+	version, err := p.resolve(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	name := versionFilename(app.PackageID, *version) + versionExt(*version)
+
+	if entry, ok := p.hold(name); ok {
+		p.count(func(s *ProxyStats) { s.Hits++ })
+		return entry, nil
+	}
+	p.count(func(s *ProxyStats) { s.Misses++ })
+
+	for range proxyFillAttempts {
+		if err := p.fill(ctx, app, *version, name); err != nil {
+			return nil, err
+		}
+		// Another download may have evicted the build in the meantime
+		if entry, ok := p.hold(name); ok {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("%s was evicted before it could be served; the cache is too small", name)
+}
+
+// resolve looks up the build of app, holding a slot for the listing
+func (p *Proxy) resolve(ctx context.Context, app AppInfo) (*VersionInfo, error) {
+	if err := acquire(ctx, p.sem); err != nil {
+		return nil, err
+	}
+	versions, err := p.client.fetchVersions(ctx, app.PackageID)
+	<-p.sem
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch versions: %w", err)
+	}
+	return resolveVersion(app, versions)
+}
+
+// fill downloads a build into the cache, sharing the download with
+// concurrent requests for it. The download runs on the proxy's context,
+// so it completes for the other requests if ctx is done first.
+func (p *Proxy) fill(ctx context.Context, app AppInfo, version VersionInfo, name string) error {
+	done := make(chan error, 1)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		_, err := p.fills.do(p.ctx, name, func(context.Context) (*proxyEntry, error) {
+			return p.download(app, version, name)
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// download fetches a build into the cache directory and adds it to the
+// cache, evicting older builds to make room
+func (p *Proxy) download(app AppInfo, version VersionInfo, name string) (*proxyEntry, error) {
+	// The spec only selects the build; the cache is keyed by build
+	app.Checksum = Checksum{}
+
+	// Make room for the download before it starts
+	p.mu.Lock()
+	p.pending += version.Size
+	p.evictLocked()
+	p.mu.Unlock()
+
+	result := p.client.acquireAndRun(p.ctx, p.sem, app, func() DownloadResult {
+		return p.client.downloadVersionWithResult(p.ctx, app, version, p.dir)
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending -= version.Size
+	if result.Error != nil {
+		// Only complete builds are kept in the cache
+		path := filepath.Join(p.dir, name)
+		for _, leftover := range []string{path + partSuffix, path + partSuffix + partMetaSuffix, path + quarantineSuffix} {
+			if err := os.Remove(leftover); err != nil && !os.IsNotExist(err) {
+				p.client.logf("Failed to remove %s: %v\n", filepath.Base(leftover), err)
+			}
+		}
+		return nil, result.Error
+	}
+
+	if entry, ok := p.entries[name]; ok {
+		return entry, nil
+	}
+	entry := &proxyEntry{name: name, size: result.Size}
+	if result.SHA256 != "" {
+		entry.etag = `"` + result.SHA256 + `"`
+	}
+	entry.elem = p.lru.PushFront(entry)
+	p.entries[name] = entry
+	p.stats.Size += entry.size
+	if !result.Skipped {
+		p.stats.Downloads++
+		p.stats.DownloadedBytes += result.Size
+	}
+	p.evictLocked(entry)
+	return entry, nil
+}
+
+// hold marks the build named name as most recently used and holds it for
+// reading
+func (p *Proxy) hold(name string) (*proxyEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[name]
+	if !ok {
+		return nil, false
+	}
+	p.holdLocked(entry)
+	return entry, true
+}
+
+// holdBuild holds the cached build of packageID with versionCode, found
+// by its file name "package@version_versionCode.ext"
+func (p *Proxy) holdBuild(packageID, versionCode string) (*proxyEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, entry := range p.entries {
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if strings.HasPrefix(base, packageID+"@") && strings.HasSuffix(base, "_"+versionCode) {
+			p.holdLocked(entry)
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// holdLocked marks entry as most recently used and holds it for reading.
+// p.mu must be held.
+func (p *Proxy) holdLocked(entry *proxyEntry) {
+	entry.readers++
+	p.lru.MoveToFront(entry.elem)
+}
+
+// release ends a read of entry and evicts builds the cache no longer has
+// room for
+func (p *Proxy) release(entry *proxyEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry.readers--
+	p.evictLocked()
+}
+
+// evictLocked deletes the least recently used builds until the cache and
+// the downloads in progress fit its limit. Builds being read and keep are
+// spared. p.mu must be held.
+func (p *Proxy) evictLocked(keep ...*proxyEntry) {
+	if p.maxSize == 0 {
+		return
+	}
+	for elem := p.lru.Back(); elem != nil && p.stats.Size+p.pending > p.maxSize; {
+		entry := elem.Value.(*proxyEntry)
+		elem = elem.Prev()
+		if entry.readers > 0 || slices.Contains(keep, entry) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(p.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+			p.client.logf("Failed to evict %s: %v\n", entry.name, err)
+			continue
+		}
+		p.lru.Remove(entry.elem)
+		delete(p.entries, entry.name)
+		p.stats.Size -= entry.size
+		p.stats.Evictions++
+		p.stats.EvictedBytes += entry.size
+	}
+}
+
+// etag returns the ETag of a held entry, hashing the file the first time
+// it is served after the proxy starts
+func (p *Proxy) etag(entry *proxyEntry, path string) (string, error) {
+	p.mu.Lock()
+	etag := entry.etag
+	p.mu.Unlock()
+	if etag != "" {
+		return etag, nil
+	}
+
+	stats, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	etag = `"` + stats.sha256 + `"`
+
+	p.mu.Lock()
+	entry.etag = etag
+	p.mu.Unlock()
+	return etag, nil
+}
+
+// isProxyLeftover reports whether name is a partial or quarantined
+// download rather than a cached build
+func isProxyLeftover(name string) bool {
+	return strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, partSuffix+partMetaSuffix) ||
+		strings.HasSuffix(name, quarantineSuffix)
+}
+
+// count updates the counters under the lock
+func (p *Proxy) count(update func(*ProxyStats)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	update(&p.stats)
+}
+
+// countingResponseWriter counts the body bytes written through it
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
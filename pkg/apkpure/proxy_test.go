@@ -0,0 +1,106 @@
+package apkpure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// proxyGet requests spec from proxy and returns the response status
+func proxyGet(t *testing.T, proxy *Proxy, spec string) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/apk/"+spec, nil))
+	return rec.Code
+}
+
+func TestProxyLookups(t *testing.T) {
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {
+			{name: "1.1", code: "11", content: testPayload(1000, 2)},
+			{name: "1.0", code: "10", content: testPayload(1000, 1)},
+		},
+	})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "com.example@1.0_10.apk"), testPayload(1000, 1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxy(newTestClient(api, DownloadOptions{}), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	tests := []struct {
+		spec string
+		// listings and downloads are the upstream requests made so far
+		listings  int
+		downloads int
+	}{
+		// A cached versionCode is served without asking the API
+		{spec: "com.example%2310"},
+		{spec: "com.example%2311", listings: 1, downloads: 1},
+		{spec: "com.example%2311", listings: 1, downloads: 1},
+		// Other specs reuse the listing while it is fresh
+		{spec: "com.example", listings: 1, downloads: 1},
+		{spec: "com.example@1.0", listings: 1, downloads: 1},
+	}
+	for _, tt := range tests {
+		if code := proxyGet(t, proxy, tt.spec); code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", tt.spec, code)
+		}
+		if got := api.versionRequests(); got != tt.listings {
+			t.Errorf("after %s: %d version requests, want %d", tt.spec, got, tt.listings)
+		}
+		if got := len(api.downloadRequests()); got != tt.downloads {
+			t.Errorf("after %s: %d downloads, want %d", tt.spec, got, tt.downloads)
+		}
+	}
+	if stats := proxy.Stats(); stats.Hits != 4 || stats.Misses != 1 {
+		t.Errorf("got %d hits and %d misses, want 4 and 1", stats.Hits, stats.Misses)
+	}
+}
+
+func TestProxyLeftovers(t *testing.T) {
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {{name: "1.0", code: "10", content: testPayload(1000, 1)}},
+	})
+	dir := t.TempDir()
+	files := map[string]bool{
+		"com.example@1.0_10.apk":                               true,
+		"com.example@1.1_11.apk" + partSuffix:                  false,
+		"com.example@1.1_11.apk" + partSuffix + partMetaSuffix: false,
+		"com.example@0.9_9.apk" + quarantineSuffix:             false,
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), testPayload(1000, 3), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proxy, err := NewProxy(newTestClient(api, DownloadOptions{}), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	for name, want := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s exists: %v, want %v", name, err == nil, want)
+		}
+	}
+	if stats := proxy.Stats(); stats.Entries != 1 || stats.Size != 1000 {
+		t.Errorf("got %d entries of %d bytes, want the one build", stats.Entries, stats.Size)
+	}
+
+	// A download in progress counts toward the limit
+	proxy.maxSize = 1500
+	proxy.mu.Lock()
+	proxy.pending = 1000
+	proxy.evictLocked()
+	proxy.mu.Unlock()
+	if stats := proxy.Stats(); stats.Entries != 0 || stats.Evictions != 1 {
+		t.Errorf("got %d entries and %d evictions, want the build evicted", stats.Entries, stats.Evictions)
+	}
+}
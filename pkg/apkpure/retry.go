@@ -0,0 +1,195 @@
+package apkpure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Operations reported in RetryAttempt.Operation
+const (
+	OpFetchVersions   = "fetch_versions"
+	OpFetchAppDetails = "fetch_app_details"
+	OpSearch          = "search"
+	OpDownload        = "download"
+)
+
+// RetryPolicy controls how failed API calls and downloads are retried.
+// Start from DefaultRetryPolicy and adjust the fields you need.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry; it doubles on each
+	// further retry
+	BaseBackoff time.Duration
+	// MaxBackoff caps the exponential backoff (not Retry-After delays)
+	MaxBackoff time.Duration
+	// Jitter randomizes each backoff by up to this fraction in either
+	// direction (e.g. 0.2 for ±20%)
+	Jitter float64
+	// RetryableStatusCodes lists HTTP status codes worth retrying
+	RetryableStatusCodes []int
+	// RetryNetworkErrors retries connection failures, resets, timeouts
+	// and truncated responses
+	RetryNetworkErrors bool
+	// RespectRetryAfter waits at least as long as a Retry-After header on
+	// a retryable response asks
+	RespectRetryAfter bool
+	// Retryable, if set, replaces the status code and network error rules
+	Retryable func(err error) bool
+	// OnAttempt, if set, is called after every attempt
+	OnAttempt func(RetryAttempt)
+}
+
+// RetryAttempt describes the outcome of a single attempt
+type RetryAttempt struct {
+	// Operation is OpFetchVersions, OpFetchAppDetails, OpSearch or
+	// OpDownload
+	Operation string
+	URL       string
+	// Attempt is 1 for the first try
+	Attempt     int
+	MaxAttempts int
+	// Err is nil if the attempt succeeded
+	Err error
+	// Delay is how long we wait before the next attempt; zero if there
+	// won't be one
+	Delay time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used when DownloadOptions.Retry is nil
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseBackoff: time.Second,
+		MaxBackoff:  30 * time.Second,
+		Jitter:      0.2,
+		RetryableStatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		RetryNetworkErrors: true,
+		RespectRetryAfter:  true,
+	}
+}
+
+// errResumeMismatch marks a resume that couldn't continue; the partial
+// file has been discarded so a fresh attempt can succeed
+var errResumeMismatch = errors.New("cannot resume partial download")
+
+// isRetryable reports whether err is worth another attempt under p
+func (p *RetryPolicy) isRetryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	if errors.Is(err, errResumeMismatch) {
+		return true
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		for _, code := range p.RetryableStatusCodes {
+			if code == statusErr.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	return p.RetryNetworkErrors && isNetworkError(err)
+}
+
+// backoff returns the delay before retrying after the given attempt
+func (p *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	delay := p.BaseBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 && delay > 0 {
+		spread := float64(delay) * p.Jitter
+		delay += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+
+	var statusErr *HTTPStatusError
+	if p.RespectRetryAfter && errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+	}
+
+	return delay
+}
+
+// isNetworkError reports whether err looks like a transient transport
+// failure rather than a definitive answer from the server
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// withRetry runs fn until it succeeds, fails with a non-retryable error,
+// ctx is done or the policy's attempts are used up
+func (c *Client) withRetry(ctx context.Context, op, url string, fn func() error) error {
+	policy := c.options.Retry
+	maxAttempts := max(policy.MaxAttempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			c.logf("Retry #%d...\n", attempt-1)
+		}
+
+		err := fn()
+
+		// Don't retry once the caller has given up
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+		}
+
+		var delay time.Duration
+		retry := err != nil && attempt < maxAttempts && ctx.Err() == nil && policy.isRetryable(err)
+		if retry {
+			delay = policy.backoff(attempt, err)
+		}
+
+		if policy.OnAttempt != nil {
+			policy.OnAttempt(RetryAttempt{
+				Operation:   op,
+				URL:         url,
+				Attempt:     attempt,
+				MaxAttempts: maxAttempts,
+				Err:         err,
+				Delay:       delay,
+			})
+		}
+
+		if !retry {
+			if err != nil && attempt > 1 {
+				return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package apkpure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second, RespectRetryAfter: true}
+	throttled := &RateLimitedError{HTTPStatusError: HTTPStatusError{StatusCode: 429, RetryAfter: 20 * time.Second}}
+
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		attempt int
+		err     error
+		want    time.Duration
+	}{
+		{name: "first retry", policy: policy, attempt: 1, err: errors.New("x"), want: time.Second},
+		{name: "doubles", policy: policy, attempt: 2, err: errors.New("x"), want: 2 * time.Second},
+		{name: "doubles again", policy: policy, attempt: 3, err: errors.New("x"), want: 4 * time.Second},
+		{name: "capped", policy: policy, attempt: 4, err: errors.New("x"), want: 5 * time.Second},
+		{name: "capped late", policy: policy, attempt: 60, err: errors.New("x"), want: 5 * time.Second},
+		{name: "uncapped", policy: &RetryPolicy{BaseBackoff: time.Second}, attempt: 5, err: errors.New("x"), want: 16 * time.Second},
+		{name: "Retry-After beyond the cap", policy: policy, attempt: 1, err: throttled, want: 20 * time.Second},
+		{name: "Retry-After ignored", policy: &RetryPolicy{BaseBackoff: time.Second}, attempt: 1, err: throttled, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.backoff(tt.attempt, tt.err); got != tt.want {
+				t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	policy := &RetryPolicy{BaseBackoff: 10 * time.Second, Jitter: 0.2}
+	low, high := 10*time.Second, 10*time.Second
+	for range 1000 {
+		delay := policy.backoff(1, errors.New("x"))
+		low, high = min(low, delay), max(high, delay)
+	}
+	if low < 8*time.Second || high > 12*time.Second {
+		t.Errorf("delays ranged over [%s, %s], want within ±20%% of 10s", low, high)
+	}
+	if low == high {
+		t.Error("delays were not jittered")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "0", want: 0},
+		{value: "120", want: 2 * time.Minute},
+		{value: "-5", want: 0},
+		{value: "soon", want: 0},
+		{value: "Wed, 01 May 2024 10:00:30 GMT", want: 30 * time.Second},
+		{value: "Wed, 01 May 2024 09:59:00 GMT", want: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewStatusError(t *testing.T) {
+	tests := []struct {
+		status      int
+		retryAfter  string
+		wantLimited bool
+		wantDelay   time.Duration
+	}{
+		{status: 429, wantLimited: true},
+		{status: 429, retryAfter: "7", wantLimited: true, wantDelay: 7 * time.Second},
+		{status: 503, retryAfter: "7", wantLimited: true, wantDelay: 7 * time.Second},
+		{status: 503},
+		{status: 500, retryAfter: "7", wantDelay: 7 * time.Second},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		if tt.retryAfter != "" {
+			rec.Header().Set("Retry-After", tt.retryAfter)
+		}
+		rec.WriteHeader(tt.status)
+		resp := rec.Result()
+		resp.Request = httptest.NewRequest("GET", "https://tapi.pureapk.com/v3/x", nil)
+
+		err := newStatusError(resp)
+		if got := errors.Is(err, ErrRateLimited); got != tt.wantLimited {
+			t.Errorf("%d with Retry-After %q: rate limited %v, want %v", tt.status, tt.retryAfter, got, tt.wantLimited)
+		}
+		var statusErr *HTTPStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || statusErr.RetryAfter != tt.wantDelay {
+			t.Errorf("%d with Retry-After %q: got %#v", tt.status, tt.retryAfter, err)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	unavailable := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	notFound := &HTTPStatusError{StatusCode: http.StatusNotFound}
+	throttled := &RateLimitedError{HTTPStatusError: HTTPStatusError{StatusCode: 429, RetryAfter: time.Minute}}
+
+	tests := []struct {
+		name string
+		// errs are returned by successive attempts; later attempts succeed
+		errs        []error
+		maxAttempts int
+		wantErr     error
+		// wantDelays are the delays reported to OnAttempt
+		wantDelays []time.Duration
+	}{
+		{name: "succeeds", maxAttempts: 3, wantDelays: []time.Duration{0}},
+		{
+			name:        "recovers",
+			errs:        []error{unavailable, unavailable},
+			maxAttempts: 3,
+			wantDelays:  []time.Duration{time.Second, 2 * time.Second, 0},
+		},
+		{
+			name:        "gives up",
+			errs:        []error{unavailable, unavailable, unavailable},
+			maxAttempts: 3,
+			wantErr:     unavailable,
+			wantDelays:  []time.Duration{time.Second, 2 * time.Second, 0},
+		},
+		{
+			name:        "not retryable",
+			errs:        []error{notFound},
+			maxAttempts: 3,
+			wantErr:     notFound,
+			wantDelays:  []time.Duration{0},
+		},
+		{
+			name:        "waits as asked",
+			errs:        []error{throttled},
+			maxAttempts: 3,
+			wantDelays:  []time.Duration{time.Minute, 0},
+		},
+		{
+			name:        "single attempt",
+			errs:        []error{unavailable},
+			maxAttempts: 0,
+			wantErr:     unavailable,
+			wantDelays:  []time.Duration{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				var delays []time.Duration
+				policy := DefaultRetryPolicy()
+				policy.MaxAttempts = tt.maxAttempts
+				policy.Jitter = 0
+				policy.OnAttempt = func(a RetryAttempt) {
+					if a.Attempt != len(delays)+1 || a.MaxAttempts != max(tt.maxAttempts, 1) || a.Operation != OpDownload {
+						t.Errorf("unexpected attempt %+v", a)
+					}
+					delays = append(delays, a.Delay)
+				}
+				client := NewClient(DownloadOptions{OutputFormat: OutputJSON, Retry: policy})
+
+				start := time.Now()
+				calls := 0
+				err := client.withRetry(context.Background(), OpDownload, "https://example.com", func() error {
+					calls++
+					if calls <= len(tt.errs) {
+						return tt.errs[calls-1]
+					}
+					return nil
+				})
+
+				if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+				if len(delays) != len(tt.wantDelays) {
+					t.Fatalf("got delays %v, want %v", delays, tt.wantDelays)
+				}
+				var total time.Duration
+				for i, delay := range delays {
+					if delay != tt.wantDelays[i] {
+						t.Errorf("attempt %d: delay %s, want %s", i+1, delay, tt.wantDelays[i])
+					}
+					total += delay
+				}
+				if elapsed := time.Since(start); elapsed != total {
+					t.Errorf("slept %s, want %s", elapsed, total)
+				}
+			})
+		})
+	}
+}
+
+func TestWithRetryCanceledDuringBackoff(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		client := NewClient(DownloadOptions{OutputFormat: OutputJSON})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		calls := 0
+		start := time.Now()
+		err := client.withRetry(ctx, OpDownload, "https://example.com", func() error {
+			calls++
+			return &HTTPStatusError{StatusCode: http.StatusBadGateway}
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got error %v, want the deadline", err)
+		}
+		if calls != 1 || time.Since(start) != 100*time.Millisecond {
+			t.Errorf("made %d calls over %s, want the first backoff cut short", calls, time.Since(start))
+		}
+	})
+}
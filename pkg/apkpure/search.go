@@ -0,0 +1,106 @@
+package apkpure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SearchResults is one page of search results
+type SearchResults struct {
+	Query string `json:"query"`
+	// Page is the 1-based page number
+	Page int `json:"page"`
+	// HasMore is set when a further page may have results
+	HasMore bool         `json:"has_more"`
+	Apps    []AppDetails `json:"apps"`
+}
+
+// searchListKeys are the keys under which search responses nest the list
+// of apps, possibly inside further objects
+var searchListKeys = []string{"data", "list", "app_list", "apps", "items", "result"}
+
+// Search queries APKPure for apps matching a keyword and returns the given
+// page of results (the first page is 1). Only the package ID, title,
+// developer and latest version of each app are reliably filled in.
+func (c *Client) Search(query string, page int) (*SearchResults, error) {
+	return c.SearchContext(context.Background(), query, page)
+}
+
+// SearchContext is like Search but honours ctx cancellation
+func (c *Client) SearchContext(ctx context.Context, query string, page int) (*SearchResults, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	page = max(page, 1)
+
+	url := c.getSearchURL(query, page)
+	key := c.cacheKey(url)
+	subject := fmt.Sprintf("search results for %q", query)
+
+	results, err := fetchCached(ctx, c, OpSearch, subject, "", url, key, parseSearchResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	results.Query = query
+	results.Page = page
+	return results, nil
+}
+
+// parseSearchResponse decodes a search response. Without an explicit
+// "more" flag, a page with results is assumed to have a successor.
+func parseSearchResponse(body []byte) (*SearchResults, error) {
+	var fields rawFields
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	results := &SearchResults{Apps: []AppDetails{}}
+	items, more, hasMoreFlag := findSearchList(fields, 0)
+	for _, raw := range items {
+		var item rawFields
+		if err := json.Unmarshal(raw, &item); err != nil || item == nil {
+			continue
+		}
+		app := appDetailsFromFields(item.unwrap("app_info", "app_detail", "app"))
+		if app.PackageID == "" {
+			continue
+		}
+		results.Apps = append(results.Apps, *app)
+	}
+
+	results.HasMore = more
+	if !hasMoreFlag {
+		results.HasMore = len(results.Apps) > 0
+	}
+	return results, nil
+}
+
+// findSearchList looks for the array of apps under searchListKeys, a few
+// objects deep, along with any "more results" flag next to it
+func findSearchList(fields rawFields, depth int) (items []json.RawMessage, more, found bool) {
+	more, found = fields.bool("has_more", "is_more", "more")
+	for _, key := range searchListKeys {
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, &items); err == nil {
+			return items, more, found
+		}
+
+		var inner rawFields
+		if depth < 2 && json.Unmarshal(raw, &inner) == nil && inner != nil {
+			innerItems, innerMore, innerFound := findSearchList(inner, depth+1)
+			if innerItems != nil {
+				if innerFound {
+					more, found = innerMore, true
+				}
+				return innerItems, more, found
+			}
+		}
+	}
+	return nil, more, found
+}
@@ -0,0 +1,553 @@
+package apkpure
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JobStatus is the state of a server download job
+type JobStatus string
+
+const (
+	// JobQueued is waiting for a free download slot
+	JobQueued JobStatus = "queued"
+	// JobRunning is downloading
+	JobRunning JobStatus = "running"
+	// JobDone has its file in the server's directory
+	JobDone JobStatus = "done"
+	// JobFailed ended with an error; requesting the build again retries it
+	JobFailed JobStatus = "failed"
+)
+
+// jobTTL is how long a Server keeps a finished job for status requests
+const jobTTL = time.Hour
+
+// Job is the download of one build by a Server. Requests for the same
+// package and versionCode share a job.
+type Job struct {
+	ID        string
+	Spec      string
+	PackageID string
+	// Version is the build the spec resolved to
+	Version   VersionInfo
+	Status    JobStatus
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Result is set once the job is done or failed
+	Result *DownloadResult
+
+	// done is closed when the job finishes
+	done chan struct{}
+}
+
+// jobJSON is the JSON form of a Job
+type jobJSON struct {
+	ID          string              `json:"id"`
+	Spec        string              `json:"spec"`
+	PackageID   string              `json:"package_id"`
+	VersionName string              `json:"version_name"`
+	VersionCode string              `json:"version_code"`
+	APKType     string              `json:"apk_type"`
+	Status      JobStatus           `json:"status"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+	Result      *downloadResultJSON `json:"result,omitempty"`
+}
+
+// errorJSON is the body of a failed server request
+type errorJSON struct {
+	Error     string `json:"error"`
+	ErrorKind string `json:"error_kind"`
+}
+
+// Server exposes a Client over HTTP: version listings, app details and
+// downloads by app spec. Downloads run as jobs that store the file in the
+// server's directory; concurrent requests for one build share its job.
+// Every upstream request of the server, listings included, takes one of
+// the client's Parallel slots.
+type Server struct {
+	client *Client
+	dir    string
+	mux    *http.ServeMux
+
+	// sem bounds upstream requests across all HTTP requests
+	sem chan struct{}
+
+	mu sync.Mutex
+	// jobs holds the latest job of each build by buildKey; finished jobs
+	// expire after jobTTL
+	jobs map[string]*Job
+
+	// ctx outlives the HTTP requests that start jobs and is canceled by
+	// Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewServer creates a server that downloads into dir using client
+func NewServer(client *Client, dir string) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		client: client,
+		dir:    dir,
+		mux:    http.NewServeMux(),
+		sem:    make(chan struct{}, client.options.Parallel),
+		jobs:   make(map[string]*Job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	s.mux.HandleFunc("GET /v1/apps/{package}", s.handleAppDetails)
+	s.mux.HandleFunc("GET /v1/apps/{package}/versions", s.handleVersions)
+	s.mux.HandleFunc("GET /v1/download", s.handleDownload)
+	s.mux.HandleFunc("GET /v1/jobs", s.handleListJobs)
+	s.mux.HandleFunc("POST /v1/jobs", s.handleCreateJob)
+	s.mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+	s.mux.HandleFunc("GET /v1/jobs/{id}/file", s.handleJobFile)
+	return s
+}
+
+// ServeHTTP routes a request to the server's API:
+//
+//	GET  /v1/apps/{package}           store listing of a package
+//	GET  /v1/apps/{package}/versions  versions of a package
+//	GET  /v1/download?spec=SPEC       download a build and stream it back
+//	POST /v1/jobs {"spec": SPEC}      start downloading a build
+//	GET  /v1/jobs                     all jobs
+//	GET  /v1/jobs/{id}                status of a job
+//	GET  /v1/jobs/{id}/file           file of a finished job
+//
+// SPEC is an app spec as accepted by ParseAppSpec.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels running jobs and waits for them to stop
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Jobs returns a snapshot of all jobs, oldest first
+func (s *Server) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	slices.SortFunc(jobs, func(a, b Job) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return jobs
+}
+
+// StartJob resolves spec and starts downloading the build unless a job for
+// it is queued, running or done with its file still present
+func (s *Server) StartJob(ctx context.Context, spec string) (Job, error) {
+	job, err := s.startJob(ctx, spec)
+	if err != nil {
+		return Job{}, err
+	}
+	return s.snapshot(job), nil
+}
+
+// startJob is StartJob returning the live job
+func (s *Server) startJob(ctx context.Context, spec string) (*Job, error) {
+	app, err := ParseAppSpec(spec)
+	if err != nil {
+		return nil, &specError{err: err}
+	}
+
+	version, err := s.resolve(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	key := buildKey(app.PackageID, *version)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked()
+	if job, ok := s.jobs[key]; ok && s.reusable(job) {
+		return job, nil
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        jobID(key),
+		Spec:      spec,
+		PackageID: app.PackageID,
+		Version:   *version,
+		Status:    JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		done:      make(chan struct{}),
+	}
+	s.jobs[key] = job
+
+	s.wg.Add(1)
+	go s.run(job, app)
+	return job, nil
+}
+
+// reusable reports whether a new request for job's build can share it.
+// s.mu must be held.
+func (s *Server) reusable(job *Job) bool {
+	switch job.Status {
+	case JobFailed:
+		return false
+	case JobDone:
+		_, err := os.Stat(job.Result.Path)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// run downloads the build of job once a slot is free
+func (s *Server) run(job *Job, app AppInfo) {
+	defer s.wg.Done()
+
+	result := s.client.acquireAndRun(s.ctx, s.sem, app, func() DownloadResult {
+		s.setStatus(job, JobRunning)
+		return s.client.downloadVersionWithResult(s.ctx, app, job.Version, s.dir)
+	})
+
+	s.mu.Lock()
+	job.Result = &result
+	job.Status = JobDone
+	if !result.Success {
+		job.Status = JobFailed
+	}
+	job.UpdatedAt = time.Now()
+	s.mu.Unlock()
+
+	close(job.done)
+}
+
+// expireLocked drops jobs that finished more than jobTTL ago; their files
+// stay on disk and are reused by the next request. s.mu must be held.
+func (s *Server) expireLocked() {
+	maps.DeleteFunc(s.jobs, func(_ string, job *Job) bool {
+		finished := job.Status == JobDone || job.Status == JobFailed
+		return finished && time.Since(job.UpdatedAt) > jobTTL
+	})
+}
+
+// setStatus updates the status of a running job
+func (s *Server) setStatus(job *Job, status JobStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Status = status
+	job.UpdatedAt = time.Now()
+}
+
+// snapshot copies job so it can be read without holding s.mu
+func (s *Server) snapshot(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *job
+}
+
+// lookupJob returns the job with the given ID
+func (s *Server) lookupJob(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked()
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// resolve looks up the build of app, holding a slot for the listing
+func (s *Server) resolve(ctx context.Context, app AppInfo) (*VersionInfo, error) {
+	if err := acquire(ctx, s.sem); err != nil {
+		return nil, err
+	}
+	defer func() { <-s.sem }()
+
+	return s.client.ResolveVersionContext(ctx, app)
+}
+
+// buildKey identifies one build of a package. Builds without a
+// versionCode fall back to their version name.
+func buildKey(packageID string, version VersionInfo) string {
+	if version.VersionCode != "" {
+		return packageID + "#" + version.VersionCode
+	}
+	return packageID + "@" + version.VersionName
+}
+
+// jobID derives a short stable ID from a build key
+func jobID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// specError reports an app spec the server could not parse
+type specError struct {
+	err error
+}
+
+func (e *specError) Error() string {
+	return fmt.Sprintf("invalid spec: %v", e.err)
+}
+
+func (e *specError) Unwrap() error { return e.err }
+
+// packageParam returns the {package} path value, or a *specError if it
+// isn't a package name
+func packageParam(r *http.Request) (string, error) {
+	packageID := r.PathValue("package")
+	if err := ValidatePackageID(packageID); err != nil {
+		return "", &specError{err: err}
+	}
+	return packageID, nil
+}
+
+// handleAppDetails serves GET /v1/apps/{package}
+func (s *Server) handleAppDetails(w http.ResponseWriter, r *http.Request) {
+	packageID, err := packageParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if err := acquire(r.Context(), s.sem); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	details, err := s.client.GetAppDetailsContext(r.Context(), packageID)
+	<-s.sem
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, details)
+}
+
+// handleVersions serves GET /v1/apps/{package}/versions
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	packageID, err := packageParam(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if err := acquire(r.Context(), s.sem); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	versions, err := s.client.fetchVersions(r.Context(), packageID)
+	<-s.sem
+	if err == nil && len(versions) == 0 {
+		err = &PackageNotFoundError{PackageID: packageID}
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, versionListingJSON{PackageID: packageID, Versions: versions})
+}
+
+// handleDownload serves GET /v1/download?spec=SPEC. It waits for the job
+// of the build and streams its file; the job carries on if the caller
+// goes away.
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	job, err := s.startJob(r.Context(), r.URL.Query().Get("spec"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		return
+	}
+	s.serveJobFile(w, r, s.snapshot(job))
+}
+
+// handleListJobs serves GET /v1/jobs
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.Jobs()
+	out := make([]jobJSON, len(jobs))
+	for i, job := range jobs {
+		out[i] = newJobJSON(job)
+	}
+	writeHTTPJSON(w, http.StatusOK, out)
+}
+
+// handleCreateJob serves POST /v1/jobs. It answers 202 Accepted while the
+// job is pending and 200 OK when the build is already there.
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Spec string `json:"spec"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeHTTPError(w, &specError{err: fmt.Errorf("failed to parse request body: %w", err)})
+		return
+	}
+
+	job, err := s.StartJob(r.Context(), body.Spec)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	status := http.StatusAccepted
+	if job.Status == JobDone {
+		status = http.StatusOK
+	}
+	writeHTTPJSON(w, status, newJobJSON(job))
+}
+
+// handleJob serves GET /v1/jobs/{id}
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookupJob(r.PathValue("id"))
+	if !ok {
+		writeHTTPStatus(w, http.StatusNotFound, "job not found", KindOther)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, newJobJSON(s.snapshot(job)))
+}
+
+// handleJobFile serves GET /v1/jobs/{id}/file
+func (s *Server) handleJobFile(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookupJob(r.PathValue("id"))
+	if !ok {
+		writeHTTPStatus(w, http.StatusNotFound, "job not found", KindOther)
+		return
+	}
+	s.serveJobFile(w, r, s.snapshot(job))
+}
+
+// serveJobFile streams the file of a finished job, or reports why there
+// is none
+func (s *Server) serveJobFile(w http.ResponseWriter, r *http.Request, job Job) {
+	switch job.Status {
+	case JobFailed:
+		writeHTTPError(w, job.Result.Error)
+		return
+	case JobQueued, JobRunning:
+		writeHTTPStatus(w, http.StatusConflict, fmt.Sprintf("job %s is %s", job.ID, job.Status), KindOther)
+		return
+	}
+
+	serveAPK(w, r, job.Result.Path)
+}
+
+// serveAPK streams an APK or XAPK as an attachment, honouring Range and
+// conditional requests
+func serveAPK(w http.ResponseWriter, r *http.Request, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	name := filepath.Base(path)
+	contentType := "application/vnd.android.package-archive"
+	if filepath.Ext(name) != ".apk" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// newJobJSON converts a Job to its JSON form
+func newJobJSON(job Job) jobJSON {
+	entry := jobJSON{
+		ID:          job.ID,
+		Spec:        job.Spec,
+		PackageID:   job.PackageID,
+		VersionName: job.Version.VersionName,
+		VersionCode: job.Version.VersionCode,
+		APKType:     job.Version.APKType,
+		Status:      job.Status,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}
+	if job.Result != nil {
+		result := newDownloadResultJSON(*job.Result)
+		entry.Result = &result
+	}
+	return entry
+}
+
+// httpStatus maps an error to the status a server answers with
+func httpStatus(err error) int {
+	var specErr *specError
+	if errors.As(err, &specErr) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return http.StatusNotFound
+	}
+
+	switch ErrorKind(err) {
+	case KindPackageNotFound, KindVersionNotFound:
+		return http.StatusNotFound
+	case KindRateLimited, KindNotCached:
+		return http.StatusServiceUnavailable
+	case KindHTTPStatus, KindVerificationFailed, KindLockDrift:
+		return http.StatusBadGateway
+	case KindCanceled:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeHTTPError answers with the status and JSON body for err. An
+// upstream Retry-After is passed on.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) && rateLimited.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(rateLimited.RetryAfter.Round(time.Second).Seconds())))
+	}
+	writeHTTPStatus(w, httpStatus(err), err.Error(), ErrorKind(err))
+}
+
+// writeHTTPStatus answers with status and a JSON error body
+func writeHTTPStatus(w http.ResponseWriter, status int, message, kind string) {
+	writeHTTPJSON(w, status, errorJSON{Error: message, ErrorKind: kind})
+}
+
+// writeHTTPJSON answers with status and v as JSON
+func writeHTTPJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = writeJSON(w, v)
+}
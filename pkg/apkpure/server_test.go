@@ -0,0 +1,165 @@
+package apkpure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverDo sends a request to server and returns the recorded response
+func serverDo(t *testing.T, server *Server, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestServer returns a server of a fake API offering com.example 1.0
+// and 1.1, and the content of 1.0
+func newTestServer(t *testing.T) (*Server, []byte) {
+	t.Helper()
+	content := testPayload(1000, 1)
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {
+			{name: "1.1", code: "11", content: testPayload(1000, 2)},
+			{name: "1.0", code: "10", content: content},
+		},
+	})
+	api.details = map[string]string{"com.example": `{"package_name": "com.example", "title": "Example"}`}
+
+	server := NewServer(newTestClient(api, DownloadOptions{}), t.TempDir())
+	t.Cleanup(server.Close)
+	return server, content
+}
+
+func TestServerListings(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		// wantBody is a fragment of the response
+		wantBody string
+	}{
+		{target: "/v1/apps/com.example/versions", wantStatus: http.StatusOK, wantBody: `"version_code": "11"`},
+		{target: "/v1/apps/com.example", wantStatus: http.StatusOK, wantBody: `"title": "Example"`},
+		{target: "/v1/apps/com.missing/versions", wantStatus: http.StatusNotFound, wantBody: `"error_kind": "package_not_found"`},
+		{target: "/v1/apps/com..bad/versions", wantStatus: http.StatusBadRequest, wantBody: "invalid package name"},
+		{target: "/v1/download?spec=com.example@>=", wantStatus: http.StatusBadRequest, wantBody: "invalid spec"},
+		{target: "/v1/download?spec=com.example@9", wantStatus: http.StatusNotFound, wantBody: `"error_kind": "version_not_found"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := serverDo(t, server, "GET", tt.target, "", nil)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body %s does not contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServerDownload(t *testing.T) {
+	server, content := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+		wantBody   []byte
+	}{
+		{name: "whole file", wantStatus: http.StatusOK, wantBody: content},
+		{name: "range", header: http.Header{"Range": {"bytes=100-199"}}, wantStatus: http.StatusPartialContent, wantBody: content[100:200]},
+		{name: "suffix range", header: http.Header{"Range": {"bytes=-10"}}, wantStatus: http.StatusPartialContent, wantBody: content[990:]},
+		{name: "unsatisfiable range", header: http.Header{"Range": {"bytes=5000-"}}, wantStatus: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serverDo(t, server, "GET", "/v1/download?spec=com.example@1.0", "", tt.header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != nil && rec.Body.String() != string(tt.wantBody) {
+				t.Errorf("got %d bytes, want %d", rec.Body.Len(), len(tt.wantBody))
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="com.example@1.0_10.apk"` {
+				t.Errorf("Content-Disposition %q", got)
+			}
+		})
+	}
+
+	if jobs := server.Jobs(); len(jobs) != 1 {
+		t.Errorf("got %d jobs, want the downloads to share one", len(jobs))
+	}
+}
+
+func TestServerJobs(t *testing.T) {
+	server, content := newTestServer(t)
+
+	rec := serverDo(t, server, "POST", "/v1/jobs", `{"spec": "com.example#10"}`, nil)
+	if rec.Code != http.StatusAccepted && rec.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	var job jobJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if location := rec.Header().Get("Location"); location != "/v1/jobs/"+job.ID {
+		t.Errorf("Location %q", location)
+	}
+
+	// Wait for the job to finish
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != JobDone {
+		if job.Status == JobFailed || time.Now().After(deadline) {
+			t.Fatalf("job ended as %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		rec = serverDo(t, server, "GET", "/v1/jobs/"+job.ID, "", nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Result == nil || job.VersionCode != "10" {
+		t.Fatalf("finished job %+v", job)
+	}
+
+	if rec := serverDo(t, server, "GET", "/v1/jobs/"+job.ID+"/file", "", nil); rec.Code != http.StatusOK || rec.Body.String() != string(content) {
+		t.Errorf("file: status %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := serverDo(t, server, "POST", "/v1/jobs", `{"spec": "com.example@1.0"}`, nil); rec.Code != http.StatusOK {
+		t.Errorf("repeated create: status %d, want 200 for a finished build", rec.Code)
+	}
+	if rec := serverDo(t, server, "GET", "/v1/jobs", "", nil); !strings.Contains(rec.Body.String(), job.ID) {
+		t.Errorf("job list %s lacks %s", rec.Body, job.ID)
+	}
+	if rec := serverDo(t, server, "GET", "/v1/jobs/000000000000", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", rec.Code)
+	}
+
+	// Finished jobs expire; the file stays for the next request
+	server.mu.Lock()
+	for _, live := range server.jobs {
+		live.UpdatedAt = time.Now().Add(-jobTTL - time.Minute)
+	}
+	server.mu.Unlock()
+	if rec := serverDo(t, server, "GET", "/v1/jobs/"+job.ID, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expired job: status %d, want 404", rec.Code)
+	}
+	if jobs := server.Jobs(); len(jobs) != 0 {
+		t.Errorf("%d jobs left after expiry", len(jobs))
+	}
+	if _, err := os.Stat(job.Result.Path); err != nil {
+		t.Errorf("the expired job's file is gone: %v", err)
+	}
+}
@@ -0,0 +1,440 @@
+package apkpure
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LinkMode controls how a store blob is placed in an output directory
+type LinkMode string
+
+const (
+	// LinkHard hard-links blobs, copying when the output directory is on
+	// another filesystem
+	LinkHard LinkMode = "hardlink"
+	// LinkSymlink creates symbolic links to blobs
+	LinkSymlink LinkMode = "symlink"
+	// LinkCopy copies blobs
+	LinkCopy LinkMode = "copy"
+)
+
+// Store is a content-addressed APK store shared between output
+// directories. Files are kept once per SHA-256 under blobs/, and an index
+// maps each package and versionCode to its blob. Several processes may
+// use the same store; GC should only run while none is downloading.
+type Store struct {
+	dir  string
+	mode LinkMode
+}
+
+// StoreEntry is the index record of one stored build
+type StoreEntry struct {
+	PackageID   string    `json:"package_id"`
+	VersionCode string    `json:"version_code"`
+	VersionName string    `json:"version_name"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// StoreGCReport describes what a garbage collection removed
+type StoreGCReport struct {
+	// Removed lists the digests of deleted blobs
+	Removed []string `json:"removed"`
+	// Freed is the number of bytes the deleted blobs used
+	Freed int64 `json:"freed"`
+	// Kept counts the blobs still referenced by the index
+	Kept int `json:"kept"`
+	// Dangling lists index entries dropped because their blob was missing
+	Dangling []string `json:"dangling,omitempty"`
+}
+
+// OpenStore opens the store in dir, creating it if needed. An empty mode
+// means LinkHard.
+func OpenStore(dir string, mode LinkMode) (*Store, error) {
+	switch mode {
+	case "":
+		mode = LinkHard
+	case LinkHard, LinkSymlink, LinkCopy:
+	default:
+		return nil, fmt.Errorf("unknown link mode %q", mode)
+	}
+
+	// Symlinks must not depend on the working directory
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"blobs", "index"} {
+		if err := os.MkdirAll(filepath.Join(abs, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Store{dir: abs, mode: mode}, nil
+}
+
+// Dir returns the store directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Lookup returns the index entry for a build, if it is stored
+func (s *Store) Lookup(packageID, versionCode string) (*StoreEntry, bool) {
+	path, err := s.indexPath(packageID, versionCode)
+	if err != nil {
+		return nil, false
+	}
+	entry, err := readStoreEntry(path)
+	if err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Entries returns every index entry, sorted by package and versionCode
+func (s *Store) Entries() ([]StoreEntry, error) {
+	var entries []StoreEntry
+	err := s.walkIndex(func(path string, entry *StoreEntry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b StoreEntry) int {
+		if c := strings.Compare(a.PackageID, b.PackageID); c != 0 {
+			return c
+		}
+		return compareBuilds(
+			SyncedBuild{VersionCode: a.VersionCode, VersionName: a.VersionName},
+			SyncedBuild{VersionCode: b.VersionCode, VersionName: b.VersionName})
+	})
+	return entries, nil
+}
+
+// Remove drops a build from the index. Its blob is deleted by the next GC
+// unless another entry still refers to it.
+func (s *Store) Remove(packageID, versionCode string) error {
+	path, err := s.indexPath(packageID, versionCode)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// GC deletes blobs that no index entry refers to, along with leftover
+// temporary files, and drops index entries whose blob has gone missing
+func (s *Store) GC() (*StoreGCReport, error) {
+	report := &StoreGCReport{Removed: []string{}}
+
+	referenced := make(map[string]bool)
+	err := s.walkIndex(func(path string, entry *StoreEntry) error {
+		// An entry is dangling when its digest is malformed or its blob
+		// has gone missing
+		blob, err := s.blobPath(entry.SHA256)
+		if err == nil {
+			if _, statErr := os.Stat(blob); errors.Is(statErr, fs.ErrNotExist) {
+				err = statErr
+			}
+		}
+		if err != nil {
+			report.Dangling = append(report.Dangling, entry.PackageID+"#"+entry.VersionCode)
+			return os.Remove(path)
+		}
+		referenced[entry.SHA256] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(filepath.Join(s.dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		digest := d.Name()
+		if blob, err := s.blobPath(digest); err == nil && referenced[digest] && path == blob {
+			report.Kept++
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		report.Removed = append(report.Removed, digest)
+		report.Freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// lookupBlob finds the blob of a build by its index entry, or by the
+// digest the version API advertised. It reports false when there is none
+// or the blob no longer matches its digest.
+func (s *Store) lookupBlob(packageID string, version VersionInfo) (string, fileStats, bool, error) {
+	digest := version.SHA256
+	if entry, ok := s.Lookup(packageID, version.VersionCode); ok {
+		digest = entry.SHA256
+	}
+	if digest == "" {
+		return "", fileStats{}, false, nil
+	}
+
+	blob, err := s.blobPath(digest)
+	if err != nil {
+		// A malformed digest names no blob; drop any entry carrying one
+		return "", fileStats{}, false, s.Remove(packageID, version.VersionCode)
+	}
+	stats, err := hashFile(blob)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fileStats{}, false, s.Remove(packageID, version.VersionCode)
+	}
+	if err != nil {
+		return "", fileStats{}, false, err
+	}
+	if stats.sha256 != digest {
+		// Corrupt blob; drop it so the download replaces it
+		return "", fileStats{}, false, s.discard(packageID, version.VersionCode, blob)
+	}
+
+	return blob, stats, true, nil
+}
+
+// fetchStored places a stored build in outPath as filename instead of
+// downloading it. It reports false when the build is not in the store.
+func (c *Client) fetchStored(packageID string, version VersionInfo, outPath, filename string, expected []Checksum) (fileStats, bool, error) {
+	store := c.options.Store
+	fullPath := filepath.Join(outPath, filename)
+
+	unlock := c.lockPath(fullPath)
+	defer unlock()
+
+	if _, err := os.Stat(fullPath); err == nil {
+		return fileStats{}, false, &FileExistsError{Path: fullPath}
+	}
+
+	blob, stats, ok, err := store.lookupBlob(packageID, version)
+	if err != nil || !ok {
+		return fileStats{}, false, err
+	}
+
+	stats.verified, err = verifyChecksums(blob, stats, expected)
+	if err != nil {
+		// The stored build is not the one expected; download it instead
+		c.logf("Stored copy of %s failed verification, downloading it: %v\n", filename, err)
+		return fileStats{}, false, store.discard(packageID, version.VersionCode, blob)
+	}
+
+	if err := store.place(blob, fullPath); err != nil {
+		return fileStats{}, false, err
+	}
+	if err := store.record(packageID, version, stats); err != nil {
+		return fileStats{}, false, err
+	}
+	return stats, true, nil
+}
+
+// add moves a freshly downloaded file into the store, replaces it with a
+// link according to the link mode and records it in the index
+func (s *Store) add(packageID string, version VersionInfo, path string, stats fileStats) error {
+	blob, err := s.blobPath(stats.sha256)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(blob); errors.Is(err, fs.ErrNotExist) {
+		if err := addBlob(path, blob); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if s.mode != LinkCopy {
+		if err := s.relink(blob, path); err != nil {
+			return err
+		}
+	}
+	return s.record(packageID, version, stats)
+}
+
+// addBlob stores the contents of path as blob, hard-linking when possible
+func addBlob(path, blob string) error {
+	if err := os.Link(path, blob); err == nil || errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	return copyFile(path, blob)
+}
+
+// discard drops a build's index entry and its blob after the blob failed
+// verification
+func (s *Store) discard(packageID, versionCode, blob string) error {
+	if err := os.Remove(blob); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.Remove(packageID, versionCode)
+}
+
+// relink replaces path with a link to blob. Where a hard link cannot be
+// made, e.g. across filesystems, path is replaced by a copy of the blob.
+func (s *Store) relink(blob, path string) error {
+	if s.mode == LinkHard {
+		blobInfo, err := os.Stat(blob)
+		if err != nil {
+			return err
+		}
+		pathInfo, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if os.SameFile(blobInfo, pathInfo) {
+			return nil
+		}
+	}
+
+	tmp := path + ".link"
+	_ = os.Remove(tmp)
+	switch s.mode {
+	case LinkHard:
+		if err := os.Link(blob, tmp); err != nil {
+			if copyErr := copyFile(blob, tmp); copyErr != nil {
+				return errors.Join(err, copyErr)
+			}
+		}
+	case LinkSymlink:
+		if err := os.Symlink(blob, tmp); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// place creates path from blob according to the link mode
+func (s *Store) place(blob, path string) error {
+	switch s.mode {
+	case LinkSymlink:
+		return os.Symlink(blob, path)
+	case LinkHard:
+		if err := os.Link(blob, path); err == nil {
+			return nil
+		}
+	}
+	return copyFile(blob, path)
+}
+
+// copyFile writes a copy of src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// record writes the index entry for a build
+func (s *Store) record(packageID string, version VersionInfo, stats fileStats) error {
+	path, err := s.indexPath(packageID, version.VersionCode)
+	if err != nil {
+		return err
+	}
+	if entry, err := readStoreEntry(path); err == nil && entry.SHA256 == stats.sha256 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(StoreEntry{
+		PackageID:   packageID,
+		VersionCode: version.VersionCode,
+		VersionName: version.VersionName,
+		SHA256:      stats.sha256,
+		Size:        stats.size,
+		AddedAt:     time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// walkIndex calls fn for every readable index entry
+func (s *Store) walkIndex(fn func(path string, entry *StoreEntry) error) error {
+	return filepath.WalkDir(filepath.Join(s.dir, "index"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		entry, err := readStoreEntry(path)
+		if err != nil {
+			// Skip entries another process is still writing
+			return nil
+		}
+		return fn(path, entry)
+	})
+}
+
+// blobPath returns where the blob with the given SHA-256 is kept; blobs
+// are fanned out by their first two hex digits. Digests come from index
+// files and the API, so anything but 64 lowercase hex digits is refused.
+func (s *Store) blobPath(digest string) (string, error) {
+	if !isHexDigest(digest, sha256.Size) {
+		return "", fmt.Errorf("invalid store digest %q", digest)
+	}
+	return filepath.Join(s.dir, "blobs", digest[:2], digest), nil
+}
+
+// indexPath returns the index file of a build
+func (s *Store) indexPath(packageID, versionCode string) (string, error) {
+	for _, part := range []string{packageID, versionCode} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", fmt.Errorf("invalid store key %q", packageID+"#"+versionCode)
+		}
+	}
+	return filepath.Join(s.dir, "index", packageID, versionCode+".json"), nil
+}
+
+// readStoreEntry reads one index file
+func readStoreEntry(path string) (*StoreEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry StoreEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,180 @@
+package apkpure
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreDiscardsBlobsFailingVerification(t *testing.T) {
+	content := testPayload(1000, 1)
+	app := AppInfo{PackageID: "com.example", VersionCode: "10"}
+
+	tests := []struct {
+		name string
+		// tamper damages the stored build before the second download
+		tamper func(t *testing.T, blob string)
+		// checksum is pinned for the second download
+		checksum Checksum
+		wantErr  bool
+	}{
+		{
+			name: "corrupt blob",
+			tamper: func(t *testing.T, blob string) {
+				if err := os.WriteFile(blob, testPayload(1000, 2), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "missing blob",
+			tamper: func(t *testing.T, blob string) {
+				if err := os.Remove(blob); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:     "pinned checksum mismatch",
+			tamper:   func(*testing.T, string) {},
+			checksum: Checksum{Algorithm: ChecksumSHA256, Value: strings.Repeat("0", 64)},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, map[string][]fakeBuild{
+				"com.example": {{name: "1.0", code: "10", content: content}},
+			})
+			store, err := OpenStore(t.TempDir(), LinkCopy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := newTestClient(api, DownloadOptions{Store: store})
+
+			if result := client.DownloadWithResult(app, t.TempDir()); result.Error != nil {
+				t.Fatalf("first download failed: %v", result.Error)
+			}
+			entry, ok := store.Lookup("com.example", "10")
+			if !ok {
+				t.Fatal("the download was not stored")
+			}
+			blob, err := store.blobPath(entry.SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(t, blob)
+
+			pinned := app
+			pinned.Checksum = tt.checksum
+			result := client.DownloadWithResult(pinned, t.TempDir())
+			if got := len(api.downloadRequests()); got != 2 {
+				t.Errorf("got %d downloads, want the build downloaded again", got)
+			}
+
+			if tt.wantErr {
+				var verifyErr *VerificationError
+				if !errors.As(result.Error, &verifyErr) {
+					t.Fatalf("got error %v, want a *VerificationError", result.Error)
+				}
+				if _, ok := store.Lookup("com.example", "10"); ok {
+					t.Error("the index entry of the rejected build was kept")
+				}
+				if _, err := os.Stat(blob); !os.IsNotExist(err) {
+					t.Error("the rejected blob was kept")
+				}
+				return
+			}
+
+			if result.Error != nil {
+				t.Fatalf("second download failed: %v", result.Error)
+			}
+			if result.Skipped {
+				t.Error("the damaged stored copy was used")
+			}
+			stats, err := hashFile(blob)
+			if err != nil || stats.sha256 != entry.SHA256 {
+				t.Errorf("the blob was not replaced by the fresh download")
+			}
+			if _, ok := store.Lookup("com.example", "10"); !ok {
+				t.Error("the fresh download was not stored")
+			}
+		})
+	}
+}
+
+func TestStoreRejectsMalformedDigests(t *testing.T) {
+	root := t.TempDir()
+	victim := filepath.Join(root, "victim.txt")
+	if err := os.WriteFile(victim, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenStore(filepath.Join(root, "store"), LinkCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, digest := range []string{"../victim.txt", "../" + strings.Repeat("0", 61), strings.Repeat("A", 64), strings.Repeat("0", 63)} {
+		if _, err := store.blobPath(digest); err == nil {
+			t.Errorf("blobPath(%q) succeeded", digest)
+		}
+		_, _, ok, err := store.lookupBlob("com.example", VersionInfo{VersionCode: "10", SHA256: digest})
+		if ok || err != nil {
+			t.Errorf("lookupBlob with digest %q = %v, %v; want a miss", digest, ok, err)
+		}
+	}
+
+	// The API's digest never reaches the store, even when the download
+	// fails verification and its stored copy is discarded
+	api := newFakeAPI(t, map[string][]fakeBuild{
+		"com.example": {{name: "1.0", code: "10", content: testPayload(1000, 1), sha256: "../victim.txt"}},
+	})
+	client := newTestClient(api, DownloadOptions{Store: store})
+	checksum := Checksum{Algorithm: ChecksumSHA256, Value: strings.Repeat("0", 64)}
+	result := client.DownloadWithResult(AppInfo{PackageID: "com.example", VersionCode: "10", Checksum: checksum}, t.TempDir())
+	var verifyErr *VerificationError
+	if !errors.As(result.Error, &verifyErr) {
+		t.Fatalf("got error %v, want a *VerificationError", result.Error)
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Errorf("a file outside the store was removed: %v", err)
+	}
+}
+
+func TestStoreRelink(t *testing.T) {
+	for _, mode := range []LinkMode{LinkHard, LinkSymlink} {
+		t.Run(string(mode), func(t *testing.T) {
+			store, err := OpenStore(t.TempDir(), mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blob := filepath.Join(store.Dir(), "blob")
+			path := filepath.Join(t.TempDir(), "app.apk")
+			for _, name := range []string{blob, path} {
+				if err := os.WriteFile(name, []byte("build"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := store.relink(blob, path); err != nil {
+				t.Fatalf("relink: %v", err)
+			}
+			blobInfo, _ := os.Stat(blob)
+			pathInfo, err := os.Stat(path)
+			if err != nil || !os.SameFile(blobInfo, pathInfo) {
+				t.Errorf("%s is not linked to the blob", filepath.Base(path))
+			}
+
+			if mode != LinkHard {
+				return
+			}
+			// A directory can be neither hard-linked nor copied
+			if err := store.relink(store.Dir(), path); err == nil {
+				t.Error("relinking to a blob that can't be linked or copied succeeded")
+			}
+		})
+	}
+}
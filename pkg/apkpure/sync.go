@@ -0,0 +1,334 @@
+package apkpure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyncState records which builds a mirror already holds. It is loaded
+// with LoadSyncState, updated by Client.Sync and written back with Save.
+type SyncState struct {
+	// Apps maps package names to what has been mirrored for them
+	Apps map[string]*SyncedApp `json:"apps"`
+	// UpdatedAt is when the state was last synced
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// SyncedApp is the mirror state of one package
+type SyncedApp struct {
+	// Version is the version constraint the app is tracked with
+	Version string `json:"version,omitempty"`
+	// Builds lists the mirrored builds, newest first
+	Builds []SyncedBuild `json:"builds"`
+}
+
+// SyncedBuild is one mirrored build
+type SyncedBuild struct {
+	VersionCode string    `json:"version_code"`
+	VersionName string    `json:"version_name"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256,omitempty"`
+	ReleasedAt  time.Time `json:"released_at,omitzero"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	// Dir is the directory the build was unpacked into, for XAPKs
+	Dir string `json:"dir,omitempty"`
+}
+
+// RetentionPolicy limits which builds Sync downloads and keeps. Builds
+// must satisfy every rule that is set; with no rules everything is kept.
+type RetentionPolicy struct {
+	// KeepLast keeps only the newest N builds per app (0 keeps all)
+	KeepLast int
+	// KeepSince keeps only builds released on or after this time (zero
+	// keeps all). Builds with no known release date count as within it,
+	// both when downloading and when pruning.
+	KeepSince time.Time
+}
+
+// within reports whether a build released at date falls in the KeepSince
+// window
+func (r RetentionPolicy) within(date time.Time) bool {
+	return r.KeepSince.IsZero() || date.IsZero() || !date.Before(r.KeepSince)
+}
+
+// SyncReport describes what a sync changed
+type SyncReport struct {
+	Apps []SyncChange
+}
+
+// SyncChange describes what a sync changed for one app
+type SyncChange struct {
+	PackageID string
+	// Added lists builds downloaded (or found on disk) in this sync
+	Added []SyncedBuild
+	// Removed lists builds deleted by the retention policy
+	Removed []SyncedBuild
+	// Failed lists builds that could not be downloaded
+	Failed []DownloadResult
+	// Error is set when the app's versions could not be listed
+	Error error
+}
+
+// Errors returns every error in the report, for exit status handling
+func (r *SyncReport) Errors() []error {
+	var errs []error
+	for _, change := range r.Apps {
+		if change.Error != nil {
+			errs = append(errs, change.Error)
+		}
+		for _, failed := range change.Failed {
+			errs = append(errs, failed.Error)
+		}
+	}
+	return errs
+}
+
+// LoadSyncState reads a state file. A missing file yields an empty state.
+func LoadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{Apps: make(map[string]*SyncedApp)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid sync state %s: %w", path, err)
+	}
+	if state.Apps == nil {
+		state.Apps = make(map[string]*SyncedApp)
+	}
+	for packageID := range state.Apps {
+		if err := ValidatePackageID(packageID); err != nil {
+			return nil, fmt.Errorf("invalid sync state %s: %w", path, err)
+		}
+	}
+	return state, nil
+}
+
+// Save writes the state to path, replacing it atomically
+func (s *SyncState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// Sync brings the mirror in outPath up to date. apps are added to the
+// tracked set (their version constraint replaces any stored one); if apps
+// is empty every app already in state is synced. Only builds not yet in
+// state are downloaded, then the retention policy prunes old builds from
+// disk and state.
+func (c *Client) Sync(state *SyncState, apps []AppInfo, outPath string, retention RetentionPolicy) *SyncReport {
+	return c.SyncContext(context.Background(), state, apps, outPath, retention)
+}
+
+// SyncContext is like Sync but stops once ctx is done. Builds that
+// finished downloading are still recorded in state.
+func (c *Client) SyncContext(ctx context.Context, state *SyncState, apps []AppInfo, outPath string, retention RetentionPolicy) *SyncReport {
+	if state.Apps == nil {
+		state.Apps = make(map[string]*SyncedApp)
+	}
+	// Record absolute paths so pruning works from any directory
+	if abs, err := filepath.Abs(outPath); err == nil {
+		outPath = abs
+	}
+
+	// Track the given apps; the last constraint given for a package wins
+	for _, app := range apps {
+		if tracked, ok := state.Apps[app.PackageID]; ok {
+			tracked.Version = app.Version
+		} else {
+			state.Apps[app.PackageID] = &SyncedApp{Version: app.Version, Builds: []SyncedBuild{}}
+		}
+	}
+
+	// Sync each given package once, or every tracked one
+	var packages []string
+	for _, app := range apps {
+		if !slices.Contains(packages, app.PackageID) {
+			packages = append(packages, app.PackageID)
+		}
+	}
+	if len(apps) == 0 {
+		packages = slices.Sorted(maps.Keys(state.Apps))
+	}
+
+	report := &SyncReport{Apps: make([]SyncChange, len(packages))}
+	sem := make(chan struct{}, c.options.Parallel)
+	var wg sync.WaitGroup
+
+	for i, packageID := range packages {
+		wg.Add(1)
+		go func(idx int, tracked *SyncedApp) {
+			defer wg.Done()
+
+			// Each goroutine only reads its own app's state
+			app := AppInfo{PackageID: packages[idx], Version: tracked.Version}
+			report.Apps[idx] = c.syncApp(ctx, sem, app, tracked, outPath, retention)
+		}(i, state.Apps[packageID])
+	}
+	wg.Wait()
+
+	for _, change := range report.Apps {
+		tracked := state.Apps[change.PackageID]
+		tracked.Builds = append(tracked.Builds, change.Added...)
+		tracked.Builds = slices.DeleteFunc(tracked.Builds, func(b SyncedBuild) bool {
+			return slices.ContainsFunc(change.Removed, func(r SyncedBuild) bool { return r.key() == b.key() })
+		})
+		slices.SortStableFunc(tracked.Builds, func(a, b SyncedBuild) int { return compareBuilds(b, a) })
+	}
+	state.UpdatedAt = time.Now().UTC()
+
+	return report
+}
+
+// syncApp lists one app, downloads builds missing from tracked and works
+// out which builds the retention policy removes
+func (c *Client) syncApp(ctx context.Context, sem chan struct{}, app AppInfo, tracked *SyncedApp, outPath string, retention RetentionPolicy) SyncChange {
+	change := SyncChange{PackageID: app.PackageID, Added: []SyncedBuild{}, Removed: []SyncedBuild{}, Failed: []DownloadResult{}}
+
+	if err := acquire(ctx, sem); err != nil {
+		change.Error = err
+		return change
+	}
+	versions, err := c.fetchVersions(ctx, app.PackageID)
+	<-sem
+	if err != nil {
+		change.Error = err
+		return change
+	}
+
+	candidates, err := selectHistory(app, versions, HistoryOptions{})
+	if err != nil {
+		change.Error = err
+		return change
+	}
+	candidates = slices.DeleteFunc(candidates, func(v VersionInfo) bool { return !retention.within(v.releaseDate()) })
+	if retention.KeepLast > 0 && len(candidates) > retention.KeepLast {
+		candidates = candidates[:retention.KeepLast]
+	}
+
+	have := make(map[string]bool, len(tracked.Builds))
+	for _, build := range tracked.Builds {
+		have[build.key()] = true
+	}
+
+	var missing []VersionInfo
+	for _, v := range candidates {
+		if !have[syncKey(v.VersionCode, v.VersionName)] {
+			missing = append(missing, v)
+		}
+	}
+
+	results := make([]DownloadResult, len(missing))
+	var wg sync.WaitGroup
+	for i, version := range missing {
+		wg.Add(1)
+		go func(idx int, version VersionInfo) {
+			defer wg.Done()
+
+			results[idx] = c.acquireAndRun(ctx, sem, app, func() DownloadResult {
+				return c.downloadVersionWithResult(ctx, app, version, outPath)
+			})
+		}(i, version)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if !result.Success {
+			change.Failed = append(change.Failed, result)
+			continue
+		}
+		build := SyncedBuild{
+			VersionCode: result.Version.VersionCode,
+			VersionName: result.Version.VersionName,
+			Path:        result.Path,
+			Size:        result.Size,
+			SHA256:      result.SHA256,
+			ReleasedAt:  result.Version.releaseDate(),
+			FetchedAt:   time.Now().UTC(),
+		}
+		if result.XAPK != nil {
+			build.Dir = result.XAPK.Dir
+		}
+		change.Added = append(change.Added, build)
+	}
+
+	change.Removed = c.prune(slices.Concat(tracked.Builds, change.Added), retention)
+	change.Added = slices.DeleteFunc(change.Added, func(b SyncedBuild) bool {
+		return slices.ContainsFunc(change.Removed, func(r SyncedBuild) bool { return r.key() == b.key() })
+	})
+	return change
+}
+
+// prune deletes the builds the retention policy drops and returns them
+func (c *Client) prune(builds []SyncedBuild, retention RetentionPolicy) []SyncedBuild {
+	slices.SortStableFunc(builds, func(a, b SyncedBuild) int { return compareBuilds(b, a) })
+
+	removed := []SyncedBuild{}
+	for i, build := range builds {
+		if (retention.KeepLast <= 0 || i < retention.KeepLast) && retention.within(build.ReleasedAt) {
+			continue
+		}
+
+		if err := os.Remove(build.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			c.logf("Failed to remove %s: %v\n", build.Path, err)
+			continue
+		}
+		if build.Dir != "" {
+			if err := os.RemoveAll(build.Dir); err != nil {
+				c.logf("Failed to remove %s: %v\n", build.Dir, err)
+			}
+		}
+		removed = append(removed, build)
+	}
+	return removed
+}
+
+// key identifies a build within an app
+func (b SyncedBuild) key() string {
+	return syncKey(b.VersionCode, b.VersionName)
+}
+
+// syncKey identifies a build by versionCode, or by versionName when the
+// API reports no code
+func syncKey(versionCode, versionName string) string {
+	if versionCode != "" {
+		return versionCode
+	}
+	return "name:" + versionName
+}
+
+// compareBuilds orders builds by versionCode, falling back to versionName
+func compareBuilds(a, b SyncedBuild) int {
+	codeA, errA := strconv.ParseInt(a.VersionCode, 10, 64)
+	codeB, errB := strconv.ParseInt(b.VersionCode, 10, 64)
+	if errA == nil && errB == nil && codeA != codeB {
+		if codeA < codeB {
+			return -1
+		}
+		return 1
+	}
+	return CompareVersions(a.VersionName, b.VersionName)
+}
@@ -0,0 +1,111 @@
+package apkpure
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSyncRetention(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	undated := []fakeBuild{
+		{name: "3.0", code: "30", content: testPayload(100, 3)},
+		{name: "2.0", code: "20", content: testPayload(100, 2)},
+		{name: "1.0", code: "10", content: testPayload(100, 1)},
+	}
+	dated := []fakeBuild{
+		{name: "3.0", code: "30", content: testPayload(100, 3), released: "2024-03-01"},
+		{name: "2.0", code: "20", content: testPayload(100, 2), released: "2023-12-31"},
+		{name: "1.0", code: "10", content: testPayload(100, 1), released: "2023-06-01"},
+	}
+
+	tests := []struct {
+		name      string
+		builds    []fakeBuild
+		retention RetentionPolicy
+		// mirrored are builds already in the mirror before the sync
+		mirrored []SyncedBuild
+		// want are the versionCodes held afterwards; removed were pruned
+		want    []string
+		removed []string
+	}{
+		{
+			name:   "no rules",
+			builds: undated,
+			want:   []string{"30", "20", "10"},
+		},
+		{
+			name:      "keep last",
+			builds:    undated,
+			retention: RetentionPolicy{KeepLast: 2},
+			mirrored:  []SyncedBuild{{VersionCode: "10", VersionName: "1.0"}},
+			want:      []string{"30", "20"},
+			removed:   []string{"10"},
+		},
+		{
+			name:      "keep since without dates",
+			builds:    undated,
+			retention: RetentionPolicy{KeepSince: since},
+			want:      []string{"30", "20", "10"},
+		},
+		{
+			name:      "keep since",
+			builds:    dated,
+			retention: RetentionPolicy{KeepSince: since},
+			mirrored:  []SyncedBuild{{VersionCode: "20", VersionName: "2.0", ReleasedAt: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)}},
+			want:      []string{"30"},
+			removed:   []string{"20"},
+		},
+		{
+			name:      "keep since without dates and keep last",
+			builds:    undated,
+			retention: RetentionPolicy{KeepLast: 1, KeepSince: since},
+			want:      []string{"30"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, map[string][]fakeBuild{"com.example": tt.builds})
+			client := newTestClient(api, DownloadOptions{})
+			dir := t.TempDir()
+
+			state := &SyncState{Apps: map[string]*SyncedApp{"com.example": {Builds: []SyncedBuild{}}}}
+			for _, build := range tt.mirrored {
+				build.Path = filepath.Join(dir, "com.example@"+build.VersionName+"_"+build.VersionCode+".apk")
+				if err := os.WriteFile(build.Path, testPayload(100, 9), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				state.Apps["com.example"].Builds = append(state.Apps["com.example"].Builds, build)
+			}
+
+			report := client.Sync(state, nil, dir, tt.retention)
+			if errs := report.Errors(); len(errs) > 0 {
+				t.Fatalf("sync failed: %v", errs)
+			}
+
+			var got []string
+			for _, build := range state.Apps["com.example"].Builds {
+				got = append(got, build.VersionCode)
+				if _, err := os.Stat(build.Path); err != nil {
+					t.Errorf("%s is in the state but not on disk", build.VersionCode)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("holding %v, want %v", got, tt.want)
+			}
+
+			var removed []string
+			for _, build := range report.Apps[0].Removed {
+				removed = append(removed, build.VersionCode)
+				if _, err := os.Stat(build.Path); !os.IsNotExist(err) {
+					t.Errorf("pruned %s is still on disk", build.VersionCode)
+				}
+			}
+			if !slices.Equal(removed, tt.removed) {
+				t.Errorf("removed %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
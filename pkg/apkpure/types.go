@@ -1,6 +1,9 @@
 package apkpure
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // DownloadOptions represents options for downloading APKs
 type DownloadOptions struct {
@@ -18,22 +21,80 @@ type DownloadOptions struct {
 	OutputFormat string
 	// Progress callback
 	ProgressCallback func(filename string, downloaded, total int64)
+	// Retry policy for API calls and downloads (nil uses DefaultRetryPolicy)
+	Retry *RetryPolicy
+	// Verify APK signatures (v1/v2/v3) after downloading
+	VerifySignature bool
+	// Pinned signer certificate SHA-256 digests by package name. A
+	// download fails unless one of its signers matches; packages with a
+	// pin are always signature-verified.
+	PinnedCertificates map[string][]string
+	// Unpack downloaded XAPKs into a directory next to the file
+	UnpackXAPK bool
+	// Skip checking the downloaded AndroidManifest.xml against the
+	// requested package and version
+	SkipManifestCheck bool
+	// Cache stores version API responses (nil disables caching)
+	Cache MetadataCache
+	// CacheTTL is how long a cached listing is used without asking the
+	// API; older entries are revalidated with ETag/If-Modified-Since
+	CacheTTL time.Duration
+	// Offline answers version lookups from Cache only, failing with
+	// ErrNotCached for packages that were never cached
+	Offline bool
+	// Store keeps downloads in a shared content-addressed store and links
+	// them into the output directory (nil disables the store)
+	Store *Store
+	// APIBaseURL is the root of the APKPure API (default
+	// DefaultAPIBaseURL), e.g. a mirror or a local fake server
+	APIBaseURL string
 }
 
 // AppInfo represents an app to download
 type AppInfo struct {
 	// Package name (e.g., "com.instagram.android")
 	PackageID string
-	// Version (optional, e.g., "1.2.3")
+	// Version (optional): an exact versionName such as "1.2.3" or a
+	// constraint such as ">=150 <160"; see ParseVersionConstraint
 	Version string
+	// VersionCode (optional) selects the build with exactly this
+	// versionCode, for vendors that ship several builds under one name
+	VersionCode string
+	// Checksum the downloaded file must match (optional)
+	Checksum Checksum
 }
 
-// VersionInfo represents a version of an app
+// VersionInfo represents a version of an app. Besides the name, code and
+// download URL, the metadata fields are filled in when the API provides them.
 type VersionInfo struct {
-	VersionName string
-	VersionCode string
-	APKType     string // "APK" or "XAPK"
-	DownloadURL string
+	VersionName string `json:"version_name"`
+	VersionCode string `json:"version_code"`
+	APKType     string `json:"apk_type"` // "APK" or "XAPK"
+	DownloadURL string `json:"download_url"`
+
+	Title      string    `json:"title,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	SHA1       string    `json:"sha1,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`
+	MD5        string    `json:"md5,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitzero"`
+	ReleasedAt time.Time `json:"released_at,omitzero"`
+	MinSDK     int       `json:"min_sdk,omitempty"`
+	TargetSDK  int       `json:"target_sdk,omitempty"`
+	ABIs       []string  `json:"abis,omitempty"`
+	Signatures []string  `json:"signatures,omitempty"`
+	Changelog  string    `json:"changelog,omitempty"`
+	// Extra holds API fields not mapped above, as raw JSON. Asset fields
+	// are prefixed with "asset."
+	Extra map[string]json.RawMessage `json:"extra,omitempty"`
+}
+
+// VersionListing holds the versions available for a single package
+type VersionListing struct {
+	PackageID string
+	Versions  []VersionInfo
+	// Error is set when the versions could not be fetched
+	Error error
 }
 
 // DownloadResult represents the result of a download operation
@@ -41,17 +102,35 @@ type DownloadResult struct {
 	AppInfo  AppInfo
 	Filename string
 	Success  bool
-	Error    error
+	// Skipped is set when the build was already on disk or in the store
+	// and was not downloaded again
+	Skipped bool
+	Error   error
+	// Version is the version that was resolved for AppInfo
+	Version VersionInfo
+	// Path is the location of the downloaded file
+	Path string
+	// Size is the number of bytes written
+	Size int64
+	// SHA256, SHA1 and MD5 are the hex-encoded digests of the file
+	SHA256 string
+	SHA1   string
+	MD5    string
+	// ChecksumsVerified lists the algorithms whose expected digest (from
+	// AppInfo.Checksum or the version API) was checked and matched
+	ChecksumsVerified []string
+	// Duration is how long the download took, including retries
+	Duration time.Duration
+	// Signature is set when the APK signature was verified
+	Signature *SignatureInfo
+	// Manifest is the parsed AndroidManifest.xml of the APK (the base APK
+	// for XAPKs), unless the manifest check was skipped
+	Manifest *ManifestInfo
+	// XAPK is set when an XAPK was unpacked
+	XAPK *XAPKInfo
 }
 
 // APIResponse represents the API response from APKPure
 type APIResponse struct {
-	VersionList []struct {
-		VersionName string `json:"version_name"`
-		VersionCode string `json:"version_code"`
-		Asset       struct {
-			URL  string `json:"url"`
-			Type string `json:"type"`
-		} `json:"asset"`
-	} `json:"version_list"`
+	VersionList []APIVersion `json:"version_list"`
 }
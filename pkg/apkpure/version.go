@@ -0,0 +1,315 @@
+package apkpure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// VersionConstraint selects versions from a version listing. It is parsed
+// from the AppInfo.Version syntax:
+//
+//	""  or latest     the newest version the API lists
+//	1.2.3             exactly this versionName (or versionCode if only digits
+//	                  and no name matches)
+//	>=150 <160        every comparison must hold; also >, <=, = and !=
+//	~1.2              >=1.2 and below the next minor (1.2.x)
+//	150.*             versions whose name starts with the segments 150
+//	<150.0.0.33.120   the newest release older than the given version
+//
+// Versions are compared segment by segment with CompareVersions.
+type VersionConstraint struct {
+	raw    string
+	latest bool
+	exact  string
+	terms  []versionTerm
+}
+
+// versionTerm is a single comparison or prefix match
+type versionTerm struct {
+	op      string
+	version string
+	// prefix is set for ~ and wildcard terms
+	prefix []string
+}
+
+// ParseVersionConstraint parses a version constraint expression
+func ParseVersionConstraint(spec string) (*VersionConstraint, error) {
+	spec = strings.TrimSpace(spec)
+	c := &VersionConstraint{raw: spec}
+
+	if spec == "" || strings.EqualFold(spec, "latest") {
+		c.latest = true
+		return c, nil
+	}
+	if !strings.ContainsAny(spec, "<>=!~* ,") {
+		c.exact = spec
+		return c, nil
+	}
+
+	for _, field := range strings.FieldsFunc(spec, func(r rune) bool { return r == ' ' || r == ',' }) {
+		term, err := parseVersionTerm(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %w", spec, err)
+		}
+		c.terms = append(c.terms, term)
+	}
+	if len(c.terms) == 0 {
+		return nil, fmt.Errorf("invalid version constraint %q", spec)
+	}
+
+	return c, nil
+}
+
+// parseVersionTerm parses one whitespace-separated part of a constraint
+func parseVersionTerm(field string) (versionTerm, error) {
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "=", "~"} {
+		version, ok := strings.CutPrefix(field, op)
+		if !ok {
+			continue
+		}
+		if version == "" {
+			return versionTerm{}, fmt.Errorf("missing version after %s", op)
+		}
+		if strings.Contains(version, "*") {
+			return versionTerm{}, fmt.Errorf("wildcard not allowed after %s", op)
+		}
+		if strings.ContainsAny(version[:1], "<>=!~") {
+			return versionTerm{}, fmt.Errorf("unsupported term %q", field)
+		}
+
+		term := versionTerm{op: op, version: version}
+		if op == "~" {
+			// ~1.2 allows 1.2.x, ~1.2.3 allows 1.2.x from 1.2.3 on
+			segments := versionSegments(version)
+			if len(segments) > 2 {
+				segments = segments[:len(segments)-1]
+			}
+			term.prefix = segments
+		}
+		return term, nil
+	}
+
+	if field == "*" {
+		return versionTerm{op: "*"}, nil
+	}
+	prefix, ok := strings.CutSuffix(field, ".*")
+	if !ok {
+		return versionTerm{}, fmt.Errorf("unsupported term %q", field)
+	}
+	if strings.Contains(prefix, "*") {
+		return versionTerm{}, fmt.Errorf("wildcard must be the last segment in %q", field)
+	}
+
+	return versionTerm{op: "*", prefix: versionSegments(prefix)}, nil
+}
+
+// String returns the expression the constraint was parsed from
+func (c *VersionConstraint) String() string {
+	if c.latest {
+		return "latest"
+	}
+	return c.raw
+}
+
+// Match reports whether v satisfies the constraint. A latest constraint
+// matches every version. As in Select, an exact constraint made only of
+// digits also matches the build with that versionCode.
+func (c *VersionConstraint) Match(v VersionInfo) bool {
+	if c.latest {
+		return true
+	}
+	if c.exact != "" {
+		return v.VersionName == c.exact || (IsVersionCode(c.exact) && v.VersionCode == c.exact)
+	}
+
+	for _, term := range c.terms {
+		if !term.match(v.VersionName) {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether the versionName satisfies one term
+func (t versionTerm) match(name string) bool {
+	switch t.op {
+	case ">=":
+		return CompareVersions(name, t.version) >= 0
+	case "<=":
+		return CompareVersions(name, t.version) <= 0
+	case ">":
+		return CompareVersions(name, t.version) > 0
+	case "<":
+		return CompareVersions(name, t.version) < 0
+	case "=":
+		return CompareVersions(name, t.version) == 0
+	case "!=":
+		return CompareVersions(name, t.version) != 0
+	case "~":
+		return CompareVersions(name, t.version) >= 0 && hasSegmentPrefix(name, t.prefix)
+	default:
+		return hasSegmentPrefix(name, t.prefix)
+	}
+}
+
+// Select picks the version the constraint resolves to from a listing in
+// API order (newest first), or nil if none matches
+func (c *VersionConstraint) Select(versions []VersionInfo) *VersionInfo {
+	if len(versions) == 0 {
+		return nil
+	}
+	if c.latest {
+		return &versions[0]
+	}
+
+	if c.exact != "" {
+		for i := range versions {
+			if versions[i].VersionName == c.exact {
+				return &versions[i]
+			}
+		}
+		if IsVersionCode(c.exact) {
+			for i := range versions {
+				if versions[i].VersionCode == c.exact {
+					return &versions[i]
+				}
+			}
+		}
+		return nil
+	}
+
+	var best *VersionInfo
+	for i := range versions {
+		if !c.Match(versions[i]) {
+			continue
+		}
+		if best == nil || compareVersionInfo(versions[i], *best) > 0 {
+			best = &versions[i]
+		}
+	}
+	return best
+}
+
+// compareVersionInfo orders two listed versions by versionName, falling
+// back to versionCode for equal names
+func compareVersionInfo(a, b VersionInfo) int {
+	if cmp := CompareVersions(a.VersionName, b.VersionName); cmp != 0 {
+		return cmp
+	}
+	codeA, _ := strconv.ParseInt(a.VersionCode, 10, 64)
+	codeB, _ := strconv.ParseInt(b.VersionCode, 10, 64)
+	switch {
+	case codeA < codeB:
+		return -1
+	case codeA > codeB:
+		return 1
+	}
+	return 0
+}
+
+// CompareVersions compares two Android versionNames and returns -1, 0 or
+// 1. Names are split into runs of digits and letters, so "150.0.0.33.120",
+// "2.1b" and "1.0-beta2" all compare sensibly: numeric runs compare by
+// value, letter runs case-insensitively, and missing trailing segments
+// count as zero ("1.2" equals "1.2.0"). A letter run where the other
+// version has ended marks a pre-release, so "1.0-beta" sorts before "1.0".
+func CompareVersions(a, b string) int {
+	segA, segB := versionSegments(a), versionSegments(b)
+
+	for i := 0; i < len(segA) || i < len(segB); i++ {
+		var x, y string
+		if i < len(segA) {
+			x = segA[i]
+		}
+		if i < len(segB) {
+			y = segB[i]
+		}
+
+		switch {
+		case x == "" && !isNumericSegment(y):
+			return 1
+		case y == "" && !isNumericSegment(x):
+			return -1
+		}
+		if x == "" {
+			x = "0"
+		}
+		if y == "" {
+			y = "0"
+		}
+
+		if cmp := compareSegment(x, y); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareSegment compares two version segments. Numbers sort before
+// letters, matching the usual 1.0.1 > 1.0.a ordering of pre-releases.
+func compareSegment(x, y string) int {
+	xNum, yNum := isNumericSegment(x), isNumericSegment(y)
+	switch {
+	case xNum && yNum:
+		x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+		if len(x) != len(y) {
+			if len(x) < len(y) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(x, y)
+	case xNum:
+		return 1
+	case yNum:
+		return -1
+	default:
+		return strings.Compare(strings.ToLower(x), strings.ToLower(y))
+	}
+}
+
+// versionSegments splits a version into runs of digits and letters,
+// dropping separators
+func versionSegments(version string) []string {
+	var segments []string
+	start := -1
+	var digits bool
+	for i, r := range version {
+		isDigit := r >= '0' && r <= '9'
+		isLetter := unicode.IsLetter(r)
+		if start >= 0 && (!(isDigit || isLetter) || isDigit != digits) {
+			segments = append(segments, version[start:i])
+			start = -1
+		}
+		if start < 0 && (isDigit || isLetter) {
+			start, digits = i, isDigit
+		}
+	}
+	if start >= 0 {
+		segments = append(segments, version[start:])
+	}
+	return segments
+}
+
+// hasSegmentPrefix reports whether version starts with the given segments
+func hasSegmentPrefix(version string, prefix []string) bool {
+	segments := versionSegments(version)
+	if len(segments) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if compareSegment(segments[i], p) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isNumericSegment reports whether a segment from versionSegments is a
+// number; segments are runs of either digits or letters, so the first
+// byte decides
+func isNumericSegment(segment string) bool {
+	return segment != "" && '0' <= segment[0] && segment[0] <= '9'
+}
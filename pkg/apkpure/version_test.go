@@ -0,0 +1,154 @@
+package apkpure
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		spec string
+		// wantErr is a substring of the expected error, or "" for success
+		wantErr string
+	}{
+		{spec: ""},
+		{spec: "latest"},
+		{spec: "LATEST"},
+		{spec: "1.2.3"},
+		{spec: "150"},
+		{spec: ">=150 <160"},
+		{spec: ">=150, <160"},
+		{spec: "~1.2"},
+		{spec: "~1.2.3"},
+		{spec: "150.*"},
+		{spec: "*"},
+		{spec: "!=1.0 >0.9"},
+		{spec: ">=", wantErr: "missing version after >="},
+		{spec: ">=150 <", wantErr: "missing version after <"},
+		{spec: "~", wantErr: "missing version after ~"},
+		{spec: ">=1.*", wantErr: "wildcard not allowed after >="},
+		{spec: "~1.*", wantErr: "wildcard not allowed after ~"},
+		{spec: "1.*.3", wantErr: "unsupported term"},
+		{spec: "*.*", wantErr: "wildcard must be the last segment"},
+		{spec: "150*", wantErr: "unsupported term"},
+		{spec: "=>150", wantErr: "unsupported term"},
+		{spec: ">=<150", wantErr: "unsupported term"},
+		{spec: "1.2 1.3", wantErr: "unsupported term"},
+		{spec: ",", wantErr: "invalid version constraint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			c, err := ParseVersionConstraint(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := strings.TrimSpace(tt.spec); want != "" && !strings.EqualFold(want, "latest") && c.String() != want {
+				t.Errorf("String() = %q, want %q", c.String(), want)
+			}
+		})
+	}
+}
+
+func TestVersionConstraintSelect(t *testing.T) {
+	// In API order, newest first
+	versions := []VersionInfo{
+		{VersionName: "161.0.0.1", VersionCode: "1610"},
+		{VersionName: "160.0", VersionCode: "1600"},
+		{VersionName: "159.2.0", VersionCode: "1592"},
+		{VersionName: "150.0.0.33.120", VersionCode: "1500"},
+		{VersionName: "149.9", VersionCode: "1499"},
+		{VersionName: "1.3.0", VersionCode: "130"},
+		{VersionName: "1.2.10", VersionCode: "1210"},
+		{VersionName: "1.2.9", VersionCode: "129"},
+		{VersionName: "1.2.0-beta", VersionCode: "119"},
+	}
+
+	tests := []struct {
+		spec string
+		// want is the versionCode selected, or "" for no match
+		want string
+	}{
+		{spec: "", want: "1610"},
+		{spec: "latest", want: "1610"},
+		{spec: "159.2.0", want: "1592"},
+		{spec: "129", want: "129"},
+		{spec: "1.2", want: ""},
+		{spec: ">=150 <160", want: "1592"},
+		{spec: ">=150, <=160", want: "1600"},
+		{spec: ">150.0.0.33.120 <159", want: ""},
+		{spec: "<150.0.0.33.120", want: "1499"},
+		{spec: "!=161.0.0.1 >=150", want: "1600"},
+		{spec: "~1.2", want: "1210"},
+		{spec: "~1.2.10", want: "1210"},
+		{spec: "~1.3", want: "130"},
+		{spec: "~2.0", want: ""},
+		{spec: "150.*", want: "1500"},
+		{spec: "1.2.*", want: "1210"},
+		{spec: "1.*", want: "130"},
+		{spec: "15.*", want: ""},
+		{spec: "*", want: "1610"},
+		{spec: "<1.2", want: "119"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			c, err := ParseVersionConstraint(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.Select(versions)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("selected nothing, want %s", tt.want)
+			case got != nil && got.VersionCode != tt.want:
+				t.Errorf("selected %s (%s), want %q", got.VersionCode, got.VersionName, tt.want)
+			}
+
+			// --all-versions filters with Match; it must agree with Select
+			matched := slices.IndexFunc(versions, c.Match) >= 0
+			if matched != (got != nil) || got != nil && !c.Match(*got) {
+				t.Errorf("Match disagrees with Select (matched any: %v)", matched)
+			}
+		})
+	}
+
+	if c, _ := ParseVersionConstraint("latest"); c.Select(nil) != nil {
+		t.Errorf("latest selected a version from an empty listing")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2", "1.2.0", 0},
+		{"1.10", "1.9", 1},
+		{"150.0.0.33.120", "150", 1},
+		{"160.0", "160", 0},
+		{"2.1b", "2.1a", 1},
+		{"1.0-beta", "1.0", -1},
+		{"1.0-beta2", "1.0-beta10", -1},
+		{"1.0.1", "1.0.a", 1},
+		{"1.0-RC1", "1.0-rc1", 0},
+		{"007", "7", 0},
+		{"1.123456789012345678901", "1.99", 1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
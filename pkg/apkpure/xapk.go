@@ -0,0 +1,249 @@
+package apkpure
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// xapkManifestName is the metadata file at the root of an XAPK
+const xapkManifestName = "manifest.json"
+
+// maxUnpackSize bounds how many bytes an XAPK may unpack to, so a crafted
+// archive can't fill the disk; real XAPKs, OBB files included, stay far
+// below it
+const maxUnpackSize = 16 << 30
+
+// XAPKInfo summarizes an unpacked XAPK
+type XAPKInfo struct {
+	PackageName string `json:"package_name"`
+	VersionCode string `json:"version_code"`
+	VersionName string `json:"version_name,omitempty"`
+	// Dir is the directory the XAPK was unpacked into
+	Dir string `json:"dir"`
+	// BaseAPK is the path of the base APK
+	BaseAPK string `json:"base_apk"`
+	// Splits lists the configuration split APKs
+	Splits []XAPKSplit `json:"splits"`
+	// OBBFiles lists the extracted expansion files
+	OBBFiles []string `json:"obb_files"`
+}
+
+// XAPKSplit is a split APK extracted from an XAPK
+type XAPKSplit struct {
+	// Name is the split name from the manifest (e.g. "config.arm64_v8a")
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// xapkManifest is the subset of manifest.json we use
+type xapkManifest struct {
+	PackageName string
+	VersionCode string
+	VersionName string
+	SplitAPKs   []struct {
+		File string `json:"file"`
+		ID   string `json:"id"`
+	}
+}
+
+// UnmarshalJSON decodes manifest.json, accepting version_code as either a
+// string or a number
+func (m *xapkManifest) UnmarshalJSON(data []byte) error {
+	var fields rawFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	m.PackageName = fields.str("package_name")
+	m.VersionCode = fields.str("version_code")
+	m.VersionName = fields.str("version_name")
+	if raw, ok := fields["split_apks"]; ok {
+		if err := json.Unmarshal(raw, &m.SplitAPKs); err != nil {
+			return fmt.Errorf("invalid split_apks: %w", err)
+		}
+	}
+	return nil
+}
+
+// UnpackXAPK extracts the base APK, split APKs and OBB files of the XAPK
+// at xapkPath into destDir. APKs are written to destDir/apks and OBB files
+// keep their Android/obb/... path. Entries that would land outside destDir
+// or on the same file as another entry are rejected, as are archives
+// unpacking to more than 16 GiB.
+func UnpackXAPK(xapkPath, destDir string) (*XAPKInfo, error) {
+	return unpackXAPK(xapkPath, destDir, maxUnpackSize)
+}
+
+// unpackXAPK is UnpackXAPK with the size limit given
+func unpackXAPK(xapkPath, destDir string, limit int64) (*XAPKInfo, error) {
+	zr, err := zip.OpenReader(xapkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XAPK: %w", err)
+	}
+	defer func() { _ = zr.Close() }()
+
+	var manifest xapkManifest
+	for _, f := range zr.File {
+		if f.Name != xapkManifestName {
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid XAPK %s: %w", xapkManifestName, err)
+		}
+		break
+	}
+	if manifest.PackageName == "" {
+		return nil, fmt.Errorf("XAPK has no %s with a package name", xapkManifestName)
+	}
+
+	// Map APK file names to their split IDs
+	splitIDs := make(map[string]string, len(manifest.SplitAPKs))
+	for _, split := range manifest.SplitAPKs {
+		splitIDs[split.File] = split.ID
+	}
+
+	absDest, err := filepath.Abs(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &XAPKInfo{
+		PackageName: manifest.PackageName,
+		VersionCode: manifest.VersionCode,
+		VersionName: manifest.VersionName,
+		Dir:         absDest,
+		Splits:      []XAPKSplit{},
+		OBBFiles:    []string{},
+	}
+
+	// sources maps each extracted file to its entry, as APKs from
+	// different directories are flattened into apks/
+	sources := make(map[string]string)
+	budget := limit
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		var target string
+		switch {
+		case strings.HasPrefix(f.Name, "Android/obb/"):
+			target = f.Name
+		case strings.HasSuffix(strings.ToLower(f.Name), ".apk"):
+			target = path.Join("apks", path.Base(f.Name))
+		default:
+			continue
+		}
+
+		outPath, err := safeJoin(absDest, target)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := sources[outPath]; ok {
+			return nil, fmt.Errorf("XAPK entries %s and %s both unpack to %s", other, f.Name, target)
+		}
+		sources[outPath] = f.Name
+		if err := extractZipFile(f, outPath, &budget); err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(f.Name, "Android/obb/") {
+			info.OBBFiles = append(info.OBBFiles, outPath)
+			continue
+		}
+
+		id, listed := splitIDs[f.Name]
+		switch {
+		case id == "base" || (!listed && path.Base(f.Name) == manifest.PackageName+".apk"):
+			info.BaseAPK = outPath
+		case listed:
+			info.Splits = append(info.Splits, XAPKSplit{Name: id, Path: outPath})
+		default:
+			info.Splits = append(info.Splits, XAPKSplit{Name: strings.TrimSuffix(path.Base(f.Name), ".apk"), Path: outPath})
+		}
+	}
+
+	// Older XAPKs hold a single APK without split metadata
+	if info.BaseAPK == "" && len(info.Splits) == 1 && len(manifest.SplitAPKs) == 0 {
+		info.BaseAPK = info.Splits[0].Path
+		info.Splits = info.Splits[:0]
+	}
+	if info.BaseAPK == "" {
+		return nil, errors.New("XAPK has no base APK")
+	}
+
+	return info, nil
+}
+
+// safeJoin joins a slash-separated archive path onto dir, refusing paths
+// that are absolute or climb out of dir (zip-slip)
+func safeJoin(dir, name string) (string, error) {
+	if path.IsAbs(name) || strings.Contains(name, `\`) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("illegal path in archive: %s", name)
+		}
+	}
+
+	joined := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	return joined, nil
+}
+
+// extractZipFile writes a zip entry to outPath as a regular file, taking
+// its size from budget; it fails once the budget is exhausted
+func extractZipFile(f *zip.File, outPath string, budget *int64) (err error) {
+	if !f.Mode().IsRegular() {
+		return fmt.Errorf("refusing to extract non-regular file: %s", f.Name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+
+	outFile, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := outFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(outPath)
+		}
+	}()
+
+	// The declared size can lie, so count what is actually written
+	n, err := io.CopyN(outFile, rc, *budget+1)
+	*budget -= n
+	if *budget < 0 {
+		return fmt.Errorf("unpack size limit exceeded by %s", f.Name)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package apkpure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testXAPKManifest = `{
+  "package_name": "com.example.app",
+  "version_code": 150,
+  "version_name": "1.5.0",
+  "split_apks": [
+    {"file": "com.example.app.apk", "id": "base"},
+    {"file": "config.arm64_v8a.apk", "id": "config.arm64_v8a"}
+  ]
+}`
+
+// writeXAPK writes a stored zip of entries and returns its path
+func writeXAPK(t *testing.T, entries ...jarEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.xapk")
+	if err := os.WriteFile(path, buildZip(t, entries), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUnpackXAPK(t *testing.T) {
+	xapk := writeXAPK(t,
+		jarEntry{name: xapkManifestName, data: []byte(testXAPKManifest)},
+		jarEntry{name: "com.example.app.apk", data: []byte("base")},
+		jarEntry{name: "config.arm64_v8a.apk", data: []byte("split")},
+		jarEntry{name: "Android/obb/com.example.app/main.150.com.example.app.obb", data: []byte("obb")},
+		jarEntry{name: "icon.png", data: []byte("png")},
+	)
+	dest := t.TempDir()
+
+	info, err := UnpackXAPK(xapk, dest)
+	if err != nil {
+		t.Fatalf("UnpackXAPK: %v", err)
+	}
+	if info.PackageName != "com.example.app" || info.VersionCode != "150" || info.VersionName != "1.5.0" {
+		t.Errorf("got %s %s (%s)", info.PackageName, info.VersionName, info.VersionCode)
+	}
+	files := map[string]string{
+		info.BaseAPK: "base",
+		filepath.Join(dest, "apks", "config.arm64_v8a.apk"):                                      "split",
+		filepath.Join(dest, "Android", "obb", "com.example.app", "main.150.com.example.app.obb"): "obb",
+	}
+	for path, want := range files {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s holds %q, %v; want %q", path, data, err, want)
+		}
+	}
+	if len(info.Splits) != 1 || info.Splits[0].Name != "config.arm64_v8a" {
+		t.Errorf("got splits %+v", info.Splits)
+	}
+	if len(info.OBBFiles) != 1 {
+		t.Errorf("got OBB files %v", info.OBBFiles)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "icon.png")); err == nil {
+		t.Error("an unrelated entry was extracted")
+	}
+}
+
+func TestUnpackXAPKRejects(t *testing.T) {
+	manifest := jarEntry{name: xapkManifestName, data: []byte(testXAPKManifest)}
+	base := jarEntry{name: "com.example.app.apk", data: []byte("base")}
+
+	tests := []struct {
+		name    string
+		entries []jarEntry
+		limit   int64
+		wantErr string
+	}{
+		{
+			name:    "no manifest",
+			entries: []jarEntry{base},
+			wantErr: "no manifest.json",
+		},
+		{
+			name:    "no base APK",
+			entries: []jarEntry{manifest, {name: "config.arm64_v8a.apk", data: []byte("split")}, {name: "config.en.apk", data: []byte("split")}},
+			wantErr: "no base APK",
+		},
+		{
+			name:    "zip-slip through OBB path",
+			entries: []jarEntry{manifest, base, {name: "Android/obb/../../escaped.obb", data: []byte("x")}},
+			wantErr: "illegal path",
+		},
+		{
+			name:    "same APK name in two folders",
+			entries: []jarEntry{manifest, base, {name: "a/config.en.apk", data: []byte("1")}, {name: "b/config.en.apk", data: []byte("2")}},
+			wantErr: "both unpack to",
+		},
+		{
+			name:    "too large",
+			entries: []jarEntry{manifest, base, {name: "config.en.apk", data: []byte(strings.Repeat("x", 100))}},
+			limit:   64,
+			wantErr: "size limit exceeded by config.en.apk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			if limit == 0 {
+				limit = maxUnpackSize
+			}
+			dest := filepath.Join(t.TempDir(), "out")
+			_, err := unpackXAPK(writeXAPK(t, tt.entries...), dest, limit)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(dest, "..", "escaped.obb")); err == nil {
+				t.Error("an entry escaped the target directory")
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{name: "apks/base.apk", ok: true},
+		{name: "Android/obb/com.example/main.obb", ok: true},
+		{name: "Android/obb/../../escaped", ok: false},
+		{name: "../escaped", ok: false},
+		{name: "apks/..", ok: false},
+		{name: "/etc/passwd", ok: false},
+		{name: `apks\..\..\escaped`, ok: false},
+	}
+	for _, tt := range tests {
+		joined, err := safeJoin(dir, tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("safeJoin(%q) = %q, %v; want ok %v", tt.name, joined, err, tt.ok)
+		}
+	}
+}